@@ -12,6 +12,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog"
 	"gitlab.com/ranfdev/discepto/internal/db"
+	"gitlab.com/ranfdev/discepto/internal/mail"
 	"gitlab.com/ranfdev/discepto/internal/models"
 	"gitlab.com/ranfdev/discepto/internal/render"
 	"gitlab.com/ranfdev/discepto/internal/routes"
@@ -21,7 +22,7 @@ import (
 const usage = `Usage:
 	- start
 	- migrate [up/down]
-`
+	- spam retrain`
 
 func main() {
 	if len(os.Args) == 1 {
@@ -52,6 +53,22 @@ func main() {
 			return
 		}
 		fmt.Println("Done")
+	case "spam":
+		if len(os.Args) < 3 || os.Args[2] != "retrain" {
+			fmt.Println(usage)
+			return
+		}
+		database, err := db.Connect(&envConfig)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		samples, err := database.RetrainSpamModel(context.Background())
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Done, trained on %d samples\n", samples)
 	default:
 		fmt.Println(usage)
 	}
@@ -65,6 +82,15 @@ type DisceptoServer struct {
 	httpServer *http.Server
 	database   db.SharedDB
 	templates  render.Templates
+	jobs       []backgroundJob
+	stopJobs   context.CancelFunc
+}
+
+// A task executed periodically while the server is running
+type backgroundJob struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
 }
 
 func (ds *DisceptoServer) setupLogger() {
@@ -83,7 +109,7 @@ func (ds *DisceptoServer) setupTemplates() {
 	ds.templates.SetFS(web.FS)
 }
 func (ds *DisceptoServer) setupRouter() {
-	ds.router = routes.NewRouter(&ds.EnvConfig, &ds.database, ds.logger, &ds.templates)
+	ds.router = routes.NewRouter(&ds.EnvConfig, &ds.database, ds.logger, &ds.templates, mail.New(&ds.EnvConfig))
 }
 func (ds *DisceptoServer) setupDB() {
 	err := db.MigrateUp(ds.DatabaseURL)
@@ -105,14 +131,49 @@ func (ds *DisceptoServer) setupHTTPServer() {
 		WriteTimeout: 1 * time.Minute,
 	}
 }
+func (ds *DisceptoServer) setupJobs() {
+	ds.jobs = []backgroundJob{
+		{"detect_vote_rings", 15 * time.Minute, ds.database.DetectVoteRings},
+		{"award_badges", 1 * time.Hour, ds.database.AwardBadges},
+		{"delete_expired_roles", 15 * time.Minute, ds.database.DeleteExpiredRoles},
+		{"purge_removed_essays", 1 * time.Hour, func(ctx context.Context) error {
+			return ds.database.PurgeRemovedEssays(ctx, ds.EnvConfig.RemovedRetentionDays)
+		}},
+	}
+}
 func (ds *DisceptoServer) Setup() {
 	ds.setupLogger()
 	ds.setupTemplates()
 	ds.setupRouter()
 	ds.setupDB()
 	ds.setupHTTPServer()
+	ds.setupJobs()
+}
+func (ds *DisceptoServer) runJobs() {
+	ctx, cancel := context.WithCancel(context.Background())
+	ds.stopJobs = cancel
+	for _, job := range ds.jobs {
+		go func(job backgroundJob) {
+			ticker := time.NewTicker(job.interval)
+			defer ticker.Stop()
+			for {
+				if err := job.run(ctx); err != nil && ctx.Err() == nil {
+					ds.logger.Error().
+						Err(err).
+						Str("job", job.name).
+						Msg("Error running background job")
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(job)
+	}
 }
 func (ds *DisceptoServer) Shutdown() {
+	ds.stopJobs()
 	if err := ds.httpServer.Shutdown(context.Background()); err != nil {
 		ds.logger.Error().
 			Err(err).
@@ -128,6 +189,7 @@ func (ds *DisceptoServer) Run() {
 		ds.logger.Info().Msg("Shutting down gracefully")
 		ds.Shutdown()
 	}()
+	ds.runJobs()
 	ds.logger.Info().Str("server_address", ds.addr).Msg("Server listening")
 	if err := ds.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		ds.logger.Fatal().
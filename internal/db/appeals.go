@@ -0,0 +1,266 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+var selectAppeals = psql.
+	Select(
+		"appeals.id",
+		"appeals.ban_id",
+		"appeals.essay_id",
+		"subdisceptos.name AS subdiscepto",
+		"appeals.user_id",
+		"users.name AS user_name",
+		"actors.name AS acted_by_name",
+		"appeals.reason",
+		"appeals.statement",
+		"appeals.created_at",
+		"reviewers.name AS reviewed_by_name",
+		"appeals.reviewed_at",
+		"appeals.outcome",
+		"appeals.response",
+	).
+	From("appeals").
+	Join("users ON users.id = appeals.user_id").
+	LeftJoin("users AS actors ON actors.id = appeals.acted_by").
+	LeftJoin("users AS reviewers ON reviewers.id = appeals.reviewed_by").
+	LeftJoin("subdisceptos ON subdisceptos.roledomain_id = appeals.domain").
+	// Pending appeals first
+	OrderBy("appeals.reviewed_at DESC NULLS FIRST", "appeals.created_at DESC")
+
+func listAppeals(ctx context.Context, db DBTX, where sq.Sqlizer) ([]models.Appeal, error) {
+	sql, args, _ := selectAppeals.Where(where).ToSql()
+
+	appeals := []models.Appeal{}
+	err := pgxscan.Select(ctx, db, &appeals, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return appeals, nil
+}
+
+// fileAppeal stores an appeal, unless the same sanction is already being appealed
+func fileAppeal(ctx context.Context, db DBTX, column string, id int, domain models.RoleDomain, userID int, actedBy *int, reason string, req models.AppealReq) error {
+	if len(req.Statement) == 0 || len(req.Statement) > 2000 {
+		return models.ErrInvalidAppeal
+	}
+	conflict := fmt.Sprintf("ON CONFLICT (%s) DO NOTHING", column)
+	if column == "essay_id" {
+		// Only open appeals of essays are unique
+		conflict = "ON CONFLICT (essay_id) WHERE reviewed_at IS NULL DO NOTHING"
+	}
+	sql, args, _ := psql.
+		Insert("appeals").
+		Columns(column, "domain", "user_id", "acted_by", "reason", "statement").
+		Values(id, domain, userID, actedBy, reason, req.Statement).
+		Suffix(conflict).
+		ToSql()
+
+	tag, err := db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return models.ErrAppealExists
+	}
+	return nil
+}
+
+// AppealBan contests one of the active bans of the user
+func (h *DisceptoH) AppealBan(ctx context.Context, userH *UserH, banID int, req models.AppealReq) error {
+	if !userH.perms.Read {
+		return models.ErrPermDenied
+	}
+	sql, args, _ := psql.
+		Select("domain", "banned_by", "reason").
+		From("bans").
+		Where(sq.Eq{"id": banID, "user_id": userH.id}).
+		Where("expires_at IS NULL OR expires_at > NOW()").
+		ToSql()
+
+	var domain models.RoleDomain
+	var bannedBy *int
+	var reason string
+	err := h.sharedDB.QueryRow(ctx, sql, args...).Scan(&domain, &bannedBy, &reason)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ErrInvalidAppeal
+	} else if err != nil {
+		return err
+	}
+	return fileAppeal(ctx, h.sharedDB, "ban_id", banID, domain, userH.id, bannedBy, reason, req)
+}
+
+// AppealRemoval contests the removal of one of the user's essays
+func (h *DisceptoH) AppealRemoval(ctx context.Context, userH *UserH, essayID int, req models.AppealReq) error {
+	if !userH.perms.Read {
+		return models.ErrPermDenied
+	}
+	sql, args, _ := psql.
+		Select("subdisceptos.roledomain_id", "essays.removed_by", "essays.removal_reason").
+		From("essays").
+		Join("subdisceptos ON subdisceptos.name = essays.posted_in").
+		Where(sq.Eq{"essays.id": essayID, "essays.attributed_to_id": userH.id, "essays.purged_at": nil}).
+		Where(sq.NotEq{"essays.removed_at": nil}).
+		ToSql()
+
+	var domain models.RoleDomain
+	var removedBy *int
+	var reason string
+	err := h.sharedDB.QueryRow(ctx, sql, args...).Scan(&domain, &removedBy, &reason)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ErrInvalidAppeal
+	} else if err != nil {
+		return err
+	}
+	return fileAppeal(ctx, h.sharedDB, "essay_id", essayID, domain, userH.id, removedBy, reason, req)
+}
+
+// ListUserAppeals lists the appeals filed by the user
+func (h *DisceptoH) ListUserAppeals(ctx context.Context, userH *UserH) ([]models.Appeal, error) {
+	if !userH.perms.Read {
+		return nil, models.ErrPermDenied
+	}
+	return listAppeals(ctx, h.sharedDB, sq.Eq{"appeals.user_id": userH.id})
+}
+
+// reviewAppeal closes a pending appeal of the domain. When the appeal is overturned,
+// the ban is lifted or the essay restored. canReview tells whether the reviewer can
+// handle ban appeals (isBan) or removal appeals.
+func reviewAppeal(ctx context.Context, db DBTX, audit auditor, notifService models.NotificationService, appealID int, canReview func(isBan bool) bool, review models.AppealReview) error {
+	if !review.Outcome.Valid() || len(review.Response) > 500 {
+		return models.ErrInvalidAppeal
+	}
+	var response interface{}
+	if review.Response != "" {
+		response = review.Response
+	}
+
+	var userID int
+	err := execTx(ctx, db, func(ctx context.Context, tx DBTX) error {
+		sql, args, _ := psql.
+			Select("ban_id", "essay_id", "user_id", "acted_by", "reason", "statement").
+			From("appeals").
+			Where(sq.Eq{"id": appealID, "domain": audit.domain, "outcome": nil}).
+			Suffix("FOR UPDATE").
+			ToSql()
+
+		var banID, essayID, actedBy *int
+		var reason, statement string
+		err := tx.QueryRow(ctx, sql, args...).Scan(&banID, &essayID, &userID, &actedBy, &reason, &statement)
+		if err != nil {
+			return err
+		}
+		if !canReview(banID != nil) {
+			return models.ErrPermDenied
+		}
+		if audit.actorID == userID || actedBy != nil && *actedBy == audit.actorID {
+			return models.ErrAppealSelfReview
+		}
+
+		sql, args, _ = psql.
+			Update("appeals").
+			Set("reviewed_by", audit.actorID).
+			Set("reviewed_at", sq.Expr("NOW()")).
+			Set("outcome", review.Outcome).
+			Set("response", response).
+			Where(sq.Eq{"id": appealID}).
+			ToSql()
+
+		_, err = tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+
+		action := models.AuditAppealUphold
+		target := fmt.Sprintf("user %d", userID)
+		if essayID != nil {
+			target = fmt.Sprintf("essay %d", *essayID)
+		}
+		if review.Outcome == models.AppealOverturned {
+			action = models.AuditAppealOverturn
+			if banID != nil {
+				// The ban is kept in the history, as expired
+				sql, args, _ = psql.
+					Update("bans").
+					Set("expires_at", sq.Expr("NOW()")).
+					Where(sq.Eq{"id": *banID}).
+					ToSql()
+				_, err = tx.Exec(ctx, sql, args...)
+			} else {
+				err = restoreEssay(ctx, tx, audit, *essayID)
+				// Already restored by a moderator
+				if errors.Is(err, models.ErrNotRemoved) {
+					err = nil
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return audit.record(ctx, tx, action, target,
+			map[string]string{"reason": reason, "statement": statement}, review)
+	})
+	if err != nil {
+		return err
+	}
+
+	actionURL, err := url.Parse("/u/appeals")
+	if err != nil {
+		return err
+	}
+	return notifService.Send(ctx, &models.Notification{
+		Title:     "Your appeal has been reviewed",
+		Text:      fmt.Sprintf("The moderators %s their decision", review.Outcome),
+		NotifType: models.NotifTypeAppeal,
+		ActionURL: *actionURL,
+	}, userID)
+}
+
+// ListAppeals lists the appeals the user can review: ban appeals need
+// the ban permission, removal appeals the remove permission.
+func (h *SubdisceptoH) ListAppeals(ctx context.Context) ([]models.Appeal, error) {
+	where := sq.Or{}
+	if h.subPerms.Check(models.PermBanUser) {
+		where = append(where, sq.NotEq{"appeals.ban_id": nil})
+	}
+	if h.subPerms.Check(models.PermRemoveEssay) {
+		where = append(where, sq.NotEq{"appeals.essay_id": nil})
+	}
+	if len(where) == 0 {
+		return nil, models.ErrPermDenied
+	}
+	return listAppeals(ctx, h.sharedDB, sq.And{sq.Eq{"appeals.domain": h.rawSub.RoledomainID}, where})
+}
+func (h *SubdisceptoH) ReviewAppeal(ctx context.Context, appealID int, review models.AppealReview) error {
+	canReview := func(isBan bool) bool {
+		if isBan {
+			return h.subPerms.Check(models.PermBanUser)
+		}
+		return h.subPerms.Check(models.PermRemoveEssay)
+	}
+	return reviewAppeal(ctx, h.sharedDB, h.audit, h.notifService, appealID, canReview, review)
+}
+
+// ListAppeals lists the appeals of global bans
+func (h *DisceptoH) ListAppeals(ctx context.Context) ([]models.Appeal, error) {
+	if err := h.globalPerms.Require(models.PermBanUserGlobally); err != nil {
+		return nil, err
+	}
+	return listAppeals(ctx, h.sharedDB, sq.Eq{"appeals.domain": models.RoleDomainDiscepto})
+}
+func (h *DisceptoH) ReviewAppeal(ctx context.Context, appealID int, review models.AppealReview) error {
+	if err := h.globalPerms.Require(models.PermBanUserGlobally); err != nil {
+		return err
+	}
+	canReview := func(isBan bool) bool { return isBan }
+	return reviewAppeal(ctx, h.sharedDB, h.audit, h.notifService, appealID, canReview, review)
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"sort"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+// auditor records the privileged actions done through a handler,
+// attributing them to the user the handler was built for
+type auditor struct {
+	actorID int
+	domain  models.RoleDomain
+}
+
+func newAuditor(uH *UserH, domain models.RoleDomain) auditor {
+	a := auditor{domain: domain}
+	if uH != nil {
+		a.actorID = uH.id
+	}
+	return a
+}
+
+// record appends an entry to the audit log. Before and after are
+// JSON encoded, nil values are stored as NULL.
+func (a auditor) record(ctx context.Context, db DBTX, action models.AuditAction, target string, before interface{}, after interface{}) error {
+	var actorID interface{}
+	if a.actorID != 0 {
+		actorID = a.actorID
+	}
+	sql, args, _ := psql.
+		Insert("audit_log").
+		Columns("domain", "actor_id", "action", "target", "before", "after").
+		Values(a.domain, actorID, action, target, before, after).
+		ToSql()
+
+	_, err := db.Exec(ctx, sql, args...)
+	return err
+}
+
+// Sorted list of permissions, to get a stable JSON encoding
+func sortedPerms(list []models.Perm) []models.Perm {
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// The granted and denied permissions of a role, as recorded in the audit log
+func permsRecord(perms models.Perms) map[string][]models.Perm {
+	return map[string][]models.Perm{
+		"perms": sortedPerms(perms.List()),
+		"deny":  sortedPerms(perms.Denied()),
+	}
+}
+
+var selectAuditLog = psql.
+	Select(
+		"audit_log.id",
+		"audit_log.domain",
+		"audit_log.actor_id",
+		"users.name AS actor_name",
+		"audit_log.action",
+		"audit_log.target",
+		"COALESCE(audit_log.before, 'null') AS before",
+		"COALESCE(audit_log.after, 'null') AS after",
+		"audit_log.created_at",
+	).
+	From("audit_log").
+	LeftJoin("users ON users.id = audit_log.actor_id").
+	OrderBy("audit_log.id DESC")
+
+func listAuditLog(ctx context.Context, db DBTX, query sq.SelectBuilder) ([]models.AuditEntry, error) {
+	sql, args, _ := query.ToSql()
+
+	entries := []models.AuditEntry{}
+	err := pgxscan.Select(ctx, db, &entries, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ListAuditLog lists the privileged actions done inside this subdiscepto
+func (h *SubdisceptoH) ListAuditLog(ctx context.Context) ([]models.AuditEntry, error) {
+	if err := h.subPerms.Require(models.PermViewAuditLog); err != nil {
+		return nil, err
+	}
+	return listAuditLog(ctx, h.sharedDB, selectAuditLog.Where(sq.Eq{"audit_log.domain": h.rawSub.RoledomainID}))
+}
+
+// ListAuditLog lists every privileged action, in every domain
+func (h *DisceptoH) ListAuditLog(ctx context.Context) ([]models.AuditEntry, error) {
+	if err := h.globalPerms.Require(models.PermViewAuditLog); err != nil {
+		return nil, err
+	}
+	return listAuditLog(ctx, h.sharedDB, selectAuditLog)
+}
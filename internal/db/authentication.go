@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"errors"
+	"net"
 	"unicode"
 
 	sq "github.com/Masterminds/squirrel"
@@ -13,7 +14,16 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (sdb *SharedDB) CreateUser(ctx context.Context, user *models.User, passwd string) (uH *UserH, err error) {
+func (sdb *SharedDB) CreateUser(ctx context.Context, user *models.User, passwd string) (*UserH, error) {
+	return sdb.createUser(ctx, user, passwd, nil)
+}
+
+// CreateUserFrom creates the user like CreateUser, recording the address they signed up from
+func (sdb *SharedDB) CreateUserFrom(ctx context.Context, user *models.User, passwd string, ip net.IP) (*UserH, error) {
+	return sdb.createUser(ctx, user, passwd, ip)
+}
+
+func (sdb *SharedDB) createUser(ctx context.Context, user *models.User, passwd string, ip net.IP) (uH *UserH, err error) {
 	// Check email format
 	if !utils.ValidateEmail(user.Email) {
 		return nil, models.ErrInvalidFormat
@@ -26,13 +36,19 @@ func (sdb *SharedDB) CreateUser(ctx context.Context, user *models.User, passwd s
 	hash, err := bcrypt.GenerateFromPassword([]byte(passwd), sdb.bcryptCost)
 
 	err = execTx(ctx, sdb.db, func(ctx context.Context, tx DBTX) error {
-		err = insertUser(ctx, sdb.db, user, hash)
+		err = insertUser(ctx, tx, user, hash)
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.ConstraintName == "users_email_key" {
 			return models.ErrEmailAlreadyUsed
 		} else if err != nil {
 			return err
 		}
+		if ip != nil {
+			err = setSignupIP(ctx, tx, user.ID, ip)
+			if err != nil {
+				return err
+			}
+		}
 
 		// Assign admin role if first user
 		sql, args, _ := psql.Select("COUNT(*)").From("users").ToSql()
@@ -68,6 +84,7 @@ func (sdb *SharedDB) CreateUser(ctx context.Context, user *models.User, passwd s
 
 	return uH, nil
 }
+
 func (sdb *SharedDB) Login(ctx context.Context, email string, passwd string) (*UserH, error) {
 	sql, args, _ := psql.
 		Select("id", "passwd_hash").
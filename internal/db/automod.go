@@ -0,0 +1,294 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+// Number of recent essays a rule is checked against, in dry-run mode
+const automodDryRunLimit = 100
+
+var selectAutomodRules = psql.
+	Select(
+		"id",
+		"subdiscepto",
+		"name",
+		"field",
+		"match_type",
+		"pattern",
+		"max_account_age_days",
+		"action",
+		"action_arg",
+		"enabled",
+		"created_at",
+	).
+	From("automod_rules").
+	OrderBy("id")
+
+func listAutomodRules(ctx context.Context, db DBTX, where sq.Eq) ([]models.AutomodRule, error) {
+	sql, args, _ := selectAutomodRules.Where(where).ToSql()
+
+	rules := []models.AutomodRule{}
+	err := pgxscan.Select(ctx, db, &rules, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		rules[i].Compile()
+	}
+	return rules, nil
+}
+func (h *SubdisceptoH) ListAutomodRules(ctx context.Context) ([]models.AutomodRule, error) {
+	if err := h.subPerms.Require(models.PermManageAutomod); err != nil {
+		return nil, err
+	}
+	return listAutomodRules(ctx, h.sharedDB, sq.Eq{"subdiscepto": h.rawSub.Name})
+}
+func (h *SubdisceptoH) CreateAutomodRule(ctx context.Context, req models.AutomodRuleReq) error {
+	if err := h.subPerms.Require(models.PermManageAutomod); err != nil {
+		return err
+	}
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	rule := req.Rule()
+	sql, args, _ := psql.
+		Insert("automod_rules").
+		Columns("subdiscepto", "name", "field", "match_type", "pattern", "max_account_age_days", "action", "action_arg").
+		Values(h.rawSub.Name, rule.Name, rule.Field, rule.MatchType, rule.Pattern, rule.MaxAccountAgeDays, rule.Action, rule.ActionArg).
+		Suffix("RETURNING id").
+		ToSql()
+
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		err := tx.QueryRow(ctx, sql, args...).Scan(&rule.ID)
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditAutomodCreate, fmt.Sprintf("automod rule %d", rule.ID), nil, req)
+	})
+}
+func (h *SubdisceptoH) DeleteAutomodRule(ctx context.Context, ruleID int) error {
+	if err := h.subPerms.Require(models.PermManageAutomod); err != nil {
+		return err
+	}
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		rules, err := listAutomodRules(ctx, tx, sq.Eq{"id": ruleID, "subdiscepto": h.rawSub.Name})
+		if err != nil {
+			return err
+		} else if len(rules) == 0 {
+			return pgx.ErrNoRows
+		}
+		sql, args, _ := psql.
+			Delete("automod_rules").
+			Where(sq.Eq{"id": ruleID}).
+			ToSql()
+
+		_, err = tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditAutomodDelete, fmt.Sprintf("automod rule %d", ruleID), rules[0], nil)
+	})
+}
+
+// SetAutomodRuleEnabled turns a rule on or off, without deleting it
+func (h *SubdisceptoH) SetAutomodRuleEnabled(ctx context.Context, ruleID int, enabled bool) error {
+	if err := h.subPerms.Require(models.PermManageAutomod); err != nil {
+		return err
+	}
+	sql, args, _ := psql.
+		Update("automod_rules").
+		Set("enabled", enabled).
+		Where(sq.Eq{"id": ruleID, "subdiscepto": h.rawSub.Name}).
+		ToSql()
+
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		tag, err := tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		} else if tag.RowsAffected() == 0 {
+			return pgx.ErrNoRows
+		}
+		return h.audit.record(ctx, tx, models.AuditAutomodToggle, fmt.Sprintf("automod rule %d", ruleID),
+			nil, map[string]bool{"enabled": enabled})
+	})
+}
+
+// DryRunAutomodRule returns the recent essays of the subdiscepto the rule would have matched,
+// without applying its action
+func (h *SubdisceptoH) DryRunAutomodRule(ctx context.Context, req models.AutomodRuleReq) ([]models.AutomodSubject, error) {
+	if err := h.subPerms.Require(models.PermManageAutomod); err != nil {
+		return nil, err
+	}
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	sql, args, _ := psql.
+		Select(
+			"essays.id",
+			"essays.thesis",
+			"essays.content",
+			"ARRAY(SELECT tag FROM essay_tags WHERE essay_id = essays.id) AS tags",
+			"ARRAY(SELECT source FROM essay_sources WHERE essay_id = essays.id) AS sources",
+			authorNameColumn,
+			"COALESCE(EXTRACT(DAY FROM essays.published - users.created_at)::int, 0) AS account_age_days",
+		).
+		From("essays").
+		LeftJoin("users ON users.id = essays.attributed_to_id").
+		Where(sq.Eq{"essays.posted_in": h.rawSub.Name, "essays.removed_at": nil}).
+		OrderBy("essays.id DESC").
+		Limit(automodDryRunLimit).
+		ToSql()
+
+	subjects := []models.AutomodSubject{}
+	err := pgxscan.Select(ctx, h.sharedDB, &subjects, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := req.Rule()
+	matched := []models.AutomodSubject{}
+	for _, s := range subjects {
+		if rule.Matches(s) {
+			matched = append(matched, s)
+		}
+	}
+	return matched, nil
+}
+
+// applyAutomod runs the enabled rules of the subdiscepto on a newly inserted essay.
+// If any matching rule rejects the essay, an ErrAutomodRejected is returned
+// and no other action is taken.
+func (h *SubdisceptoH) applyAutomod(ctx context.Context, tx DBTX, essay *models.Essay) error {
+	rules, err := listAutomodRules(ctx, tx, sq.Eq{"subdiscepto": h.rawSub.Name, "enabled": true})
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+
+	sql, args, _ := psql.
+		Select("EXTRACT(DAY FROM NOW() - created_at)::int").
+		From("users").
+		Where(sq.Eq{"id": essay.AttributedToID}).
+		ToSql()
+
+	accountAgeDays := 0
+	err = tx.QueryRow(ctx, sql, args...).Scan(&accountAgeDays)
+	if err != nil {
+		return err
+	}
+
+	subject := models.NewAutomodSubject(essay, accountAgeDays)
+	matched := []models.AutomodRule{}
+	for _, r := range rules {
+		if !r.Matches(subject) {
+			continue
+		}
+		if r.Action == models.AutomodActionReject {
+			return models.ErrAutomodRejected{Rule: r.Name}
+		}
+		matched = append(matched, r)
+	}
+
+	for _, r := range matched {
+		switch r.Action {
+		case models.AutomodActionHold:
+			err = holdEssay(ctx, tx, essay.ID, fmt.Sprintf("AutoMod: %s", r.Name))
+		case models.AutomodActionReport:
+			sql, args, _ := psql.
+				Insert("reports").
+				Columns("essay_id", "description", "flag_type").
+				Values(essay.ID, fmt.Sprintf("AutoMod: %s", r.Name), r.ActionArg.String).
+				ToSql()
+			_, err = tx.Exec(ctx, sql, args...)
+		case models.AutomodActionTag:
+			sql, args, _ := psql.
+				Insert("essay_tags").
+				Columns("essay_id", "tag").
+				Values(essay.ID, r.ActionArg.String).
+				Suffix("ON CONFLICT DO NOTHING").
+				ToSql()
+			_, err = tx.Exec(ctx, sql, args...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// holdEssay hides an essay until a moderator approves it.
+// The first reason is kept when the essay is held more than once.
+func holdEssay(ctx context.Context, db DBTX, essayID int, reason string) error {
+	sql, args, _ := psql.
+		Update("essays").
+		Set("held_at", sq.Expr("NOW()")).
+		Set("held_reason", reason).
+		Where(sq.Eq{"id": essayID, "held_at": nil}).
+		ToSql()
+
+	_, err := db.Exec(ctx, sql, args...)
+	return err
+}
+
+// ApproveEssay publishes an essay held for review
+func (h EssayH) ApproveEssay(ctx context.Context) error {
+	if err := h.essayPerms.Require(models.PermRemoveEssay); err != nil {
+		return err
+	}
+	err := execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		return h.approveEssay(ctx, tx)
+	})
+	if err != nil {
+		return err
+	}
+	return h.notifyApproval(ctx)
+}
+func (h EssayH) approveEssay(ctx context.Context, tx DBTX) error {
+	sql, args, _ := psql.
+		Select("held_reason").
+		From("essays").
+		Where(sq.Eq{"id": h.id}).
+		Where(sq.NotEq{"held_at": nil}).
+		Suffix("FOR UPDATE").
+		ToSql()
+
+	var reason string
+	err := tx.QueryRow(ctx, sql, args...).Scan(&reason)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ErrNotHeld
+	} else if err != nil {
+		return err
+	}
+
+	sql, args, _ = psql.
+		Update("essays").
+		Set("held_at", nil).
+		Set("held_reason", nil).
+		Where(sq.Eq{"id": h.id}).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	// An approved essay isn't spam
+	err = labelSample(ctx, tx, h.id, false)
+	if err != nil {
+		return err
+	}
+	return h.audit.record(ctx, tx, models.AuditEssayApprove, fmt.Sprintf("essay %d", h.id),
+		map[string]string{"held_reason": reason}, nil)
+}
+func (h EssayH) notifyApproval(ctx context.Context) error {
+	err := h.notifyReply(ctx)
+	if err != nil {
+		return err
+	}
+	return h.notifyModeration(ctx, "Your essay has been approved")
+}
@@ -0,0 +1,221 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+// Awards every badge with the given rule to the users whose score
+// (as computed by the %s query) reaches the badge threshold
+const awardRuleBadges = `
+INSERT INTO user_badges (badge_id, user_id)
+SELECT badges.id, scores.user_id
+FROM badges
+JOIN (%s) AS scores(user_id, score) ON scores.score >= badges.threshold
+WHERE badges.rule = $1
+ON CONFLICT DO NOTHING`
+
+var badgeRuleScores = map[models.BadgeRule]string{
+	models.BadgeRuleAcceptedCorrection: `
+		SELECT essays.attributed_to_id, COUNT(*)
+		FROM persuasions
+		JOIN essays ON essays.id = persuasions.essay_id
+		JOIN essay_replies ON essay_replies.from_id = essays.id
+		JOIN essays AS parent ON parent.id = essay_replies.to_id
+		WHERE persuasions.user_id = parent.attributed_to_id AND essays.attributed_to_id IS NOT NULL
+		GROUP BY essays.attributed_to_id`,
+	models.BadgeRuleSupportsReceived: `
+		SELECT parent.attributed_to_id, COUNT(*)
+		FROM essay_replies
+		JOIN essays AS parent ON parent.id = essay_replies.to_id
+		WHERE essay_replies.reply_type = 'supports' AND parent.attributed_to_id IS NOT NULL
+		GROUP BY parent.attributed_to_id`,
+	models.BadgeRuleMembershipDays: `
+		SELECT id, EXTRACT(DAY FROM NOW() - created_at)
+		FROM users`,
+}
+
+// AwardBadges awards every automatic badge to the users who deserve it.
+// It's meant to be run periodically, in background.
+func (sdb SharedDB) AwardBadges(ctx context.Context) error {
+	return execTx(ctx, sdb.db, func(ctx context.Context, tx DBTX) error {
+		for rule, scores := range badgeRuleScores {
+			_, err := tx.Exec(ctx, fmt.Sprintf(awardRuleBadges, scores), rule)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func listUserBadges(ctx context.Context, db DBTX, userID int) ([]models.UserBadge, error) {
+	sql, args, _ := psql.
+		Select(
+			"badges.id",
+			"badges.name",
+			"badges.description",
+			"badges.subdiscepto",
+			"badges.rule",
+			"badges.threshold",
+			"user_badges.awarded_at",
+		).
+		From("user_badges").
+		Join("badges ON badges.id = user_badges.badge_id").
+		Where(sq.Eq{"user_badges.user_id": userID}).
+		OrderBy("user_badges.awarded_at").
+		ToSql()
+
+	badges := []models.UserBadge{}
+	err := pgxscan.Select(ctx, db, &badges, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return badges, nil
+}
+
+func (h *SubdisceptoH) ListBadges(ctx context.Context) ([]models.BadgeView, error) {
+	if err := h.subPerms.Require(models.PermManageBadges); err != nil {
+		return nil, err
+	}
+	sql, args, _ := psql.
+		Select(
+			"badges.id",
+			"badges.name",
+			"badges.description",
+			"badges.subdiscepto",
+			"badges.rule",
+			"badges.threshold",
+			"ARRAY(SELECT user_id FROM user_badges WHERE badge_id = badges.id ORDER BY user_id) AS holder_ids",
+			`ARRAY(SELECT users.name FROM user_badges
+				JOIN users ON users.id = user_badges.user_id
+				WHERE badge_id = badges.id ORDER BY user_id
+			) AS holder_names`,
+		).
+		From("badges").
+		Where(sq.Eq{"subdiscepto": h.rawSub.Name}).
+		OrderBy("badges.id").
+		ToSql()
+
+	badges := []models.BadgeView{}
+	err := pgxscan.Select(ctx, h.sharedDB, &badges, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return badges, nil
+}
+func (h *SubdisceptoH) CreateBadge(ctx context.Context, badge *models.BadgeReq) error {
+	if err := h.subPerms.Require(models.PermManageBadges); err != nil {
+		return err
+	}
+	if len(badge.Name) == 0 || len(badge.Name) > 50 || len(badge.Description) > 255 {
+		return models.ErrInvalidBadge
+	}
+	sql, args, _ := psql.
+		Insert("badges").
+		Columns("name", "description", "subdiscepto").
+		Values(badge.Name, badge.Description, h.rawSub.Name).
+		Suffix("RETURNING id").
+		ToSql()
+
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		var id int
+		err := tx.QueryRow(ctx, sql, args...).Scan(&id)
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditBadgeCreate, fmt.Sprintf("badge %d", id), nil, badge)
+	})
+}
+func (h *SubdisceptoH) DeleteBadge(ctx context.Context, badgeID int) error {
+	if err := h.subPerms.Require(models.PermManageBadges); err != nil {
+		return err
+	}
+	sql, args, _ := psql.
+		Delete("badges").
+		Where(sq.Eq{"id": badgeID, "subdiscepto": h.rawSub.Name}).
+		Suffix("RETURNING name, description").
+		ToSql()
+
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		before := models.BadgeReq{}
+		err := tx.QueryRow(ctx, sql, args...).Scan(&before.Name, &before.Description)
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditBadgeDelete, fmt.Sprintf("badge %d", badgeID), before, nil)
+	})
+}
+
+// AwardBadge gives a badge of this subdiscepto to one of its members
+func (h *SubdisceptoH) AwardBadge(ctx context.Context, badgeID int, userID int) error {
+	if err := h.subPerms.Require(models.PermManageBadges); err != nil {
+		return err
+	}
+	sql, args, _ := psql.
+		Insert("user_badges").
+		Columns("badge_id", "user_id").
+		Select(psql.
+			Select("badges.id", "subdiscepto_users.user_id").
+			From("badges").
+			Join("subdiscepto_users ON subdiscepto_users.subdiscepto = badges.subdiscepto").
+			Where(sq.Eq{
+				"badges.id":                 badgeID,
+				"badges.subdiscepto":        h.rawSub.Name,
+				"badges.rule":               nil,
+				"subdiscepto_users.user_id": userID,
+				"subdiscepto_users.left_at": nil,
+			}),
+		).
+		Suffix("ON CONFLICT DO NOTHING").
+		ToSql()
+
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		tag, err := tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			if hasBadge(ctx, tx, badgeID, userID) {
+				return nil
+			}
+			return models.ErrInvalidBadge
+		}
+		return h.audit.record(ctx, tx, models.AuditBadgeAward, fmt.Sprintf("user %d", userID),
+			nil, map[string]int{"badge_id": badgeID})
+	})
+}
+func (h *SubdisceptoH) RevokeBadge(ctx context.Context, badgeID int, userID int) error {
+	if err := h.subPerms.Require(models.PermManageBadges); err != nil {
+		return err
+	}
+	sql, args, _ := psql.
+		Delete("user_badges").
+		Where(sq.Eq{"badge_id": badgeID, "user_id": userID}).
+		Where("badge_id IN (SELECT id FROM badges WHERE subdiscepto = ?)", h.rawSub.Name).
+		ToSql()
+
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		tag, err := tx.Exec(ctx, sql, args...)
+		if err != nil || tag.RowsAffected() == 0 {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditBadgeRevoke, fmt.Sprintf("user %d", userID),
+			map[string]int{"badge_id": badgeID}, nil)
+	})
+}
+func hasBadge(ctx context.Context, db DBTX, badgeID int, userID int) bool {
+	sql, args, _ := psql.
+		Select("1").
+		From("user_badges").
+		Where(sq.Eq{"badge_id": badgeID, "user_id": userID}).
+		ToSql()
+
+	has := 0
+	err := db.QueryRow(ctx, sql, args...).Scan(&has)
+	return err == nil && has == 1
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+// Bans expire by themselves: expired rows are simply ignored
+var selectActiveBans = psql.
+	Select(
+		"bans.id",
+		"bans.domain",
+		"subdisceptos.name AS subdiscepto",
+		"bans.user_id",
+		"users.name AS user_name",
+		"banners.name AS banned_by_name",
+		"bans.reason",
+		"bans.created_at",
+		"bans.expires_at",
+	).
+	From("bans").
+	Join("users ON users.id = bans.user_id").
+	LeftJoin("users AS banners ON banners.id = bans.banned_by").
+	LeftJoin("subdisceptos ON subdisceptos.roledomain_id = bans.domain").
+	Where("bans.expires_at IS NULL OR bans.expires_at > NOW()")
+
+// findActiveBan returns the longest active ban of the user inside the domain,
+// or nil if the user isn't banned
+func findActiveBan(ctx context.Context, db DBTX, domain models.RoleDomain, userID int) (*models.Ban, error) {
+	sql, args, _ := selectActiveBans.
+		Where(sq.Eq{"bans.domain": domain, "bans.user_id": userID}).
+		OrderBy("bans.expires_at DESC NULLS FIRST").
+		Limit(1).
+		ToSql()
+
+	ban := &models.Ban{}
+	err := pgxscan.Get(ctx, db, ban, sql, args...)
+	if pgxscan.NotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return ban, nil
+}
+func listActiveBans(ctx context.Context, db DBTX, domain models.RoleDomain) ([]models.Ban, error) {
+	sql, args, _ := selectActiveBans.
+		Where(sq.Eq{"bans.domain": domain}).
+		OrderBy("bans.created_at DESC").
+		ToSql()
+
+	bans := []models.Ban{}
+	err := pgxscan.Select(ctx, db, &bans, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
+
+// banUser bans the user from the domain of the auditor, unless the user ranks at or above the actor
+func banUser(ctx context.Context, db DBTX, audit auditor, actorRank int, userID int, req models.BanReq) error {
+	if len(req.Reason) == 0 || len(req.Reason) > 500 || req.Days < 0 || userID == audit.actorID {
+		return models.ErrInvalidBan
+	}
+	if err := requireOutrank(ctx, db, audit.domain, actorRank, userID); err != nil {
+		return err
+	}
+	var expiresAt interface{}
+	if req.Days > 0 {
+		expiresAt = sq.Expr("NOW() + make_interval(days => ?)", req.Days)
+	}
+	var bannedBy interface{}
+	if audit.actorID != 0 {
+		bannedBy = audit.actorID
+	}
+	sql, args, _ := psql.
+		Insert("bans").
+		Columns("domain", "user_id", "banned_by", "reason", "expires_at").
+		Values(audit.domain, userID, bannedBy, req.Reason, expiresAt).
+		ToSql()
+
+	return execTx(ctx, db, func(ctx context.Context, tx DBTX) error {
+		_, err := tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+		return audit.record(ctx, tx, models.AuditUserBan, fmt.Sprintf("user %d", userID), nil, req)
+	})
+}
+func unbanUser(ctx context.Context, db DBTX, audit auditor, banID int) error {
+	sql, args, _ := psql.
+		Delete("bans").
+		Where(sq.Eq{"id": banID, "domain": audit.domain}).
+		Suffix("RETURNING user_id, reason").
+		ToSql()
+
+	return execTx(ctx, db, func(ctx context.Context, tx DBTX) error {
+		var userID int
+		var reason string
+		err := tx.QueryRow(ctx, sql, args...).Scan(&userID, &reason)
+		if err != nil {
+			return err
+		}
+		return audit.record(ctx, tx, models.AuditUserUnban, fmt.Sprintf("user %d", userID),
+			map[string]string{"reason": reason}, nil)
+	})
+}
+
+// Ban returns the active ban of the user inside this subdiscepto, if any.
+// A banned user has no permission at all inside the subdiscepto.
+func (h *SubdisceptoH) Ban() *models.Ban {
+	return h.ban
+}
+func (h *SubdisceptoH) ListBans(ctx context.Context) ([]models.Ban, error) {
+	if err := h.subPerms.Require(models.PermBanUser); err != nil {
+		return nil, err
+	}
+	return listActiveBans(ctx, h.sharedDB, h.rawSub.RoledomainID)
+}
+func (h *SubdisceptoH) BanUser(ctx context.Context, userID int, req models.BanReq) error {
+	if err := h.subPerms.Require(models.PermBanUser); err != nil {
+		return err
+	}
+	return banUser(ctx, h.sharedDB, h.audit, h.rank, userID, req)
+}
+func (h *SubdisceptoH) UnbanUser(ctx context.Context, banID int) error {
+	if err := h.subPerms.Require(models.PermBanUser); err != nil {
+		return err
+	}
+	return unbanUser(ctx, h.sharedDB, h.audit, banID)
+}
+
+// Ban returns the active global ban of the user, if any.
+// A banned user has no global permission.
+func (h *DisceptoH) Ban() *models.Ban {
+	return h.ban
+}
+func (h *DisceptoH) ListBans(ctx context.Context) ([]models.Ban, error) {
+	if err := h.globalPerms.Require(models.PermBanUserGlobally); err != nil {
+		return nil, err
+	}
+	return listActiveBans(ctx, h.sharedDB, models.RoleDomainDiscepto)
+}
+func (h *DisceptoH) BanUser(ctx context.Context, userID int, req models.BanReq) error {
+	if err := h.globalPerms.Require(models.PermBanUserGlobally); err != nil {
+		return err
+	}
+	return banUser(ctx, h.sharedDB, h.audit, h.rank, userID, req)
+}
+func (h *DisceptoH) UnbanUser(ctx context.Context, banID int) error {
+	if err := h.globalPerms.Require(models.PermBanUserGlobally); err != nil {
+		return err
+	}
+	return unbanUser(ctx, h.sharedDB, h.audit, banID)
+}
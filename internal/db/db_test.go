@@ -3,11 +3,14 @@ package db
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"math"
 	"net/url"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/stretchr/testify/require"
 	"gitlab.com/ranfdev/discepto/internal/models"
 )
@@ -68,6 +71,44 @@ func mockSubdisceptoReq2() *models.SubdisceptoReq {
 
 var db SharedDB
 
+// createVerifiedUser creates a user who already verified their email
+func createVerifiedUser(ctx context.Context, db SharedDB, user *models.User) (*UserH, error) {
+	userH, err := db.CreateUser(ctx, user, mockPasswd)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.db.Exec(ctx, "UPDATE users SET email_verified_at = NOW() WHERE id = $1", userH.id)
+	return userH, err
+}
+
+// getMockSub returns the handles of the user, globally and inside the mock subdiscepto
+func getMockSub(ctx context.Context, db SharedDB, userH *UserH) (*DisceptoH, *SubdisceptoH, error) {
+	disH, err := db.GetDisceptoH(ctx, userH)
+	if err != nil {
+		return nil, nil, err
+	}
+	subH, err := disH.GetSubdisceptoH(ctx, mockSubName, userH)
+	if err != nil {
+		return nil, nil, err
+	}
+	return disH, subH, nil
+}
+
+// joinMockSub makes the user a member of the mock subdiscepto,
+// returning the handles of the user
+func joinMockSub(ctx context.Context, db SharedDB, userH *UserH) (*DisceptoH, *SubdisceptoH, error) {
+	_, subH, err := getMockSub(ctx, db, userH)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = subH.AddMember(ctx, *userH)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The handles have the permissions of a member only once fetched again
+	return getMockSub(ctx, db, userH)
+}
+
 func init() {
 	err := os.Chdir("./../..")
 	if err != nil {
@@ -120,7 +161,7 @@ func TestUser(t *testing.T) {
 			require.Equal(td.err, err)
 		}
 
-		err = userH.Delete(context.Background())
+		err = userH.Delete(context.Background(), models.EssayDeletionAnonymize)
 		require.Nil(err)
 		return nil
 	})
@@ -154,7 +195,7 @@ func TestAuth(t *testing.T) {
 		}
 
 		// Clean
-		require.Nil(userH.Delete(ctx))
+		require.Nil(userH.Delete(ctx, models.EssayDeletionAnonymize))
 		return nil
 	})
 	require.Nil(err)
@@ -167,7 +208,7 @@ func TestEssay(t *testing.T) {
 	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
 		db := db.withTx(tx)
 		user := mockUser()
-		userH, err := db.CreateUser(ctx, user, mockPasswd)
+		userH, err := createVerifiedUser(ctx, db, user)
 		require.Nil(err)
 
 		disceptoH, err := db.GetDisceptoH(ctx, userH)
@@ -245,7 +286,7 @@ func TestEssay(t *testing.T) {
 		require.Nil(err)
 		err = sub2H.Delete(ctx)
 		require.Nil(err)
-		require.Nil(userH.Delete(ctx))
+		require.Nil(userH.Delete(ctx, models.EssayDeletionAnonymize))
 		return nil
 	})
 	require.Nil(err)
@@ -262,7 +303,7 @@ func TestSubdiscepto(t *testing.T) {
 			// user1
 			// Setup needed data
 			user := mockUser()
-			userH, err := db.CreateUser(ctx, user, mockPasswd)
+			userH, err := createVerifiedUser(ctx, db, user)
 			require.Nil(err)
 
 			subdis := mockSubdisceptoReq()
@@ -272,7 +313,7 @@ func TestSubdiscepto(t *testing.T) {
 			_, err = disceptoH.CreateSubdiscepto(ctx, *userH, subdis)
 			require.Nil(err)
 
-			subs, err := db.ListSubdisceptos(ctx, userH)
+			subs, err := db.ListSubdisceptos(ctx, userH, models.NsfwHide)
 			require.Nil(err)
 			require.Len(subs, 1)
 		}
@@ -281,7 +322,7 @@ func TestSubdiscepto(t *testing.T) {
 			// Join a sub
 			user := mockUser2()
 
-			userH, err := db.CreateUser(ctx, user, mockPasswd)
+			userH, err := createVerifiedUser(ctx, db, user)
 			require.Nil(err)
 
 			disceptoH, err := db.GetDisceptoH(ctx, userH)
@@ -303,7 +344,7 @@ func TestSubdiscepto(t *testing.T) {
 			err = subH.Delete(ctx)
 			require.NotNil(err)
 
-			require.Nil(userH.Delete(ctx))
+			require.Nil(userH.Delete(ctx, models.EssayDeletionAnonymize))
 		}
 
 		// Clean
@@ -316,7 +357,7 @@ func TestSubdiscepto(t *testing.T) {
 		err = subH.Delete(ctx)
 		require.Nil(err)
 
-		require.Nil(userH.Delete(ctx))
+		require.Nil(userH.Delete(ctx, models.EssayDeletionAnonymize))
 		return nil
 	})
 	require.Nil(err)
@@ -325,7 +366,7 @@ func TestSearch(t *testing.T) {
 	require := require.New(t)
 
 	user := mockUser()
-	userH, err := db.CreateUser(context.Background(), user, mockPasswd)
+	userH, err := createVerifiedUser(context.Background(), db, user)
 	require.Nil(err)
 	disceptoH, err := db.GetDisceptoH(context.Background(), userH)
 	require.Nil(err)
@@ -355,7 +396,7 @@ func TestSearch(t *testing.T) {
 	// Clean
 	require.Nil(esH.DeleteEssay(context.Background()))
 	require.Nil(subH.Delete(context.Background()))
-	require.Nil(userH.Delete(context.Background()))
+	require.Nil(userH.Delete(context.Background(), models.EssayDeletionAnonymize))
 }
 func TestRoles(t *testing.T) {
 	t.Parallel()
@@ -366,8 +407,8 @@ func TestRoles(t *testing.T) {
 	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
 		db := db.withTx(tx)
 		// Create necessary entities
-		userH, err := db.CreateUser(ctx, user, mockPasswd)
-		defer userH.Delete(ctx)
+		userH, err := createVerifiedUser(ctx, db, user)
+		defer userH.Delete(ctx, models.EssayDeletionAnonymize)
 
 		require.Nil(err)
 		disceptoH, err := db.GetDisceptoH(ctx, userH)
@@ -377,9 +418,9 @@ func TestRoles(t *testing.T) {
 		defer subH.Delete(ctx)
 
 		user2 := mockUser2()
-		user2H, err := db.CreateUser(ctx, user2, mockPasswd)
+		user2H, err := createVerifiedUser(ctx, db, user2)
 		require.Nil(err)
-		defer user2H.Delete(ctx)
+		defer user2H.Delete(ctx, models.EssayDeletionAnonymize)
 
 		err = subH.AddMember(ctx, *user2H)
 		require.Nil(err)
@@ -541,3 +582,1820 @@ func testListDomainsWithPerms(db SharedDB) func(t *testing.T) {
 		}
 	}
 }
+func TestWeightedVotes(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		subReq := mockSubdisceptoReq()
+		subReq.WeightedVotes = true
+		subH, err := disceptoH.CreateSubdiscepto(ctx, *userH, subReq)
+		require.Nil(err)
+
+		_, sub2H, err := joinMockSub(ctx, db, user2H)
+		require.Nil(err)
+		user3H, err := createVerifiedUser(ctx, db, &models.User{Name: "User3", Email: "user3@example.com"})
+		require.Nil(err)
+		_, sub3H, err := joinMockSub(ctx, db, user3H)
+		require.Nil(err)
+		user4H, err := createVerifiedUser(ctx, db, &models.User{Name: "User4", Email: "user4@example.com"})
+		require.Nil(err)
+		_, sub4H, err := joinMockSub(ctx, db, user4H)
+		require.Nil(err)
+
+		// user3 and user4 have no reputation: their upvotes weigh 1 each
+		essayRep := mockEssay(user.ID)
+		essayRepH, err := subH.CreateEssay(ctx, essayRep)
+		require.Nil(err)
+		esH, err := sub3H.GetEssayH(ctx, essayRepH.ID(), user3H)
+		require.Nil(err)
+		require.Nil(esH.CreateVote(ctx, *user3H, models.VoteTypeUpvote))
+		esH, err = sub4H.GetEssayH(ctx, essayRepH.ID(), user4H)
+		require.Nil(err)
+		require.Nil(esH.CreateVote(ctx, *user4H, models.VoteTypeUpvote))
+
+		// user1 and user2 upvote each other, once
+		essayOld := mockEssay(user2.ID)
+		essayOldH, err := sub2H.CreateEssay(ctx, essayOld)
+		require.Nil(err)
+		essayNew := mockEssay(user.ID)
+		essayNewH, err := subH.CreateEssay(ctx, essayNew)
+		require.Nil(err)
+		require.Nil(essayNewH.CreateVote(ctx, *user2H, models.VoteTypeUpvote))
+		essayOldH, err = subH.GetEssayH(ctx, essayOldH.ID(), userH)
+		require.Nil(err)
+		require.Nil(essayOldH.CreateVote(ctx, *userH, models.VoteTypeUpvote))
+
+		// user1 received 3 upvotes, user2 only 1
+		scores := map[int]float64{
+			essayOld.ID: 1 + math.Log(4),
+			essayRep.ID: 2,
+			essayNew.ID: 1 + math.Log(2),
+		}
+		essays, err := subH.ListEssays(ctx)
+		require.Nil(err)
+		require.Len(essays, 3)
+		require.Equal([]int{essayOld.ID, essayRep.ID, essayNew.ID}, []int{essays[0].ID, essays[1].ID, essays[2].ID})
+		for _, e := range essays {
+			require.InDelta(scores[e.ID], e.WeightedScore.Float64, 1e-9)
+		}
+
+		// Raw counts are still available
+		require.Equal(1, essays[0].Upvotes)
+		require.Equal(2, essays[1].Upvotes)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestVoteFlags(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2H, err := createVerifiedUser(ctx, db, mockUser2())
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		subH, err := disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+
+		// Both users always upvote the same essays
+		essayIDs := []int{}
+		for i := 0; i < CoVotingMinShared; i++ {
+			essay := mockEssay(user.ID)
+			esH, err := subH.CreateEssay(ctx, essay)
+			require.Nil(err)
+			require.Nil(esH.CreateVote(ctx, *userH, models.VoteTypeUpvote))
+			require.Nil(esH.CreateVote(ctx, *user2H, models.VoteTypeUpvote))
+			essayIDs = append(essayIDs, essay.ID)
+		}
+
+		require.Nil(db.DetectVoteRings(ctx))
+		flags, err := subH.ListVoteFlags(ctx)
+		require.Nil(err)
+		require.Len(flags, 1)
+		require.Equal(models.VoteFlagCoVoting, flags[0].FlagType)
+		require.ElementsMatch([]int{userH.ID(), user2H.ID()}, flags[0].UserIDs)
+
+		// Running the analysis again doesn't duplicate flags
+		require.Nil(db.DetectVoteRings(ctx))
+		flags, err = subH.ListVoteFlags(ctx)
+		require.Nil(err)
+		require.Len(flags, 1)
+
+		// Nullified votes aren't counted anymore
+		require.Nil(subH.ResolveVoteFlag(ctx, flags[0].ID, true))
+		esH, err := subH.GetEssayH(ctx, essayIDs[0], userH)
+		require.Nil(err)
+		essay, err := esH.ReadView(ctx)
+		require.Nil(err)
+		require.Equal(0, essay.Upvotes)
+
+		// Nullified votes can't be replaced by fresh ones
+		require.Equal(models.ErrVoteNullified, esH.DeleteVote(ctx, *userH))
+		essay, err = esH.ReadView(ctx)
+		require.Nil(err)
+		require.Equal(0, essay.Upvotes)
+
+		// A resolved flag can't be resolved twice
+		require.NotNil(subH.ResolveVoteFlag(ctx, flags[0].ID, false))
+		return nil
+	})
+	require.Nil(err)
+}
+func TestPersuasions(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		subH, err := disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+
+		_, sub2H, err := joinMockSub(ctx, db, user2H)
+		require.Nil(err)
+
+		// user2 replies to an essay of user1
+		parent := mockEssay(user.ID)
+		parentH, err := subH.CreateEssay(ctx, parent)
+		require.Nil(err)
+		reply := mockEssay(user2.ID)
+		reply.ReplyType = models.ReplyTypeRefutes
+		replyH, err := sub2H.CreateEssayReply(ctx, reply, *parentH)
+		require.Nil(err)
+
+		// Only replies written by someone else can be marked
+		require.Equal(models.ErrInvalidPersuasion, parentH.MarkChangedView(ctx, *userH))
+		reply2H, err := sub2H.GetEssayH(ctx, replyH.ID(), user2H)
+		require.Nil(err)
+		require.Equal(models.ErrInvalidPersuasion, reply2H.MarkChangedView(ctx, *user2H))
+
+		replyH, err = subH.GetEssayH(ctx, replyH.ID(), userH)
+		require.Nil(err)
+		require.Nil(replyH.MarkChangedView(ctx, *userH))
+		did, err := replyH.GetUserDid(ctx, *userH)
+		require.Nil(err)
+		require.True(did.ChangedView)
+
+		essay, err := replyH.ReadView(ctx)
+		require.Nil(err)
+		require.Equal(1, essay.Persuasions)
+		require.True(essay.PersuadedParentAuthor)
+
+		author, err := disceptoH.ReadPublicUser(ctx, user2.ID)
+		require.Nil(err)
+		require.Equal(1, author.Persuasions)
+
+		require.Nil(replyH.UnmarkChangedView(ctx, *userH))
+		essay, err = replyH.ReadView(ctx)
+		require.Nil(err)
+		require.Equal(0, essay.Persuasions)
+		require.False(essay.PersuadedParentAuthor)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestBadges(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		subH, err := disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+
+		// A reply of user2 changes the view of user1
+		_, sub2H, err := joinMockSub(ctx, db, user2H)
+		require.Nil(err)
+		parentH, err := subH.CreateEssay(ctx, mockEssay(user.ID))
+		require.Nil(err)
+		replyH, err := sub2H.CreateEssayReply(ctx, mockEssay(user2.ID), *parentH)
+		require.Nil(err)
+		replyH, err = subH.GetEssayH(ctx, replyH.ID(), userH)
+		require.Nil(err)
+		require.Nil(replyH.MarkChangedView(ctx, *userH))
+
+		require.Nil(db.AwardBadges(ctx))
+		author, err := disceptoH.ReadPublicUser(ctx, user2.ID)
+		require.Nil(err)
+		require.Len(author.Badges, 1)
+		require.Equal(string(models.BadgeRuleAcceptedCorrection), author.Badges[0].Rule.String)
+
+		// Running the evaluator again doesn't duplicate badges
+		require.Nil(db.AwardBadges(ctx))
+		author, err = disceptoH.ReadPublicUser(ctx, user2.ID)
+		require.Nil(err)
+		require.Len(author.Badges, 1)
+
+		// Community badges are awarded by hand
+		require.NotNil(subH.CreateBadge(ctx, &models.BadgeReq{}))
+		require.Nil(subH.CreateBadge(ctx, &models.BadgeReq{Name: "Founder"}))
+		badges, err := subH.ListBadges(ctx)
+		require.Nil(err)
+		require.Len(badges, 1)
+		require.Nil(subH.AwardBadge(ctx, badges[0].ID, user.ID))
+		badges, err = subH.ListBadges(ctx)
+		require.Nil(err)
+		require.Equal([]int{user.ID}, badges[0].HolderIDs)
+
+		// Normal members can't manage badges
+		require.NotNil(sub2H.AwardBadge(ctx, badges[0].ID, user2.ID))
+
+		require.Nil(subH.RevokeBadge(ctx, badges[0].ID, user.ID))
+		badges, err = subH.ListBadges(ctx)
+		require.Nil(err)
+		require.Empty(badges[0].HolderIDs)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestReports(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		subH, err := disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+		essay := mockEssay(user.ID)
+		_, err = subH.CreateEssay(ctx, essay)
+		require.Nil(err)
+
+		_, sub2H, err := joinMockSub(ctx, db, user2H)
+		require.Nil(err)
+		essay2H, err := sub2H.GetEssayH(ctx, essay.ID, user2H)
+		require.Nil(err)
+
+		report := models.Report{
+			EssayID:     essay.ID,
+			FromUserID:  user2.ID,
+			FlagType:    "unknown",
+			Description: "Copied from another site",
+		}
+		require.Equal(models.ErrInvalidReport, essay2H.CreateReport(ctx, report, *user2H))
+		report.FlagType = models.FlagTypeSpam
+		require.Nil(essay2H.CreateReport(ctx, report, *user2H))
+
+		reports, err := subH.ListReports(ctx, models.ReportFilter{FlagType: models.FlagTypeFake})
+		require.Nil(err)
+		require.Len(reports, 0)
+		reports, err = subH.ListReports(ctx, models.ReportFilter{State: models.ReportStateOpen})
+		require.Nil(err)
+		require.Len(reports, 1)
+		require.Equal(models.FlagTypeSpam, reports[0].FlagType)
+		reportID := reports[0].ID
+
+		// open -> claimed -> resolved
+		require.Nil(subH.ClaimReport(ctx, reportID, *userH))
+		require.Equal(models.ErrInvalidReport, subH.ClaimReport(ctx, reportID, *userH))
+		require.Equal(models.ErrInvalidReport, subH.CloseReport(ctx, reportID, *userH, models.ReportStateOpen, ""))
+		require.Nil(subH.CloseReport(ctx, reportID, *userH, models.ReportStateResolved, "Essay removed"))
+
+		reports, err = subH.ListReports(ctx, models.ReportFilter{State: models.ReportStateResolved})
+		require.Nil(err)
+		require.Len(reports, 1)
+		require.Equal(user.Name, reports[0].ModeratorName.String)
+		require.Equal("Essay removed", reports[0].Resolution.String)
+		require.True(reports[0].ClosedAt.Valid)
+
+		// Closed reports can't be reopened
+		require.Equal(models.ErrInvalidReport, subH.CloseReport(ctx, reportID, *userH, models.ReportStateDismissed, ""))
+
+		// Normal members can't see reports
+		_, err = sub2H.ListReports(ctx, models.ReportFilter{})
+		require.NotNil(err)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+		subH, err := disceptoH.GetSubdisceptoH(ctx, mockSubName, userH)
+		require.Nil(err)
+		require.Nil(subH.AddMember(ctx, *user2H))
+
+		// Privileged actions
+		roleH, err := subH.CreateRole(ctx, "helper")
+		require.Nil(err)
+		require.Nil(roleH.UpdatePerms(ctx, models.NewPerms(models.PermViewReport)))
+		require.Nil(subH.Assign(ctx, user2.ID, *roleH))
+		subReq := mockSubdisceptoReq()
+		subReq.Description = "A new description"
+		require.Nil(subH.Update(ctx, subReq))
+		essayH, err := subH.CreateEssay(ctx, mockEssay(user.ID))
+		require.Nil(err)
+		require.Nil(essayH.DeleteEssay(ctx))
+
+		entries, err := subH.ListAuditLog(ctx)
+		require.Nil(err)
+		actions := []models.AuditAction{}
+		for _, e := range entries {
+			require.Equal(int32(user.ID), e.ActorID.Int32)
+			actions = append(actions, e.Action)
+		}
+		require.Equal([]models.AuditAction{
+			models.AuditEssayDelete,
+			models.AuditSubUpdate,
+			models.AuditRoleAssign,
+			models.AuditRoleUpdatePerms,
+			models.AuditRoleCreate,
+		}, actions)
+		require.JSONEq(`{"perms": []}`, string(entries[3].Before))
+		require.JSONEq(`{"perms": ["view_report"]}`, string(entries[3].After))
+
+		// The log is append-only
+		err = execTx(ctx, tx, func(ctx context.Context, tx DBTX) error {
+			_, err := tx.Exec(ctx, "DELETE FROM audit_log")
+			return err
+		})
+		require.NotNil(err)
+
+		// Normal members can't read it
+		_, sub2H, err := getMockSub(ctx, db, user2H)
+		require.Nil(err)
+		_, err = sub2H.ListAuditLog(ctx)
+		require.NotNil(err)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestBans(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		subH, err := disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+		subH, err = disceptoH.GetSubdisceptoH(ctx, mockSubName, userH)
+		require.Nil(err)
+		require.Nil(subH.AddMember(ctx, *user2H))
+
+		// Can't ban without a reason, or yourself
+		require.Equal(models.ErrInvalidBan, subH.BanUser(ctx, user2.ID, models.BanReq{}))
+		require.Equal(models.ErrInvalidBan, subH.BanUser(ctx, user.ID, models.BanReq{Reason: "spam"}))
+
+		// Ban from the subdiscepto
+		require.Nil(subH.BanUser(ctx, user2.ID, models.BanReq{Reason: "spam", Days: 3}))
+		dis2H, err := db.GetDisceptoH(ctx, user2H)
+		require.Nil(err)
+		require.Nil(dis2H.Ban())
+		sub2H, err := dis2H.GetSubdisceptoH(ctx, mockSubName, user2H)
+		require.Nil(err)
+		require.NotNil(sub2H.Ban())
+		require.Equal("spam", sub2H.Ban().Reason)
+		require.True(sub2H.Ban().ExpiresAt.Valid)
+		require.Equal(models.NewPerms(), sub2H.Perms())
+
+		bans, err := subH.ListBans(ctx)
+		require.Nil(err)
+		require.Len(bans, 1)
+		require.Nil(subH.UnbanUser(ctx, bans[0].ID))
+		sub2H, err = dis2H.GetSubdisceptoH(ctx, mockSubName, user2H)
+		require.Nil(err)
+		require.Nil(sub2H.Ban())
+		require.Nil(sub2H.Perms().Require(models.PermCreateEssay))
+
+		// Permanent global ban
+		require.Nil(disceptoH.BanUser(ctx, user2.ID, models.BanReq{Reason: "trolling"}))
+		dis2H, err = db.GetDisceptoH(ctx, user2H)
+		require.Nil(err)
+		require.NotNil(dis2H.Ban())
+		require.False(dis2H.Ban().ExpiresAt.Valid)
+		require.Equal(models.NewPerms(), dis2H.Perms())
+		bans, err = disceptoH.ListBans(ctx)
+		require.Nil(err)
+		require.Nil(disceptoH.UnbanUser(ctx, bans[0].ID))
+
+		// Expired bans are ignored
+		_, err = tx.Exec(ctx,
+			"INSERT INTO bans (domain, user_id, reason, expires_at) VALUES ($1, $2, 'old', NOW() - interval '1 day')",
+			models.RoleDomainDiscepto, user2.ID)
+		require.Nil(err)
+		dis2H, err = db.GetDisceptoH(ctx, user2H)
+		require.Nil(err)
+		require.Nil(dis2H.Ban())
+		return nil
+	})
+	require.Nil(err)
+}
+func TestRemovals(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		subH, err := disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+
+		_, sub2H, err := joinMockSub(ctx, db, user2H)
+		require.Nil(err)
+
+		// user2 writes an essay, user1 replies to it
+		parentH, err := sub2H.CreateEssay(ctx, mockEssay(user2.ID))
+		require.Nil(err)
+		_, err = subH.CreateEssayReply(ctx, mockEssay(user.ID), *parentH)
+		require.Nil(err)
+
+		require.Nil(subH.CreateRemovalReason(ctx, "Off topic"))
+		reasons, err := subH.ListRemovalReasons(ctx)
+		require.Nil(err)
+		require.Len(reasons, 1)
+
+		// Only moderators can remove, and only with a reason of the subdiscepto
+		require.NotNil(parentH.RemoveEssay(ctx, reasons[0].ID))
+		parentH, err = subH.GetEssayH(ctx, parentH.ID(), userH)
+		require.Nil(err)
+		require.Equal(models.ErrInvalidRemovalReason, parentH.RemoveEssay(ctx, -1))
+		// Moderators can't delete the essays of others, with their replies
+		require.NotNil(parentH.DeleteEssay(ctx))
+		require.Nil(parentH.RemoveEssay(ctx, reasons[0].ID))
+
+		essay, err := parentH.ReadView(ctx)
+		require.Nil(err)
+		require.Equal("", essay.Thesis)
+		require.Equal("Off topic", essay.RemovalReason.String)
+		essays, err := subH.ListEssays(ctx)
+		require.Nil(err)
+		for _, e := range essays {
+			require.NotEqual(parentH.ID(), e.ID)
+		}
+		replies, err := subH.ListReplies(ctx, *parentH, nil)
+		require.Nil(err)
+		require.Len(replies, 1)
+		removed, err := subH.ListRemovedEssays(ctx)
+		require.Nil(err)
+		require.Len(removed, 1)
+
+		require.Nil(parentH.RestoreEssay(ctx))
+		essay, err = parentH.ReadView(ctx)
+		require.Nil(err)
+		require.Equal(mockEssay(user2.ID).Thesis, essay.Thesis)
+		require.False(essay.RemovalReason.Valid)
+		require.Equal(models.ErrNotRemoved, parentH.RestoreEssay(ctx))
+
+		// After the retention period, the content is purged but the replies stay
+		require.Nil(parentH.RemoveEssay(ctx, reasons[0].ID))
+		_, err = tx.Exec(ctx, "UPDATE essays SET removed_at = NOW() - interval '40 days' WHERE id = $1", parentH.ID())
+		require.Nil(err)
+		require.Nil(db.PurgeRemovedEssays(ctx, 30))
+		replies, err = subH.ListReplies(ctx, *parentH, nil)
+		require.Nil(err)
+		require.Len(replies, 1)
+		require.Equal(models.ErrNotRemoved, parentH.RestoreEssay(ctx))
+		removed, err = subH.ListRemovedEssays(ctx)
+		require.Nil(err)
+		require.Len(removed, 0)
+
+		// The author deletes an essay with replies, a placeholder keeps the thread
+		parentH, err = sub2H.CreateEssay(ctx, mockEssay(user2.ID))
+		require.Nil(err)
+		_, err = subH.CreateEssayReply(ctx, mockEssay(user.ID), *parentH)
+		require.Nil(err)
+		require.Nil(parentH.DeleteEssay(ctx))
+		parentH, err = subH.GetEssayH(ctx, parentH.ID(), userH)
+		require.Nil(err)
+		essay, err = parentH.ReadView(ctx)
+		require.Nil(err)
+		require.Equal("", essay.Thesis)
+		replies, err = subH.ListReplies(ctx, *parentH, nil)
+		require.Nil(err)
+		require.Len(replies, 1)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestAutomod(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		subH, err := disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+
+		_, sub2H, err := joinMockSub(ctx, db, user2H)
+		require.Nil(err)
+
+		// Essay posted before the rules exist
+		oldH, err := sub2H.CreateEssay(ctx, mockEssay(user2.ID))
+		require.Nil(err)
+
+		hold := models.AutomodRuleReq{
+			Name:      "No bananas",
+			Field:     models.AutomodFieldThesis,
+			MatchType: models.AutomodMatchKeyword,
+			Pattern:   "banana",
+			Action:    models.AutomodActionHold,
+		}
+		require.Equal(models.ErrInvalidAutomodRule, subH.CreateAutomodRule(ctx, models.AutomodRuleReq{Name: "empty"}))
+		require.NotNil(sub2H.CreateAutomodRule(ctx, hold))
+
+		// Dry run finds the old essay
+		matches, err := subH.DryRunAutomodRule(ctx, hold)
+		require.Nil(err)
+		require.Len(matches, 1)
+		require.Equal(oldH.ID(), matches[0].ID)
+
+		require.Nil(subH.CreateAutomodRule(ctx, hold))
+		require.Nil(subH.CreateAutomodRule(ctx, models.AutomodRuleReq{
+			Name:      "Tag fruits",
+			Field:     models.AutomodFieldTags,
+			MatchType: models.AutomodMatchKeyword,
+			Pattern:   "fruit",
+			Action:    models.AutomodActionTag,
+			ActionArg: "automod",
+		}))
+		rules, err := subH.ListAutomodRules(ctx)
+		require.Nil(err)
+		require.Len(rules, 2)
+
+		// New essays are held and tagged
+		heldH, err := sub2H.CreateEssay(ctx, mockEssay(user2.ID))
+		require.Nil(err)
+		essay, err := heldH.ReadView(ctx)
+		require.Nil(err)
+		require.Equal("AutoMod: No bananas", essay.HeldReason.String)
+		essays, err := subH.ListEssays(ctx)
+		require.Nil(err)
+		require.Len(essays, 1)
+		tagged, err := disceptoH.SearchByTags(ctx, []string{"automod"})
+		require.Nil(err)
+		require.Len(tagged, 0)
+
+		heldH, err = subH.GetEssayH(ctx, heldH.ID(), userH)
+		require.Nil(err)
+		require.Nil(heldH.ApproveEssay(ctx))
+		require.Equal(models.ErrNotHeld, heldH.ApproveEssay(ctx))
+		essays, err = subH.ListEssays(ctx)
+		require.Nil(err)
+		require.Len(essays, 2)
+
+		// Rejecting rules win over the others
+		require.Nil(subH.SetAutomodRuleEnabled(ctx, rules[1].ID, false))
+		require.Nil(subH.CreateAutomodRule(ctx, models.AutomodRuleReq{
+			Name:              "New accounts",
+			Field:             models.AutomodFieldContent,
+			MatchType:         models.AutomodMatchRegex,
+			Pattern:           `fruit\b`,
+			MaxAccountAgeDays: 7,
+			Action:            models.AutomodActionReject,
+		}))
+		_, err = sub2H.CreateEssay(ctx, mockEssay(user2.ID))
+		require.Equal(models.ErrAutomodRejected{Rule: "New accounts"}, err)
+
+		require.Nil(subH.DeleteAutomodRule(ctx, rules[0].ID))
+		require.Equal(pgx.ErrNoRows, subH.DeleteAutomodRule(ctx, rules[0].ID))
+		require.Equal(pgx.ErrNoRows, subH.SetAutomodRuleEnabled(ctx, rules[0].ID, true))
+		rules, err = subH.ListAutomodRules(ctx)
+		require.Nil(err)
+		require.Len(rules, 2)
+		require.False(rules[0].Enabled)
+
+		// AutoMod reports have no author, but can still be deleted
+		require.Nil(subH.SetAutomodRuleEnabled(ctx, rules[1].ID, false))
+		require.Nil(subH.CreateAutomodRule(ctx, models.AutomodRuleReq{
+			Name:      "Report bananas",
+			Field:     models.AutomodFieldThesis,
+			MatchType: models.AutomodMatchKeyword,
+			Pattern:   "banana",
+			Action:    models.AutomodActionReport,
+			ActionArg: string(models.FlagTypeSpam),
+		}))
+		_, err = sub2H.CreateEssay(ctx, mockEssay(user2.ID))
+		require.Nil(err)
+		reports, err := subH.ListReports(ctx, models.ReportFilter{})
+		require.Nil(err)
+		require.Len(reports, 1)
+		require.Nil(subH.DeleteReport(ctx, reports[0].ID))
+		reports, err = subH.ListReports(ctx, models.ReportFilter{})
+		require.Nil(err)
+		require.Len(reports, 0)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestSpamClassifier(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		subH, err := disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+
+		for i := 0; i < SpamMinSamples; i++ {
+			spamEssay := mockEssay(user.ID)
+			spamEssay.Thesis = "Buy cheap pills"
+			spamEssay.Content = fmt.Sprintf("Cheap pills, best price, click now! Offer %d", i)
+			esH, err := subH.CreateEssay(ctx, spamEssay)
+			require.Nil(err)
+			require.Nil(labelSample(ctx, tx, esH.ID(), true))
+
+			esH, err = subH.CreateEssay(ctx, mockEssay(user.ID))
+			require.Nil(err)
+			require.Nil(labelSample(ctx, tx, esH.ID(), false))
+			// The samples outlive the essays
+			require.Nil(esH.DeleteEssay(ctx))
+		}
+		samples, err := db.RetrainSpamModel(ctx)
+		require.Nil(err)
+		require.Equal(2*SpamMinSamples, samples)
+
+		spamEssay := mockEssay(user.ID)
+		spamEssay.Thesis = "Cheap pills"
+		spamEssay.Content = "Click now for the best price on pills"
+		esH, err := subH.CreateEssay(ctx, spamEssay)
+		require.Nil(err)
+		essay, err := esH.ReadView(ctx)
+		require.Nil(err)
+		require.True(essay.HeldReason.Valid)
+
+		esH, err = subH.CreateEssay(ctx, mockEssay(user.ID))
+		require.Nil(err)
+		essay, err = esH.ReadView(ctx)
+		require.Nil(err)
+		require.False(essay.HeldReason.Valid)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestModmail(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+		subH, err := disceptoH.GetSubdisceptoH(ctx, mockSubName, userH)
+		require.Nil(err)
+		dis2H, sub2H, err := getMockSub(ctx, db, user2H)
+		require.Nil(err)
+
+		_, err = sub2H.OpenModmail(ctx, *user2H, models.ModmailReq{Subject: "", Text: "hi"})
+		require.Equal(models.ErrInvalidModmail, err)
+		threadID, err := sub2H.OpenModmail(ctx, *user2H, models.ModmailReq{Subject: "Question", Text: "hi"})
+		require.Nil(err)
+
+		// Only moderators can read the inbox
+		_, err = sub2H.ListModmail(ctx, *user2H, false)
+		require.NotNil(err)
+
+		threads, err := subH.ListModmail(ctx, *userH, false)
+		require.Nil(err)
+		require.Len(threads, 1)
+		require.True(threads[0].Unread)
+		require.Equal(user2.Name, threads[0].UserName)
+
+		thread, err := subH.ReadModmail(ctx, *userH, threadID)
+		require.Nil(err)
+		require.Len(thread.Messages, 1)
+		threads, err = subH.ListModmail(ctx, *userH, false)
+		require.Nil(err)
+		require.False(threads[0].Unread)
+
+		// The user doesn't see who answered
+		require.Nil(subH.ReplyModmail(ctx, *userH, threadID, "hello"))
+		thread, err = dis2H.ReadModmail(ctx, user2H, threadID)
+		require.Nil(err)
+		require.Len(thread.Messages, 2)
+		require.True(thread.Messages[1].FromMods)
+		require.False(thread.Messages[1].AuthorName.Valid)
+		thread, err = subH.ReadModmail(ctx, *userH, threadID)
+		require.Nil(err)
+		require.Equal(user.Name, thread.Messages[1].AuthorName.String)
+
+		// Other users can't read the thread
+		_, err = disceptoH.ReadModmail(ctx, userH, threadID)
+		require.NotNil(err)
+
+		// Archived threads come back when the user writes again
+		require.Nil(subH.ArchiveModmail(ctx, threadID, true))
+		require.Equal(pgx.ErrNoRows, subH.ArchiveModmail(ctx, -1, true))
+		threads, err = subH.ListModmail(ctx, *userH, false)
+		require.Nil(err)
+		require.Len(threads, 0)
+		require.Nil(dis2H.ReplyModmail(ctx, user2H, threadID, "thanks"))
+		threads, err = subH.ListModmail(ctx, *userH, false)
+		require.Nil(err)
+		require.Len(threads, 1)
+
+		threads, err = dis2H.ListModmail(ctx, user2H)
+		require.Nil(err)
+		require.Len(threads, 1)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestAppeals(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+		user3 := &models.User{Name: "User3", Email: "user3@example.com"}
+		user3H, err := createVerifiedUser(ctx, db, user3)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+		subH, err := disceptoH.GetSubdisceptoH(ctx, mockSubName, userH)
+		require.Nil(err)
+		require.Nil(subH.AddMember(ctx, *user2H))
+		require.Nil(subH.AddMember(ctx, *user3H))
+		adminH, err := subH.GetRoleH(ctx, "admin")
+		require.Nil(err)
+		require.Nil(subH.Assign(ctx, user3.ID, *adminH))
+		_, sub3H, err := getMockSub(ctx, db, user3H)
+		require.Nil(err)
+
+		dis2H, sub2H, err := getMockSub(ctx, db, user2H)
+		require.Nil(err)
+		essayH, err := sub2H.CreateEssay(ctx, mockEssay(user2.ID))
+		require.Nil(err)
+
+		// user1 bans user2, who appeals
+		require.Nil(subH.BanUser(ctx, user2.ID, models.BanReq{Reason: "spam"}))
+		sub2H, err = dis2H.GetSubdisceptoH(ctx, mockSubName, user2H)
+		require.Nil(err)
+		require.NotNil(sub2H.Ban())
+		banID := sub2H.Ban().ID
+		require.Equal(models.ErrInvalidAppeal, dis2H.AppealBan(ctx, user2H, banID, models.AppealReq{}))
+		require.Equal(models.ErrInvalidAppeal, disceptoH.AppealBan(ctx, userH, banID, models.AppealReq{Statement: "not mine"}))
+		require.Nil(dis2H.AppealBan(ctx, user2H, banID, models.AppealReq{Statement: "I'm not a bot"}))
+		require.Equal(models.ErrAppealExists, dis2H.AppealBan(ctx, user2H, banID, models.AppealReq{Statement: "again"}))
+
+		appeals, err := subH.ListAppeals(ctx)
+		require.Nil(err)
+		require.Len(appeals, 1)
+		require.Equal(user.Name, appeals[0].ActedByName.String)
+		overturn := models.AppealReview{Outcome: models.AppealOverturned}
+		require.Equal(models.ErrAppealSelfReview, subH.ReviewAppeal(ctx, appeals[0].ID, overturn))
+		require.Equal(models.ErrInvalidAppeal, sub3H.ReviewAppeal(ctx, appeals[0].ID, models.AppealReview{Outcome: "maybe"}))
+
+		// Overturning lifts the ban
+		require.Nil(sub3H.ReviewAppeal(ctx, appeals[0].ID, overturn))
+		sub2H, err = dis2H.GetSubdisceptoH(ctx, mockSubName, user2H)
+		require.Nil(err)
+		require.Nil(sub2H.Ban())
+		require.NotNil(sub3H.ReviewAppeal(ctx, appeals[0].ID, overturn))
+
+		// Upholding a removal keeps the essay removed
+		require.Nil(subH.CreateRemovalReason(ctx, "Off topic"))
+		reasons, err := subH.ListRemovalReasons(ctx)
+		require.Nil(err)
+		essayH, err = subH.GetEssayH(ctx, essayH.ID(), userH)
+		require.Nil(err)
+		require.Nil(essayH.RemoveEssay(ctx, reasons[0].ID))
+		require.Nil(dis2H.AppealRemoval(ctx, user2H, essayH.ID(), models.AppealReq{Statement: "It's on topic"}))
+		appeals, err = sub3H.ListAppeals(ctx)
+		require.Nil(err)
+		require.Len(appeals, 2)
+		require.False(appeals[0].Outcome.Valid)
+		require.Nil(sub3H.ReviewAppeal(ctx, appeals[0].ID, models.AppealReview{Outcome: models.AppealUpheld, Response: "It isn't"}))
+		essay, err := essayH.ReadView(ctx)
+		require.Nil(err)
+		require.True(essay.RemovalReason.Valid)
+
+		appeals, err = dis2H.ListUserAppeals(ctx, user2H)
+		require.Nil(err)
+		require.Len(appeals, 2)
+		for _, a := range appeals {
+			require.True(a.Outcome.Valid)
+		}
+
+		// A later removal of the same essay can be appealed again
+		require.Nil(essayH.RestoreEssay(ctx))
+		require.Nil(essayH.RemoveEssay(ctx, reasons[0].ID))
+		require.Nil(dis2H.AppealRemoval(ctx, user2H, essayH.ID(), models.AppealReq{Statement: "Still on topic"}))
+		require.Equal(models.ErrAppealExists, dis2H.AppealRemoval(ctx, user2H, essayH.ID(), models.AppealReq{Statement: "again"}))
+		entries, err := subH.ListAuditLog(ctx)
+		require.Nil(err)
+		actions := []models.AuditAction{}
+		for _, e := range entries {
+			actions = append(actions, e.Action)
+		}
+		require.Contains(actions, models.AuditAppealOverturn)
+		require.Contains(actions, models.AuditAppealUphold)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestPremoderation(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+		user3 := &models.User{Name: "User3", Email: "user3@example.com"}
+		user3H, err := createVerifiedUser(ctx, db, user3)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		subReq := mockSubdisceptoReq()
+		subReq.PremodMinKarma = 1
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, subReq)
+		require.Nil(err)
+		subH, err := disceptoH.GetSubdisceptoH(ctx, mockSubName, userH)
+		require.Nil(err)
+		require.Nil(subH.CreateRemovalReason(ctx, "Off topic"))
+		reasons, err := subH.ListRemovalReasons(ctx)
+		require.Nil(err)
+
+		dis2H, sub2H, err := joinMockSub(ctx, db, user2H)
+		require.Nil(err)
+		_, sub3H, err := getMockSub(ctx, db, user3H)
+		require.Nil(err)
+
+		// Moderators aren't held, user2 has no karma yet
+		_, err = subH.CreateEssay(ctx, mockEssay(user.ID))
+		require.Nil(err)
+		essay1H, err := sub2H.CreateEssay(ctx, mockEssay(user2.ID))
+		require.Nil(err)
+		essay2H, err := sub2H.CreateEssay(ctx, mockEssay(user2.ID))
+		require.Nil(err)
+		held, err := subH.ListHeldEssays(ctx)
+		require.Nil(err)
+		require.Len(held, 2)
+		_, err = sub2H.ListHeldEssays(ctx)
+		require.NotNil(err)
+
+		// Visible to the author and to moderators only
+		_, err = sub2H.GetEssayH(ctx, essay1H.ID(), user2H)
+		require.Nil(err)
+		_, err = sub3H.GetEssayH(ctx, essay1H.ID(), user3H)
+		require.NotNil(err)
+
+		// A batch failing halfway isn't applied at all
+		require.NotNil(subH.ApproveEssays(ctx, userH, []int{essay1H.ID(), -1}))
+		held, err = subH.ListHeldEssays(ctx)
+		require.Nil(err)
+		require.Len(held, 2)
+
+		require.Nil(subH.ApproveEssays(ctx, userH, []int{essay1H.ID()}))
+		require.Nil(subH.RejectEssays(ctx, userH, []int{essay2H.ID()}, reasons[0].ID))
+		held, err = subH.ListHeldEssays(ctx)
+		require.Nil(err)
+		require.Len(held, 0)
+		_, err = sub3H.GetEssayH(ctx, essay1H.ID(), user3H)
+		require.Nil(err)
+		removed, err := subH.ListRemovedEssays(ctx)
+		require.Nil(err)
+		require.Len(removed, 1)
+		require.Equal(essay2H.ID(), removed[0].ID)
+
+		// The author is told about both decisions
+		notifs, err := dis2H.ListNotifs(ctx, user2H)
+		require.Nil(err)
+		count := 0
+		for _, n := range notifs {
+			if n.NotifType == models.NotifTypeModeration {
+				count++
+			}
+		}
+		require.Equal(2, count)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestNsfw(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		require.Equal(models.NsfwHide, disceptoH.NsfwPref())
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+		nsfwSub := mockSubdisceptoReq2()
+		nsfwSub.Nsfw = true
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, nsfwSub)
+		require.Nil(err)
+
+		subH, err := disceptoH.GetSubdisceptoH(ctx, mockSubName, userH)
+		require.Nil(err)
+		_, err = subH.CreateEssay(ctx, mockEssay(user.ID))
+		require.Nil(err)
+		nsfwEssay := mockEssay(user.ID)
+		nsfwEssay.Nsfw = true
+		nsfwEssayH, err := subH.CreateEssay(ctx, nsfwEssay)
+		require.Nil(err)
+		require.True(nsfwEssayH.Nsfw())
+		// Single essays are gated by the routes, knowing the essay is NSFW
+		nsfwEssayH, err = subH.GetEssayH(ctx, nsfwEssayH.ID(), userH)
+		require.Nil(err)
+		require.True(nsfwEssayH.Nsfw())
+		sub2H, err := disceptoH.GetSubdisceptoH(ctx, mockSubName2, userH)
+		require.Nil(err)
+		_, err = sub2H.CreateEssay(ctx, mockEssay(user.ID))
+		require.Nil(err)
+
+		// Hidden by default
+		subs, err := db.ListSubdisceptos(ctx, userH, disceptoH.NsfwPref())
+		require.Nil(err)
+		require.Len(subs, 1)
+		essays, err := subH.ListEssays(ctx)
+		require.Nil(err)
+		require.Len(essays, 1)
+		essays, err = disceptoH.SearchByThesis(ctx, mockEssay(user.ID).Thesis)
+		require.Nil(err)
+		require.Len(essays, 1)
+
+		require.Equal(models.ErrInvalidNsfwPref, userH.SetNsfwPref(ctx, "maybe"))
+		require.Nil(userH.SetNsfwPref(ctx, models.NsfwBlur))
+		disceptoH, err = db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		require.Equal(models.NsfwBlur, disceptoH.NsfwPref())
+
+		subs, err = db.ListSubdisceptos(ctx, userH, disceptoH.NsfwPref())
+		require.Nil(err)
+		require.Len(subs, 2)
+		essays, err = disceptoH.SearchByThesis(ctx, mockEssay(user.ID).Thesis)
+		require.Nil(err)
+		require.Len(essays, 3)
+		nsfwCount := 0
+		for _, e := range essays {
+			if e.Nsfw {
+				nsfwCount++
+			}
+		}
+		// The essay of the NSFW subdiscepto is NSFW too
+		require.Equal(2, nsfwCount)
+
+		// Anonymous visitors must confirm their age
+		anonH, err := db.GetDisceptoH(ctx, nil)
+		require.Nil(err)
+		require.Equal(models.NsfwHide, anonH.NsfwPref())
+		anonH.ConfirmAge()
+		require.Equal(models.NsfwBlur, anonH.NsfwPref())
+		return nil
+	})
+	require.Nil(err)
+}
+func TestInvites(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+		user3 := &models.User{Name: "User3", Email: "user3@example.com"}
+		user3H, err := createVerifiedUser(ctx, db, user3)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		subReq := mockSubdisceptoReq()
+		subReq.Public = false
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, subReq)
+		require.Nil(err)
+		subH, err := disceptoH.GetSubdisceptoH(ctx, mockSubName, userH)
+		require.Nil(err)
+
+		dis2H, err := db.GetDisceptoH(ctx, user2H)
+		require.Nil(err)
+		_, err = dis2H.GetSubdisceptoH(ctx, mockSubName, user2H)
+		require.NotNil(err)
+
+		// A single use link, making the user an admin
+		token, err := subH.CreateInviteLink(ctx, *userH, models.InviteReq{Role: "admin", Days: 1, MaxUses: 1})
+		require.Nil(err)
+		invite, err := dis2H.ReadInvite(ctx, token)
+		require.Nil(err)
+		require.Equal(mockSubName, invite.Subdiscepto)
+		// Members redeeming the link don't use it up
+		_, err = disceptoH.RedeemInvite(ctx, userH, token)
+		require.Nil(err)
+		subName, err := dis2H.RedeemInvite(ctx, user2H, token)
+		require.Nil(err)
+		require.Equal(mockSubName, subName)
+		sub2H, err := dis2H.GetSubdisceptoH(ctx, mockSubName, user2H)
+		require.Nil(err)
+		require.True(sub2H.Perms().Check(models.PermManageInvites))
+
+		dis3H, err := db.GetDisceptoH(ctx, user3H)
+		require.Nil(err)
+		_, err = dis3H.RedeemInvite(ctx, user3H, token)
+		require.Equal(models.ErrInviteExpired, err)
+
+		// Direct invitation
+		require.Nil(sub2H.InviteUser(ctx, *user2H, user3.ID, models.InviteReq{}))
+		invites, err := dis3H.ListUserInvites(ctx, user3H)
+		require.Nil(err)
+		require.Len(invites, 1)
+		_, err = disceptoH.AcceptInvite(ctx, userH, invites[0].ID)
+		require.Equal(models.ErrInviteExpired, err)
+		_, err = dis3H.AcceptInvite(ctx, user3H, invites[0].ID)
+		require.Nil(err)
+		sub3H, err := dis3H.GetSubdisceptoH(ctx, mockSubName, user3H)
+		require.Nil(err)
+		require.False(sub3H.Perms().Check(models.PermManageInvites))
+		invites, err = dis3H.ListUserInvites(ctx, user3H)
+		require.Nil(err)
+		require.Len(invites, 0)
+		require.Equal(pgx.ErrNoRows, subH.DeleteInvite(ctx, -1))
+		return nil
+	})
+	require.Nil(err)
+}
+func TestJoinRequests(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		subReq := mockSubdisceptoReq()
+		subReq.JoinMode = models.JoinByRequest
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, subReq)
+		require.Nil(err)
+		subH, err := disceptoH.GetSubdisceptoH(ctx, mockSubName, userH)
+		require.Nil(err)
+
+		// Can't join freely
+		dis2H, sub2H, err := getMockSub(ctx, db, user2H)
+		require.Nil(err)
+		require.Equal(models.ErrJoinClosed, sub2H.AddMember(ctx, *user2H))
+
+		require.Nil(dis2H.RequestJoin(ctx, user2H, mockSubName, "Let me in"))
+		require.Equal(models.ErrJoinRequestExists, dis2H.RequestJoin(ctx, user2H, mockSubName, "Please"))
+		pending, err := dis2H.ReadJoinRequest(ctx, user2H, mockSubName)
+		require.Nil(err)
+		require.NotNil(pending)
+
+		_, err = sub2H.ListJoinRequests(ctx)
+		require.NotNil(err)
+		reqs, err := subH.ListJoinRequests(ctx)
+		require.Nil(err)
+		require.Len(reqs, 1)
+		require.Equal("Let me in", reqs[0].Message)
+
+		require.Nil(subH.DecideJoinRequest(ctx, *userH, reqs[0].ID, true))
+		require.Equal(models.ErrInvalidJoinRequest, subH.DecideJoinRequest(ctx, *userH, reqs[0].ID, false))
+		pending, err = dis2H.ReadJoinRequest(ctx, user2H, mockSubName)
+		require.Nil(err)
+		require.Nil(pending)
+		mySubs, err := dis2H.ListUserSubdisceptos(ctx, user2H)
+		require.Nil(err)
+		require.Len(mySubs, 1)
+
+		// Both sides are notified
+		notifs, err := disceptoH.ListNotifs(ctx, userH)
+		require.Nil(err)
+		require.Len(notifs, 1)
+		notifs, err = dis2H.ListNotifs(ctx, user2H)
+		require.Nil(err)
+		require.Len(notifs, 1)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestRoleExpiry(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+		subH, err := disceptoH.GetSubdisceptoH(ctx, mockSubName, userH)
+		require.Nil(err)
+		require.Nil(subH.AddMember(ctx, *user2H))
+
+		roleH, err := subH.CreateRole(ctx, "guest-mod")
+		require.Nil(err)
+		require.Nil(roleH.UpdatePerms(ctx, models.NewPerms(models.PermViewReport)))
+		require.Equal(models.ErrInvalidExpiry, subH.AssignFor(ctx, user2.ID, *roleH, -1))
+		require.Nil(subH.AssignFor(ctx, user2.ID, *roleH, 7))
+
+		dis2H, sub2H, err := getMockSub(ctx, db, user2H)
+		require.Nil(err)
+		require.True(sub2H.Perms().Check(models.PermViewReport))
+		roles, err := subH.ListUserRoles(ctx, user2.ID)
+		require.Nil(err)
+		expiring := 0
+		for _, r := range roles {
+			if r.ExpiresAt.Valid {
+				expiring++
+			}
+		}
+		require.Equal(1, expiring)
+
+		// Once expired, the role is ignored, then cleaned up
+		_, err = tx.Exec(ctx, "UPDATE user_roles SET expires_at = NOW() - interval '1 day' WHERE user_id = $1 AND expires_at IS NOT NULL", user2.ID)
+		require.Nil(err)
+		sub2H, err = dis2H.GetSubdisceptoH(ctx, mockSubName, user2H)
+		require.Nil(err)
+		require.False(sub2H.Perms().Check(models.PermViewReport))
+		require.Nil(db.DeleteExpiredRoles(ctx))
+		var count int
+		err = tx.QueryRow(ctx, "SELECT COUNT(*) FROM user_roles WHERE user_id = $1 AND expires_at IS NOT NULL", user2.ID).Scan(&count)
+		require.Nil(err)
+		require.Equal(0, count)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestRoleRank(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+		user3 := &models.User{Name: "User3", Email: "user3@example.com"}
+		user3H, err := createVerifiedUser(ctx, db, user3)
+		require.Nil(err)
+		user4 := &models.User{Name: "User4", Email: "user4@example.com"}
+		user4H, err := createVerifiedUser(ctx, db, user4)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+		subH, err := disceptoH.GetSubdisceptoH(ctx, mockSubName, userH)
+		require.Nil(err)
+		require.Nil(subH.AddMember(ctx, *user2H))
+		require.Nil(subH.AddMember(ctx, *user3H))
+		require.Nil(subH.AddMember(ctx, *user4H))
+
+		// User2 is a low ranking moderator, user3 an admin
+		modH, err := subH.CreateRole(ctx, "mod")
+		require.Nil(err)
+		require.Equal(models.RoleRankDefault, modH.Rank())
+		require.Nil(modH.UpdatePerms(ctx, models.NewPerms(models.PermManageRole, models.PermBanUser, models.PermViewReport)))
+		require.Nil(subH.Assign(ctx, user2.ID, *modH))
+		adminH, err := subH.GetRoleH(ctx, "admin")
+		require.Nil(err)
+		require.Equal(models.RoleRankAdmin, adminH.Rank())
+		require.Nil(subH.Assign(ctx, user3.ID, *adminH))
+
+		_, sub2H, err := getMockSub(ctx, db, user2H)
+		require.Nil(err)
+
+		// The moderator can't act on the admin
+		require.Equal(models.ErrRoleRank, sub2H.BanUser(ctx, user3.ID, models.BanReq{Reason: "spam"}))
+		require.Equal(models.ErrRoleRank, sub2H.UnassignAll(ctx, user3.ID))
+		admin2H, err := sub2H.GetRoleH(ctx, "admin")
+		require.Nil(err)
+		require.False(admin2H.CanEdit())
+		require.Equal(models.ErrRoleRank, sub2H.Unassign(ctx, user3.ID, *admin2H))
+		require.Equal(models.ErrRoleRank, sub2H.Assign(ctx, user4.ID, *admin2H))
+
+		// nor create roles above itself
+		helperH, err := sub2H.CreateRole(ctx, "helper")
+		require.Nil(err)
+		require.Equal(models.ErrRoleRank, helperH.SetRank(ctx, models.RoleRankDefault+1))
+		require.Nil(helperH.SetRank(ctx, 0))
+		require.Equal(0, helperH.Rank())
+
+		// but it can act on common members
+		require.Nil(sub2H.Assign(ctx, user4.ID, *helperH))
+		require.Nil(sub2H.BanUser(ctx, user4.ID, models.BanReq{Reason: "spam"}))
+
+		// Admins can't act on each other, but can on the moderator
+		_, sub3H, err := getMockSub(ctx, db, user3H)
+		require.Nil(err)
+		require.Equal(models.ErrRoleRank, sub3H.BanUser(ctx, user.ID, models.BanReq{Reason: "spam"}))
+		mod3H, err := sub3H.GetRoleH(ctx, "mod")
+		require.Nil(err)
+		require.Nil(sub3H.Unassign(ctx, user2.ID, *mod3H))
+		return nil
+	})
+	require.Nil(err)
+}
+func TestPermExplain(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+		subH, err := disceptoH.GetSubdisceptoH(ctx, mockSubName, userH)
+		require.Nil(err)
+		require.Nil(subH.AddMember(ctx, *user2H))
+
+		// Granted by the local common role
+		e, err := subH.ExplainPerm(ctx, user2.ID, 0, models.PermCreateEssay)
+		require.Nil(err)
+		require.True(e.Granted)
+		require.Contains(e.Grants, models.PermReason{Source: models.PermSourceLocalRole, Detail: "common"})
+
+		// Granted by the global common role
+		e, err = disceptoH.ExplainPerm(ctx, user2.ID, "", 0, models.PermCreateVote)
+		require.Nil(err)
+		require.True(e.Granted)
+		require.Contains(e.Grants, models.PermReason{Source: models.PermSourceGlobalRole, Detail: "common"})
+
+		// Missing, but the admin roles would grant it
+		e, err = subH.ExplainPerm(ctx, user2.ID, 0, models.PermViewReport)
+		require.Nil(err)
+		require.False(e.Granted)
+		require.Empty(e.Grants)
+		require.Contains(e.Missing, models.PermReason{Source: models.PermSourceLocalRole, Detail: "admin"})
+		// Local managers don't see the names of the global roles
+		require.Contains(e.Missing, models.PermReason{Source: models.PermSourceGlobalRole, Detail: "global role"})
+		require.NotContains(e.Missing, models.PermReason{Source: models.PermSourceGlobalRole, Detail: "admin"})
+
+		// Hidden essays grant nothing
+		essay := mockEssay(user.ID)
+		essay.MembersOnly = true
+		essayH, err := subH.CreateEssay(ctx, essay)
+		require.Nil(err)
+		user3 := &models.User{Name: "User3", Email: "user3@example.com"}
+		_, err = createVerifiedUser(ctx, db, user3)
+		require.Nil(err)
+		e, err = subH.ExplainPerm(ctx, user3.ID, essayH.ID(), models.PermCreateVote)
+		require.Nil(err)
+		require.False(e.Granted)
+		require.Contains(e.Blocks, models.PermReason{Source: models.PermSourceMembersOnly, Detail: fmt.Sprintf("essay %d", essayH.ID())})
+		require.Nil(holdEssay(ctx, tx, essayH.ID(), "test"))
+		e, err = subH.ExplainPerm(ctx, user2.ID, essayH.ID(), models.PermCreateVote)
+		require.Nil(err)
+		require.False(e.Granted)
+		require.Contains(e.Blocks, models.PermReason{Source: models.PermSourceHeld, Detail: fmt.Sprintf("essay %d", essayH.ID())})
+
+		// A ban takes away the local roles
+		require.Nil(subH.BanUser(ctx, user2.ID, models.BanReq{Reason: "spam"}))
+		e, err = subH.ExplainPerm(ctx, user2.ID, 0, models.PermCreateEssay)
+		require.Nil(err)
+		require.False(e.Granted)
+		require.Contains(e.Grants, models.PermReason{Source: models.PermSourceLocalRole, Detail: "common"})
+		require.Contains(e.Blocks, models.PermReason{Source: models.PermSourceLocalBan, Detail: "spam"})
+
+		// Only role managers can ask
+		dis2H, err := db.GetDisceptoH(ctx, user2H)
+		require.Nil(err)
+		_, err = dis2H.ExplainPerm(ctx, user.ID, mockSubName, 0, models.PermCreateEssay)
+		require.NotNil(err)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestRoleTemplates(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+
+		// Roles from a preset
+		subReq := mockSubdisceptoReq()
+		subReq.RolePreset = "unknown"
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, subReq)
+		require.Equal(models.ErrInvalidRoleTemplate, err)
+		subReq.RolePreset = "moderated"
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, subReq)
+		require.Nil(err)
+		subH, err := disceptoH.GetSubdisceptoH(ctx, mockSubName, userH)
+		require.Nil(err)
+		modH, err := subH.GetRoleH(ctx, "moderator")
+		require.Nil(err)
+		require.Equal(models.RoleRankDefault, modH.Rank())
+
+		// Export, then import into another subdiscepto
+		exported, err := subH.ExportRoles(ctx)
+		require.Nil(err)
+		require.Len(exported.Roles, 4)
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq2())
+		require.Nil(err)
+		sub2H, err := disceptoH.GetSubdisceptoH(ctx, mockSubName2, userH)
+		require.Nil(err)
+		_, err = sub2H.GetRoleH(ctx, "moderator")
+		require.NotNil(err)
+		require.Nil(sub2H.ImportRoles(ctx, *exported))
+		mod2H, err := sub2H.GetRoleH(ctx, "moderator")
+		require.Nil(err)
+		perms, err := modH.ListActivePerms(ctx)
+		require.Nil(err)
+		perms2, err := mod2H.ListActivePerms(ctx)
+		require.Nil(err)
+		require.Equal(perms, perms2)
+		require.Equal(modH.Rank(), mod2H.Rank())
+
+		// Only the available permissions are accepted
+		invalid := models.RoleTemplate{Roles: []models.RoleTemplateEntry{
+			{Name: "super", Perms: []models.Perm{models.PermManageGlobalRole}},
+		}}
+		require.Equal(models.ErrInvalidRoleTemplate, sub2H.ImportRoles(ctx, invalid))
+		return nil
+	})
+	require.Nil(err)
+}
+func TestDenyPerms(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+		subH, err := disceptoH.GetSubdisceptoH(ctx, mockSubName, userH)
+		require.Nil(err)
+		require.Nil(subH.AddMember(ctx, *user2H))
+
+		// A local deny wins over the local common role
+		mutedH, err := subH.CreateRole(ctx, "muted")
+		require.Nil(err)
+		require.Nil(mutedH.UpdatePerms(ctx, models.NewPerms().Deny(models.PermCreateEssay)))
+		perms, err := mutedH.ListActivePerms(ctx)
+		require.Nil(err)
+		require.True(perms.Denies(models.PermCreateEssay))
+		require.Nil(subH.Assign(ctx, user2.ID, *mutedH))
+
+		dis2H, sub2H, err := getMockSub(ctx, db, user2H)
+		require.Nil(err)
+		require.False(sub2H.Perms().Check(models.PermCreateEssay))
+		require.True(sub2H.Perms().Check(models.PermReadSubdiscepto))
+		e, err := subH.ExplainPerm(ctx, user2.ID, 0, models.PermCreateEssay)
+		require.Nil(err)
+		require.False(e.Granted)
+		require.Contains(e.Blocks, models.PermReason{Source: models.PermSourceLocalDeny, Detail: "muted"})
+
+		// A local deny wins over a global grant, inside the subdiscepto only
+		noUndoH, err := subH.CreateRole(ctx, "no-undo")
+		require.Nil(err)
+		require.Nil(noUndoH.UpdatePerms(ctx, models.NewPerms().Deny(models.PermDeleteVote)))
+		require.Nil(subH.Assign(ctx, user2.ID, *noUndoH))
+		sub2H, err = dis2H.GetSubdisceptoH(ctx, mockSubName, user2H)
+		require.Nil(err)
+		require.True(dis2H.Perms().Check(models.PermDeleteVote))
+		require.False(sub2H.Perms().Check(models.PermDeleteVote))
+
+		// A global deny wins everywhere, even over a local grant
+		noVoteH, err := disceptoH.CreateRole(ctx, "no-vote")
+		require.Nil(err)
+		require.Nil(noVoteH.UpdatePerms(ctx, models.NewPerms().Deny(models.PermCreateVote)))
+		require.Nil(disceptoH.Assign(ctx, user2.ID, *noVoteH))
+		voterH, err := subH.CreateRole(ctx, "voter")
+		require.Nil(err)
+		require.Nil(voterH.UpdatePerms(ctx, models.NewPerms(models.PermCreateVote)))
+		require.Nil(subH.Assign(ctx, user2.ID, *voterH))
+		dis2H, sub2H, err = getMockSub(ctx, db, user2H)
+		require.Nil(err)
+		require.False(dis2H.Perms().Check(models.PermCreateVote))
+		require.False(sub2H.Perms().Check(models.PermCreateVote))
+		return nil
+	})
+	require.Nil(err)
+}
+func TestMembersOnly(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		subH, err := disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+		_, err = subH.CreateEssay(ctx, mockEssay(user.ID))
+		require.Nil(err)
+		secret := mockEssay(user.ID)
+		secret.MembersOnly = true
+		secretH, err := subH.CreateEssay(ctx, secret)
+		require.Nil(err)
+
+		essays, err := subH.ListEssays(ctx)
+		require.Nil(err)
+		require.Len(essays, 2)
+
+		// Visitors of the public subdiscepto don't see members-only essays
+		dis2H, sub2H, err := getMockSub(ctx, db, user2H)
+		require.Nil(err)
+		require.False(sub2H.Perms().Check(models.PermReadEssay))
+		essays, err = sub2H.ListEssays(ctx)
+		require.Nil(err)
+		require.Len(essays, 1)
+		require.False(essays[0].MembersOnly)
+		_, err = sub2H.GetEssayH(ctx, secretH.ID(), user2H)
+		require.Equal(pgx.ErrNoRows, err)
+		essays, err = dis2H.SearchByThesis(ctx, secret.Thesis)
+		require.Nil(err)
+		require.Len(essays, 1)
+		essays, err = dis2H.ListUserEssays(ctx, user.ID)
+		require.Nil(err)
+		require.Len(essays, 1)
+
+		anonH, err := db.GetDisceptoH(ctx, nil)
+		require.Nil(err)
+		essays, err = anonH.SearchByThesis(ctx, secret.Thesis)
+		require.Nil(err)
+		require.Len(essays, 1)
+
+		// Members do
+		_, sub2H, err = joinMockSub(ctx, db, user2H)
+		require.Nil(err)
+		essays, err = sub2H.ListEssays(ctx)
+		require.Nil(err)
+		require.Len(essays, 2)
+		_, err = sub2H.GetEssayH(ctx, secretH.ID(), user2H)
+		require.Nil(err)
+		essays, err = dis2H.SearchByThesis(ctx, secret.Thesis)
+		require.Nil(err)
+		require.Len(essays, 2)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestDeleteUser(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := createVerifiedUser(ctx, db, user2)
+		require.Nil(err)
+		user3 := &models.User{Name: "User3", Email: "user3@example.com"}
+		user3H, err := createVerifiedUser(ctx, db, user3)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		subH, err := disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+
+		dis2H, sub2H, err := joinMockSub(ctx, db, user2H)
+		require.Nil(err)
+		parentH, err := sub2H.CreateEssay(ctx, mockEssay(user2.ID))
+		require.Nil(err)
+		_, err = sub2H.CreateEssay(ctx, mockEssay(user2.ID))
+		require.Nil(err)
+		reply := mockEssay(user.ID)
+		reply.InReplyTo = sql.NullInt32{Int32: int32(parentH.ID()), Valid: true}
+		reply.ReplyType = models.ReplyTypeRefutes
+		replyH, err := subH.CreateEssayReply(ctx, reply, *parentH)
+		require.Nil(err)
+
+		// Only admins can delete other users
+		require.NotNil(dis2H.DeleteUser(ctx, user3.ID, models.EssayDeletionPurge))
+		require.Equal(models.ErrInvalidDeletion, disceptoH.DeleteUser(ctx, user2.ID, "maybe"))
+
+		// Purged essays with replies are kept as placeholders
+		require.Nil(disceptoH.DeleteUser(ctx, user2.ID, models.EssayDeletionPurge))
+		essays, err := subH.ListEssays(ctx)
+		require.Nil(err)
+		require.Len(essays, 1)
+		require.Equal(replyH.ID(), essays[0].ID)
+		require.Equal(int32(parentH.ID()), essays[0].InReplyTo.Int32)
+		parentH, err = subH.GetEssayH(ctx, parentH.ID(), userH)
+		require.Nil(err)
+		parent, err := parentH.ReadView(ctx)
+		require.Nil(err)
+		require.Empty(parent.Thesis)
+		require.True(parent.RemovalReason.Valid)
+		require.Equal(models.DeletedUserName, parent.AttributedToName)
+
+		// Anonymized essays stay
+		_, sub3H, err := joinMockSub(ctx, db, user3H)
+		require.Nil(err)
+		_, err = sub3H.CreateEssay(ctx, mockEssay(user3.ID))
+		require.Nil(err)
+		require.Equal(models.ErrInvalidDeletion, user3H.Delete(ctx, ""))
+		require.Nil(user3H.Delete(ctx, models.EssayDeletionAnonymize))
+		essays, err = subH.ListEssays(ctx)
+		require.Nil(err)
+		require.Len(essays, 2)
+		require.Equal(0, essays[0].AttributedToID)
+		require.Equal(models.DeletedUserName, essays[0].AttributedToName)
+
+		// The admin can't be deleted by who doesn't outrank them
+		require.Equal(models.ErrRoleRank, disceptoH.DeleteUser(ctx, user.ID, models.EssayDeletionAnonymize))
+		return nil
+	})
+	require.Nil(err)
+}
+func TestPasswdReset(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		generation, err := userH.SessionGeneration(ctx)
+		require.Nil(err)
+
+		_, _, err = db.RequestPasswdReset(ctx, "nobody@example.com")
+		require.Equal(pgx.ErrNoRows, err)
+		resetUser, token, err := db.RequestPasswdReset(ctx, user.Email)
+		require.Nil(err)
+		require.Equal(user.ID, resetUser.ID)
+		require.NotEmpty(token)
+
+		require.Equal(models.ErrInvalidResetToken, db.ResetPasswd(ctx, "wrong", "n3wPassword!"))
+		require.Equal(models.ErrWeakPasswd, db.ResetPasswd(ctx, token, "weak"))
+		require.Nil(db.ResetPasswd(ctx, token, "n3wPassword!"))
+
+		// Single use
+		require.Equal(models.ErrInvalidResetToken, db.ResetPasswd(ctx, token, "an0therPassword!"))
+		_, err = db.Login(ctx, user.Email, mockPasswd)
+		require.NotNil(err)
+		_, err = db.Login(ctx, user.Email, "n3wPassword!")
+		require.Nil(err)
+
+		// Old sessions are invalidated
+		newGeneration, err := userH.SessionGeneration(ctx)
+		require.Nil(err)
+		require.NotEqual(generation, newGeneration)
+
+		// Rate limited
+		for i := 1; i < LimitPasswdResets; i++ {
+			_, _, err = db.RequestPasswdReset(ctx, user.Email)
+			require.Nil(err)
+		}
+		_, _, err = db.RequestPasswdReset(ctx, user.Email)
+		require.Equal(models.ErrTooManyResets, err)
+		return nil
+	})
+	require.Nil(err)
+}
+func TestEmailVerification(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+
+	err := execTx(ctx, db.db, func(ctx context.Context, tx DBTX) error {
+		db := db.withTx(tx)
+		user := mockUser()
+		userH, err := createVerifiedUser(ctx, db, user)
+		require.Nil(err)
+		user2 := mockUser2()
+		user2H, err := db.CreateUser(ctx, user2, mockPasswd)
+		require.Nil(err)
+
+		disceptoH, err := db.GetDisceptoH(ctx, userH)
+		require.Nil(err)
+		_, err = disceptoH.CreateSubdiscepto(ctx, *userH, mockSubdisceptoReq())
+		require.Nil(err)
+
+		// Unverified users can't post nor vote
+		verified, err := user2H.EmailVerified(ctx)
+		require.Nil(err)
+		require.False(verified)
+		dis2H, err := db.GetDisceptoH(ctx, user2H)
+		require.Nil(err)
+		require.False(dis2H.Perms().Check(models.PermCreateVote))
+		_, sub2H, err := joinMockSub(ctx, db, user2H)
+		require.Nil(err)
+		require.True(sub2H.Perms().Denies(models.PermCreateEssay))
+		_, err = sub2H.CreateEssay(ctx, mockEssay(user2.ID))
+		require.NotNil(err)
+
+		_, token, err := user2H.RequestEmailVerification(ctx)
+		require.Nil(err)
+		require.Equal(models.ErrInvalidVerifyToken, db.VerifyEmail(ctx, "wrong"))
+		require.Nil(db.VerifyEmail(ctx, token))
+		require.Equal(models.ErrInvalidVerifyToken, db.VerifyEmail(ctx, token))
+		_, _, err = user2H.RequestEmailVerification(ctx)
+		require.Equal(models.ErrAlreadyVerified, err)
+
+		dis2H, sub2H, err = getMockSub(ctx, db, user2H)
+		require.Nil(err)
+		require.Nil(sub2H.Perms().Require(models.PermCreateEssay))
+
+		// The email changes once the new address is verified
+		_, err = user2H.RequestEmailChange(ctx, user.Email)
+		require.Equal(models.ErrEmailAlreadyUsed, err)
+		_, err = user2H.RequestEmailChange(ctx, "invalid")
+		require.Equal(models.ErrInvalidFormat, err)
+		token, err = user2H.RequestEmailChange(ctx, "new@example.com")
+		require.Nil(err)
+		read, err := user2H.Read(ctx)
+		require.Nil(err)
+		require.Equal(user2.Email, read.Email)
+		require.Nil(db.VerifyEmail(ctx, token))
+		read, err = user2H.Read(ctx)
+		require.Nil(err)
+		require.Equal("new@example.com", read.Email)
+
+		// Admins see who is verified and can resend or force the verification
+		user3 := &models.User{Name: "User3", Email: "user3@example.com"}
+		_, err = db.CreateUser(ctx, user3, mockPasswd)
+		require.Nil(err)
+		members, err := disceptoH.ListMembers(ctx)
+		require.Nil(err)
+		require.Len(members, 3)
+		require.Equal(models.Member{UserID: user3.ID, Name: user3.Name, EmailVerified: sql.NullBool{Bool: false, Valid: true}},
+			models.Member{UserID: members[2].UserID, Name: members[2].Name, EmailVerified: members[2].EmailVerified})
+
+		_, _, err = dis2H.ResendEmailVerification(ctx, user3.ID)
+		require.NotNil(err)
+		for i := 0; i < LimitEmailVerifications; i++ {
+			_, _, err = disceptoH.ResendEmailVerification(ctx, user3.ID)
+			require.Nil(err)
+		}
+		_, _, err = disceptoH.ResendEmailVerification(ctx, user3.ID)
+		require.Equal(models.ErrTooManyVerifyMails, err)
+
+		require.Nil(disceptoH.ForceVerifyEmail(ctx, user3.ID))
+		require.Equal(models.ErrAlreadyVerified, disceptoH.ForceVerifyEmail(ctx, user3.ID))
+		return nil
+	})
+	require.Nil(err)
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"fmt"
+	"math"
 	"regexp"
 
 	sq "github.com/Masterminds/squirrel"
@@ -16,20 +17,67 @@ type DisceptoH struct {
 	sharedDB     DBTX
 	globalPerms  models.Perms
 	notifService models.NotificationService
+	audit        auditor
+	ban          *models.Ban
+	nsfwPref     models.NsfwPref
+	// Rank of the highest global role of the user
+	rank int
+	// Zero for anonymous users
+	userID int
 }
 
 func (sdb *SharedDB) GetDisceptoH(ctx context.Context, uH *UserH) (*DisceptoH, error) {
 	globalPerms := models.NewPerms()
+	nsfwPref := models.NsfwHide
+	rank := 0
+	userID := 0
+	var ban *models.Ban
 	if uH != nil {
-		perms, err := getUserPerms(ctx, sdb.db, models.RoleDomainDiscepto, uH.id)
+		userID = uH.id
+		var err error
+		ban, err = findActiveBan(ctx, sdb.db, models.RoleDomainDiscepto, uH.id)
 		if err != nil {
 			return nil, err
 		}
-		globalPerms = perms
+		nsfwPref, err = uH.ReadNsfwPref(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Banned users keep no permission
+		if ban == nil {
+			globalPerms, err = getUserPerms(ctx, sdb.db, models.RoleDomainDiscepto, uH.id)
+			if err != nil {
+				return nil, err
+			}
+		}
+		verified, err := uH.EmailVerified(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !verified {
+			globalPerms = globalPerms.Deny(models.PermsDeniedUnverified...)
+		}
+		// The rank matters only to who manages users
+		if globalPerms.Check(models.PermManageGlobalRole) || globalPerms.Check(models.PermBanUserGlobally) ||
+			globalPerms.Check(models.PermDeleteUser) {
+			rank, err = getUserRank(ctx, sdb.db, models.RoleDomainDiscepto, uH.id)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	notifService := NewNotificationService(sdb.db)
-	dH := &DisceptoH{globalPerms: globalPerms, sharedDB: sdb.db, notifService: notifService}
+	dH := &DisceptoH{
+		globalPerms:  globalPerms,
+		sharedDB:     sdb.db,
+		notifService: notifService,
+		audit:        newAuditor(uH, models.RoleDomainDiscepto),
+		ban:          ban,
+		nsfwPref:     nsfwPref,
+		rank:         rank,
+		userID:       userID,
+	}
 	var err error
 	rolesH, err := dH.buildRolesH()
 	if err == nil {
@@ -47,16 +95,29 @@ func (h *DisceptoH) buildRolesH() (*RolesH, error) {
 		contextPerms: h.globalPerms,
 		rolesPerms:   ps,
 		domain:       models.RoleDomainDiscepto,
+		rank:         h.rank,
 		sharedDB:     h.sharedDB,
+		audit:        h.audit,
 	}
 	return rolesH, nil
 }
 func (h *DisceptoH) Perms() models.Perms {
 	return h.globalPerms
 }
+func (h *DisceptoH) NsfwPref() models.NsfwPref {
+	return h.nsfwPref
+}
+
+// ConfirmAge lets an anonymous visitor, who confirmed being an adult,
+// see NSFW content (blurred)
+func (h *DisceptoH) ConfirmAge() {
+	if h.nsfwPref == models.NsfwHide {
+		h.nsfwPref = models.NsfwBlur
+	}
+}
 func (h *DisceptoH) ListMembers(ctx context.Context) ([]models.Member, error) {
 	sqlquery, args, _ := psql.
-		Select("users.id AS user_id", "users.name").
+		Select("users.id AS user_id", "users.name", "users.email_verified_at IS NOT NULL AS email_verified").
 		From("users").
 		OrderBy("users.id").
 		ToSql()
@@ -87,11 +148,16 @@ func (h *DisceptoH) GetSubdisceptoH(ctx context.Context, subdiscepto string, uH
 		sharedDB:     h.sharedDB,
 		rawSub:       rawSub,
 		notifService: h.notifService,
+		nsfwPref:     h.nsfwPref,
 	}
 
 	var subPerms models.Perms
 
 	if uH != nil && h.globalPerms.Check(models.PermUseLocalPermissions) {
+		subH.ban, err = findActiveBan(ctx, h.sharedDB, subH.rawSub.RoledomainID, uH.id)
+		if err != nil {
+			return nil, err
+		}
 		// First, try getting user's permissions
 		perms, err := getUserPerms(ctx, h.sharedDB, subH.rawSub.RoledomainID, uH.id)
 		if err != nil {
@@ -108,15 +174,33 @@ func (h *DisceptoH) GetSubdisceptoH(ctx context.Context, subdiscepto string, uH
 		subPerms = subPerms.Union(toAdd)
 	}
 
-	if err := subPerms.Require(models.PermReadSubdiscepto); err != nil {
+	if subH.ban != nil {
+		// Banned users keep no permission inside the subdiscepto
+		subPerms = models.NewPerms()
+	} else if err := subPerms.Require(models.PermReadSubdiscepto); err != nil {
 		return nil, err
 	}
 
+	// Global role managers outrank everyone inside subdisceptos.
+	// The others are bounded by their highest role in the subdiscepto.
+	rank := 0
+	if h.globalPerms.Check(models.PermManageGlobalRole) {
+		rank = math.MaxInt32
+	} else if uH != nil && subH.ban == nil &&
+		(subPerms.Check(models.PermManageRole) || subPerms.Check(models.PermBanUser)) {
+		rank, err = getUserRank(ctx, h.sharedDB, subH.rawSub.RoledomainID, uH.id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	rolesH := RolesH{
 		contextPerms: subPerms,
-		rolesPerms:   subPerms, // TODO: fix subPerms may contain only ManageGlobalRole
+		rolesPerms:   subPerms,
 		domain:       subH.rawSub.RoledomainID,
+		rank:         rank,
 		sharedDB:     h.sharedDB,
+		audit:        newAuditor(uH, subH.rawSub.RoledomainID),
 	}
 	subH.RolesH = rolesH
 	subH.subPerms = subPerms
@@ -137,8 +221,20 @@ func (h *DisceptoH) ListAvailablePerms() models.Perms {
 }
 func (h *DisceptoH) createSubdiscepto(ctx context.Context, uH UserH, subd *models.SubdisceptoReq) (*SubdisceptoH, error) {
 	firstUserID := uH.id
+	joinMode, err := parseJoinMode(subd.JoinMode)
+	if err != nil {
+		return nil, err
+	}
+	preset := subd.RolePreset
+	if preset == "" {
+		preset = models.RolePresetDefault
+	}
+	template, ok := models.RoleTemplatePresets[preset]
+	if !ok {
+		return nil, models.ErrInvalidRoleTemplate
+	}
 	var subH *SubdisceptoH
-	err := execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+	err = execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
 		// Retrieve real roledomain
 		roledomain, err := createRoledomain(ctx, tx, "subdiscepto")
 		if err != nil {
@@ -152,14 +248,24 @@ func (h *DisceptoH) createSubdiscepto(ctx context.Context, uH UserH, subd *model
 			QuestionsRequired: subd.QuestionsRequired,
 			Nsfw:              subd.Nsfw,
 			Public:            subd.Public,
+			WeightedVotes:     subd.WeightedVotes,
+			PremodMinDays:     subd.PremodMinDays,
+			PremodMinKarma:    subd.PremodMinKarma,
+			JoinMode:          joinMode,
 		}
 
 		// Init subH
+		rank := models.RoleRankAdmin
+		if h.globalPerms.Check(models.PermManageGlobalRole) {
+			rank = math.MaxInt32
+		}
 		rolesH := RolesH{
 			contextPerms: models.PermsSubAdmin,
 			rolesPerms:   models.PermsSubAdmin,
 			domain:       roledomain,
+			rank:         rank,
 			sharedDB:     h.sharedDB,
+			audit:        newAuditor(&uH, roledomain),
 		}
 		subH = &SubdisceptoH{
 			sharedDB:     h.sharedDB,
@@ -167,6 +273,7 @@ func (h *DisceptoH) createSubdiscepto(ctx context.Context, uH UserH, subd *model
 			RolesH:       rolesH,
 			subPerms:     models.PermsSubAdmin.Union(h.Perms()),
 			notifService: h.notifService,
+			nsfwPref:     h.nsfwPref,
 		}
 
 		err = insertSubdiscepto(ctx, tx, *rawSub)
@@ -179,38 +286,19 @@ func (h *DisceptoH) createSubdiscepto(ctx context.Context, uH UserH, subd *model
 			return err
 		}
 
-		// init roles
-		roles := []struct {
-			roleData models.Role
-			perms    models.Perms
-			assign   bool
-		}{
-			{
-				models.Role{Name: "common", Preset: false},
-				models.PermsSubCommon,
-				true,
-			},
-			{
-				models.Role{Name: "common-after-rejoin", Preset: true},
-				models.NewPerms(
-					models.PermCommonAfterRejoin,
-				),
-				false,
-			},
-			{
-				models.Role{Name: "admin", Preset: true},
-				models.PermsSubAdmin,
-				true,
-			},
-		}
-
-		for _, r := range roles {
-			r.roleData.Domain = roledomain
-			roleID, err := createRole(ctx, tx, r.roleData, r.perms)
+		// init roles. The first user gets the common and admin roles.
+		for _, r := range template.Roles {
+			role := models.Role{
+				Domain: roledomain,
+				Name:   r.Name,
+				Preset: r.Name == "common-after-rejoin" || r.Name == "admin",
+				Rank:   r.Rank,
+			}
+			roleID, err := createRole(ctx, tx, role, models.NewPerms(r.Perms...))
 			if err != nil {
 				return err
 			}
-			if r.assign {
+			if r.Name == "common" || r.Name == "admin" {
 				err = assignRole(ctx, tx, firstUserID, roleID)
 			}
 			if err != nil {
@@ -227,35 +315,61 @@ func (h *DisceptoH) createSubdiscepto(ctx context.Context, uH UserH, subd *model
 }
 func (h *DisceptoH) DeleteReport(ctx context.Context, report *models.Report) error {
 	// TODO: What kind of permission should one have to view reports?
-	sql, args, _ := psql.
-		Delete("reports").
-		Where(sq.Eq{"id": report.ID}).
-		ToSql()
-
-	_, err := h.sharedDB.Exec(ctx, sql, args...)
-	if err != nil {
-		return err
-	}
-	return nil
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		before, err := deleteReport(ctx, tx, sq.Eq{"id": report.ID})
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditReportDelete, fmt.Sprintf("report %d", report.ID), before, nil)
+	})
 }
 func (h *DisceptoH) ListRecentEssaysIn(ctx context.Context, subsViews []models.SubdisceptoView) ([]models.EssayView, error) {
 	subs := []string{}
 	for _, s := range subsViews {
 		subs = append(subs, s.Name)
 	}
+	query, err := h.hideMembersOnly(ctx, selectEssayWithJoins)
+	if err != nil {
+		return nil, err
+	}
 	essayPreviews := []models.EssayView{}
-	sql, args, _ := selectEssayWithJoins.
-		Where(sq.Eq{"posted_in": subs}).
+	sql, args, _ := filterNsfw(query, h.nsfwPref).
+		Where(sq.Eq{"posted_in": subs, "essays.removed_at": nil, "essays.held_at": nil}).
 		GroupBy("essays.id", "essay_replies.from_id", "users.name").
 		OrderBy("essays.id DESC").
 		ToSql()
 
-	err := pgxscan.Select(ctx, h.sharedDB, &essayPreviews, sql, args...)
+	err = pgxscan.Select(ctx, h.sharedDB, &essayPreviews, sql, args...)
 	if err != nil {
 		return nil, err
 	}
 	return essayPreviews, nil
 }
+
+// hideMembersOnly leaves out the members-only essays of the subdisceptos
+// where the user can't read them
+func (h *DisceptoH) hideMembersOnly(ctx context.Context, query sq.SelectBuilder) (sq.SelectBuilder, error) {
+	if h.globalPerms.Check(models.PermReadEssay) {
+		return query, nil
+	}
+	if h.userID == 0 || !h.globalPerms.Check(models.PermUseLocalPermissions) {
+		return query.Where(sq.Eq{"essays.members_only": false}), nil
+	}
+	readableSubs, err := listDomainsWithPerms(ctx, h.sharedDB, h.userID, "subdiscepto", models.NewPerms(
+		models.PermReadEssay,
+	))
+	if err != nil {
+		return query, err
+	}
+	subquery, args, _ := sq.Select("name").
+		From("subdisceptos").
+		Where(sq.Eq{"roledomain_id": readableSubs}).
+		ToSql()
+	return query.Where(sq.Or{
+		sq.Eq{"essays.members_only": false},
+		sq.Expr("essays.posted_in IN ("+subquery+")", args...),
+	}), nil
+}
 func (h *DisceptoH) ListUserSubdisceptos(ctx context.Context, userH *UserH) ([]models.SubdisceptoView, error) {
 	if err := h.globalPerms.Require(models.PermUseLocalPermissions); err != nil {
 		// Intentionally return empty array
@@ -284,13 +398,20 @@ func (h *DisceptoH) ListUserSubdisceptos(ctx context.Context, userH *UserH) ([]m
 	}
 	return subs, nil
 }
-func (sdb *SharedDB) ListSubdisceptos(ctx context.Context, userH *UserH) ([]models.SubdisceptoView, error) {
+
+// ListSubdisceptos lists the public subdisceptos. NSFW ones are included only
+// when nsfwPref allows them.
+func (sdb *SharedDB) ListSubdisceptos(ctx context.Context, userH *UserH, nsfwPref models.NsfwPref) ([]models.SubdisceptoView, error) {
 	var subs []models.SubdisceptoView
 	var userID *int
 	if userH != nil {
 		userID = &userH.id
 	}
-	sql, args, _ := selectSubdiscepto(userID).Where(sq.Eq{"public": true}).ToSql()
+	where := sq.Eq{"public": true}
+	if nsfwPref == models.NsfwHide {
+		where["nsfw"] = false
+	}
+	sql, args, _ := selectSubdiscepto(userID).Where(where).ToSql()
 	err := pgxscan.Select(ctx, sdb.db, &subs, sql, args...)
 	if err != nil {
 		return nil, err
@@ -298,11 +419,15 @@ func (sdb *SharedDB) ListSubdisceptos(ctx context.Context, userH *UserH) ([]mode
 	return subs, nil
 }
 func (h *DisceptoH) SearchByTags(ctx context.Context, tags []string) ([]models.EssayView, error) {
-	sql, args, _ := selectEssayWithJoins.
+	query, err := h.hideMembersOnly(ctx, selectEssayWithJoins)
+	if err != nil {
+		return nil, err
+	}
+	sql, args, _ := filterNsfw(query, h.nsfwPref).
 		Join("essay_tags ON essays.id = essay_tags.essay_id").
 		Join("subdisceptos ON subdisceptos.name = essays.posted_in").
 		GroupBy("essays.id", "essay_replies.from_id", "users.name").
-		Where(sq.Eq{"subdisceptos.public": true, "essay_tags.tag": tags}).
+		Where(sq.Eq{"subdisceptos.public": true, "essay_tags.tag": tags, "essays.removed_at": nil, "essays.held_at": nil}).
 		OrderBy("essays.id DESC").
 		ToSql()
 
@@ -330,16 +455,22 @@ func scanEssays(ctx context.Context, rows pgx.Rows, tags []string) ([]models.Ess
 			essay.Tags = append(essay.Tags, tmp.Tag)
 		} else {
 			essayMap[tmp.ID] = &models.EssayView{
-				ID:               tmp.ID,
-				Thesis:           tmp.Thesis,
-				Content:          tmp.Content,
-				Published:        tmp.Published,
-				PostedIn:         tmp.PostedIn,
-				AttributedToID:   tmp.AttributedToID,
-				AttributedToName: tmp.AttributedToName,
-				Upvotes:          tmp.Upvotes,
-				Downvotes:        tmp.Downvotes,
-				Tags:             []string{tmp.Tag},
+				ID:                    tmp.ID,
+				Thesis:                tmp.Thesis,
+				Content:               tmp.Content,
+				Published:             tmp.Published,
+				PostedIn:              tmp.PostedIn,
+				AttributedToID:        tmp.AttributedToID,
+				AttributedToName:      tmp.AttributedToName,
+				Upvotes:               tmp.Upvotes,
+				Downvotes:             tmp.Downvotes,
+				Persuasions:           tmp.Persuasions,
+				PersuadedParentAuthor: tmp.PersuadedParentAuthor,
+				RemovalReason:         tmp.RemovalReason,
+				HeldReason:            tmp.HeldReason,
+				Nsfw:                  tmp.Nsfw,
+				MembersOnly:           tmp.MembersOnly,
+				Tags:                  []string{tmp.Tag},
 				Replying: models.Replying{
 					InReplyTo: tmp.InReplyTo,
 					ReplyType: tmp.ReplyType,
@@ -356,23 +487,31 @@ func scanEssays(ctx context.Context, rows pgx.Rows, tags []string) ([]models.Ess
 	return essays, nil
 }
 func (h *DisceptoH) SearchByThesis(ctx context.Context, title string) ([]models.EssayView, error) {
-	sql, args, _ := selectEssayWithJoins.
+	query, err := h.hideMembersOnly(ctx, selectEssayWithJoins)
+	if err != nil {
+		return nil, err
+	}
+	sql, args, _ := filterNsfw(query, h.nsfwPref).
 		Join("subdisceptos ON subdisceptos.name = essays.posted_in").
-		Where("subdisceptos.public = true AND essays.thesis ILIKE $1", fmt.Sprintf(`%%%s%%`, title)).
+		Where("subdisceptos.public = true AND essays.removed_at IS NULL AND essays.held_at IS NULL AND essays.thesis ILIKE ?", fmt.Sprintf(`%%%s%%`, title)).
 		GroupBy("essays.id", "essay_replies.from_id", "users.name").
 		OrderBy("essays.id DESC").
 		ToSql()
 
 	essays := []models.EssayView{}
 
-	err := pgxscan.Select(ctx, h.sharedDB, &essays, sql, args...)
+	err = pgxscan.Select(ctx, h.sharedDB, &essays, sql, args...)
 	if err != nil {
 		return nil, err
 	}
 	return essays, nil
 }
 func (h *DisceptoH) ListUserEssays(ctx context.Context, userID int) ([]models.EssayView, error) {
-	return listUserEssays(ctx, h.sharedDB, userID)
+	query, err := h.hideMembersOnly(ctx, selectEssayWithJoins)
+	if err != nil {
+		return nil, err
+	}
+	return listUserEssays(ctx, h.sharedDB, query, userID)
 }
 func (h *DisceptoH) ListNotifs(ctx context.Context, userH *UserH) ([]models.NotifView, error) {
 	if !userH.perms.Read {
@@ -394,10 +533,14 @@ func readPublicUser(ctx context.Context, db DBTX, userID int) (*models.UserView,
 			"users.id",
 			"users.created_at",
 			"SUM(CASE votes.vote_type WHEN 'upvote' THEN 1 ELSE 0 END) AS karma",
+			`(SELECT COUNT(*) FROM persuasions
+				JOIN essays ON essays.id = persuasions.essay_id
+				WHERE essays.attributed_to_id = users.id
+			) AS persuasions`,
 		).
 		From("users").
 		LeftJoin("essays ON essays.attributed_to_id = users.id").
-		LeftJoin("votes ON essays.id = votes.essay_id").
+		LeftJoin("votes ON essays.id = votes.essay_id AND NOT votes.nullified").
 		GroupBy("users.id").
 		Where(sq.Eq{"users.id": userID}).
 		ToSql()
@@ -407,6 +550,10 @@ func readPublicUser(ctx context.Context, db DBTX, userID int) (*models.UserView,
 		db, user,
 		sql, args...)
 
+	if err != nil {
+		return nil, err
+	}
+	user.Badges, err = listUserBadges(ctx, db, userID)
 	if err != nil {
 		return nil, err
 	}
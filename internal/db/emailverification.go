@@ -0,0 +1,231 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"gitlab.com/ranfdev/discepto/internal/models"
+	"gitlab.com/ranfdev/discepto/internal/utils"
+)
+
+const (
+	// How long an email verification token can be used
+	EmailVerifyTTL = 24 * time.Hour
+	// Verification emails allowed for each account in EmailVerifyWindow
+	LimitEmailVerifications, EmailVerifyWindow = 3, 1 * time.Hour
+)
+
+// createEmailVerification returns a token verifying the email for the user.
+// The token must be mailed to the address: only its hash is stored.
+func createEmailVerification(ctx context.Context, db DBTX, userID int, email string) (string, error) {
+	token := utils.GenToken(32)
+	err := execTx(ctx, db, func(ctx context.Context, tx DBTX) error {
+		// Serialize the requests of the same user
+		sql, args, _ := psql.
+			Select("id").
+			From("users").
+			Where(sq.Eq{"id": userID}).
+			Suffix("FOR UPDATE").
+			ToSql()
+
+		_, err := tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+
+		sql, args, _ = psql.
+			Select("COUNT(*)").
+			From("email_verifications").
+			Where(sq.Eq{"user_id": userID}).
+			Where("created_at > NOW() - make_interval(secs => ?)", EmailVerifyWindow.Seconds()).
+			ToSql()
+
+		var count int
+		err = tx.QueryRow(ctx, sql, args...).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count >= LimitEmailVerifications {
+			return models.ErrTooManyVerifyMails
+		}
+
+		sql, args, _ = psql.
+			Insert("email_verifications").
+			Columns("token_hash", "user_id", "email", "expires_at").
+			Values(hashToken(token), userID, email, sq.Expr("NOW() + make_interval(secs => ?)", EmailVerifyTTL.Seconds())).
+			ToSql()
+
+		_, err = tx.Exec(ctx, sql, args...)
+		return err
+	})
+	return token, err
+}
+
+// requestEmailVerification returns a token to verify the current email of the user
+func requestEmailVerification(ctx context.Context, db DBTX, userID int) (*models.User, string, error) {
+	sql, args, _ := psql.
+		Select("id", "name", "email").
+		From("users").
+		Where(sq.Eq{"id": userID}).
+		Where("email_verified_at IS NULL").
+		ToSql()
+
+	user := &models.User{}
+	err := pgxscan.Get(ctx, db, user, sql, args...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, "", models.ErrAlreadyVerified
+	} else if err != nil {
+		return nil, "", err
+	}
+	token, err := createEmailVerification(ctx, db, userID, user.Email)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, token, nil
+}
+
+// VerifyEmail verifies the email matching the token, replacing
+// the email of the user if it was a change. The other pending tokens
+// of the user stop working.
+func (sdb *SharedDB) VerifyEmail(ctx context.Context, token string) error {
+	return execTx(ctx, sdb.db, func(ctx context.Context, tx DBTX) error {
+		sql, args, _ := psql.
+			Select("user_id", "email").
+			From("email_verifications").
+			Where(sq.Eq{"token_hash": hashToken(token), "used_at": nil}).
+			Where("expires_at > NOW()").
+			Suffix("FOR UPDATE").
+			ToSql()
+
+		var userID int
+		var email string
+		err := tx.QueryRow(ctx, sql, args...).Scan(&userID, &email)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.ErrInvalidVerifyToken
+		} else if err != nil {
+			return err
+		}
+
+		sql, args, _ = psql.
+			Update("users").
+			Set("email", email).
+			Set("email_verified_at", sq.Expr("NOW()")).
+			Where(sq.Eq{"id": userID}).
+			ToSql()
+
+		_, err = tx.Exec(ctx, sql, args...)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.ConstraintName == "users_email_key" {
+			return models.ErrEmailAlreadyUsed
+		} else if err != nil {
+			return err
+		}
+		return consumeEmailVerifications(ctx, tx, userID)
+	})
+}
+func consumeEmailVerifications(ctx context.Context, db DBTX, userID int) error {
+	sql, args, _ := psql.
+		Update("email_verifications").
+		Set("used_at", sq.Expr("NOW()")).
+		Where(sq.Eq{"user_id": userID, "used_at": nil}).
+		ToSql()
+
+	_, err := db.Exec(ctx, sql, args...)
+	return err
+}
+func emailVerified(ctx context.Context, db DBTX, userID int) (bool, error) {
+	sql, args, _ := psql.
+		Select("email_verified_at IS NOT NULL").
+		From("users").
+		Where(sq.Eq{"id": userID}).
+		ToSql()
+
+	var verified bool
+	err := db.QueryRow(ctx, sql, args...).Scan(&verified)
+	return verified, err
+}
+
+// EmailVerified tells if the user verified their email.
+// Until then, they can't post nor vote.
+func (h UserH) EmailVerified(ctx context.Context) (bool, error) {
+	if !h.perms.Read {
+		return false, models.ErrPermDenied
+	}
+	return emailVerified(ctx, h.sharedDB, h.id)
+}
+
+// RequestEmailVerification returns a token to verify the current email of the user
+func (h UserH) RequestEmailVerification(ctx context.Context) (*models.User, string, error) {
+	if !h.perms.Read {
+		return nil, "", models.ErrPermDenied
+	}
+	return requestEmailVerification(ctx, h.sharedDB, h.id)
+}
+
+// RequestEmailChange returns a token to verify the new email.
+// The email of the user changes only once verified.
+func (h UserH) RequestEmailChange(ctx context.Context, email string) (string, error) {
+	if !h.perms.Read {
+		return "", models.ErrPermDenied
+	}
+	if !utils.ValidateEmail(email) {
+		return "", models.ErrInvalidFormat
+	}
+	sql, args, _ := psql.
+		Select("1").
+		From("users").
+		Where(sq.Eq{"email": email}).
+		ToSql()
+
+	var exists int
+	err := h.sharedDB.QueryRow(ctx, sql, args...).Scan(&exists)
+	if err == nil {
+		return "", models.ErrEmailAlreadyUsed
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return "", err
+	}
+	return createEmailVerification(ctx, h.sharedDB, h.id, email)
+}
+
+// ResendEmailVerification returns a new token to verify the email of another user
+func (h *DisceptoH) ResendEmailVerification(ctx context.Context, userID int) (*models.User, string, error) {
+	if err := h.globalPerms.Require(models.PermManageGlobalRole); err != nil {
+		return nil, "", err
+	}
+	return requestEmailVerification(ctx, h.sharedDB, userID)
+}
+
+// ForceVerifyEmail marks the email of the user as verified, without a token
+func (h *DisceptoH) ForceVerifyEmail(ctx context.Context, userID int) error {
+	if err := h.globalPerms.Require(models.PermManageGlobalRole); err != nil {
+		return err
+	}
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		sql, args, _ := psql.
+			Update("users").
+			Set("email_verified_at", sq.Expr("NOW()")).
+			Where(sq.Eq{"id": userID, "email_verified_at": nil}).
+			Suffix("RETURNING email").
+			ToSql()
+
+		var email string
+		err := tx.QueryRow(ctx, sql, args...).Scan(&email)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.ErrAlreadyVerified
+		} else if err != nil {
+			return err
+		}
+		err = consumeEmailVerifications(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditUserVerify, fmt.Sprintf("user %d", userID),
+			nil, map[string]string{"email": email})
+	})
+}
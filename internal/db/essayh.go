@@ -2,10 +2,12 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
 
 	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
 
 	sq "github.com/Masterminds/squirrel"
 	"gitlab.com/ranfdev/discepto/internal/models"
@@ -16,6 +18,8 @@ type EssayH struct {
 	id           int
 	essayPerms   models.Perms
 	notifService models.NotificationService
+	audit        auditor
+	nsfw         bool
 }
 
 func isEssayOwner(ctx context.Context, db DBTX, essayID int, userID int) bool {
@@ -35,26 +39,69 @@ func isEssayOwner(ctx context.Context, db DBTX, essayID int, userID int) bool {
 	return isOwner == 1
 }
 
+// Essays of deleted users have no author
+const authorIDColumn = "COALESCE(essays.attributed_to_id, 0) AS attributed_to_id"
+
+var authorNameColumn = fmt.Sprintf("COALESCE(users.name, '%s') AS attributed_to_name", models.DeletedUserName)
+
 var selectEssay = psql.
 	Select(
 		"essays.id",
-		"essays.thesis",
-		"essays.content",
-		"essays.attributed_to_id",
+		// The content of removed essays is hidden, but they stay in place to keep the reply thread
+		"CASE WHEN essays.removed_at IS NULL THEN essays.thesis ELSE '' END AS thesis",
+		"CASE WHEN essays.removed_at IS NULL THEN essays.content ELSE '' END AS content",
+		"essays.removal_reason",
+		"essays.held_reason",
+		"essays.nsfw OR EXISTS(SELECT 1 FROM subdisceptos WHERE subdisceptos.name = essays.posted_in AND subdisceptos.nsfw) AS nsfw",
+		"essays.members_only",
+		authorIDColumn,
 		"essays.published",
 		"essays.posted_in",
 		"SUM(CASE votes.vote_type WHEN 'upvote' THEN 1 ELSE 0 END) AS upvotes",
 		"SUM(CASE votes.vote_type WHEN 'downvote' THEN 1 ELSE 0 END) AS downvotes",
 		"essay_replies.to_id AS in_reply_to",
 		"essay_replies.reply_type AS reply_type",
-		"users.name AS attributed_to_name",
+		authorNameColumn,
+		"(SELECT COUNT(*) FROM persuasions WHERE persuasions.essay_id = essays.id) AS persuasions",
+		`EXISTS(SELECT 1 FROM persuasions
+			JOIN essays AS parent ON parent.id = essay_replies.to_id
+			WHERE persuasions.essay_id = essays.id AND persuasions.user_id = parent.attributed_to_id
+		) AS persuaded_parent_author`,
 	)
+
 var selectEssayWithJoins = selectEssay.
 	From("essays").
 	LeftJoin("essay_replies ON essay_replies.from_id = essays.id").
-	LeftJoin("votes ON votes.essay_id = essays.id").
+	LeftJoin("votes ON votes.essay_id = essays.id AND NOT votes.nullified").
 	LeftJoin("users ON essays.attributed_to_id = users.id")
 
+// filterNsfw leaves out NSFW essays, and the essays of NSFW subdisceptos,
+// unless the viewer wants to see them
+func filterNsfw(query sq.SelectBuilder, pref models.NsfwPref) sq.SelectBuilder {
+	if pref != models.NsfwHide {
+		return query
+	}
+	return query.Where(`NOT essays.nsfw AND NOT EXISTS(
+		SELECT 1 FROM subdisceptos WHERE subdisceptos.name = essays.posted_in AND subdisceptos.nsfw
+	)`)
+}
+
+// filterMembersOnly leaves out members-only essays, for viewers without
+// the read_essay permission
+func filterMembersOnly(query sq.SelectBuilder, perms models.Perms) sq.SelectBuilder {
+	if perms.Check(models.PermReadEssay) {
+		return query
+	}
+	return query.Where(sq.Eq{"essays.members_only": false})
+}
+
+// Score of an essay where every vote is weighted by the voter's local reputation
+// (see the vote_weight SQL function). Requires the same joins of selectEssayWithJoins.
+const weightedScoreColumn = `SUM(CASE votes.vote_type
+	WHEN 'upvote' THEN vote_weight(votes.user_id, essays.posted_in)
+	WHEN 'downvote' THEN -vote_weight(votes.user_id, essays.posted_in)
+	ELSE 0 END) AS weighted_score`
+
 func (h *EssayH) Perms() models.Perms {
 	return h.essayPerms
 }
@@ -81,7 +128,8 @@ func (h EssayH) CountReplies(ctx context.Context) (map[string]int, error) {
 	}
 	sql, args, _ := psql.Select("reply_type", "COUNT(reply_type)").
 		From("essay_replies").
-		Where(sq.Eq{"to_id": h.id}).
+		Join("essays ON essays.id = essay_replies.from_id").
+		Where(sq.Eq{"to_id": h.id, "essays.held_at": nil}).
 		GroupBy("reply_type").
 		ToSql()
 
@@ -104,6 +152,11 @@ func (h EssayH) CountReplies(ctx context.Context) (map[string]int, error) {
 func (h EssayH) ID() int {
 	return h.id
 }
+
+// Nsfw tells whether the essay itself is marked as NSFW
+func (h EssayH) Nsfw() bool {
+	return h.nsfw
+}
 func (h EssayH) CreateReport(ctx context.Context, rep models.Report, userH UserH) error {
 	if err := h.essayPerms.Require(models.PermCreateReport); err != nil {
 		return err
@@ -111,10 +164,13 @@ func (h EssayH) CreateReport(ctx context.Context, rep models.Report, userH UserH
 	if rep.EssayID != h.id || rep.FromUserID != userH.id {
 		return models.ErrPermDenied
 	}
+	if !rep.FlagType.Valid() || len(rep.Description) > 500 {
+		return models.ErrInvalidReport
+	}
 	sql, args, _ := psql.
 		Insert("reports").
-		Columns("essay_id", "from_user_id", "description").
-		Values(h.id, userH.id, rep.Description).
+		Columns("essay_id", "from_user_id", "description", "flag_type").
+		Values(h.id, userH.id, rep.Description, rep.FlagType).
 		Suffix("RETURNING id").
 		ToSql()
 
@@ -129,13 +185,30 @@ func (h EssayH) DeleteVote(ctx context.Context, uH UserH) error {
 	if err := h.essayPerms.Require(models.PermDeleteVote); err != nil {
 		return err
 	}
+	// Nullified votes are kept, so the user can't vote again
 	sql, args, _ := psql.
 		Delete("votes").
-		Where(sq.Eq{"user_id": uH.id, "essay_id": h.id}).
+		Where(sq.Eq{"user_id": uH.id, "essay_id": h.id, "nullified": false}).
 		ToSql()
 
-	_, err := h.sharedDB.Exec(ctx, sql, args...)
-	return err
+	res, err := h.sharedDB.Exec(ctx, sql, args...)
+	if err != nil || res.RowsAffected() > 0 {
+		return err
+	}
+
+	sql, args, _ = psql.
+		Select("nullified").
+		From("votes").
+		Where(sq.Eq{"user_id": uH.id, "essay_id": h.id}).
+		ToSql()
+	var nullified bool
+	err = h.sharedDB.QueryRow(ctx, sql, args...).Scan(&nullified)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return models.ErrVoteNullified
 }
 func (h EssayH) CreateVote(ctx context.Context, uH UserH, vote models.VoteType) error {
 	if err := h.essayPerms.Require(models.PermCreateVote); err != nil {
@@ -159,8 +232,8 @@ func (h EssayH) CreateVote(ctx context.Context, uH UserH, vote models.VoteType)
 		if err != nil {
 			return err
 		}
-		if uH.id == essay.AttributedToID {
-			// Don't notify self
+		if uH.id == essay.AttributedToID || essay.AttributedToID == 0 {
+			// Don't notify self, nor deleted users
 			return nil
 		}
 		url, err := url.Parse(fmt.Sprintf("/s/%s/%d", essay.PostedIn, h.id))
@@ -181,11 +254,37 @@ func (h EssayH) CreateVote(ctx context.Context, uH UserH, vote models.VoteType)
 	}
 	return nil
 }
+
+// DeleteEssay deletes the essay. When it has replies, an empty placeholder
+// keeps the thread together. Only the author can, moderators remove essays instead.
 func (h EssayH) DeleteEssay(ctx context.Context) error {
 	if err := h.essayPerms.Require(models.PermDeleteEssay); err != nil {
 		return err
 	}
-	return h.deleteEssay(ctx)
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		sql, args, _ := psql.
+			Select("thesis", "COALESCE(attributed_to_id, 0)", "posted_in").
+			From("essays").
+			Where(sq.Eq{"id": h.id}).
+			Suffix("FOR UPDATE").
+			ToSql()
+
+		before := models.Essay{}
+		err := tx.QueryRow(ctx, sql, args...).Scan(&before.Thesis, &before.AttributedToID, &before.PostedIn)
+		if err != nil {
+			return err
+		}
+		err = purgeEssays(ctx, tx, []int{h.id}, "Deleted by the author")
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditEssayDelete, fmt.Sprintf("essay %d", h.id),
+			map[string]interface{}{
+				"thesis":           before.Thesis,
+				"attributed_to_id": before.AttributedToID,
+				"posted_in":        before.PostedIn,
+			}, nil)
+	})
 }
 func (h EssayH) ListQuestions(ctx context.Context) ([]models.Question, error) {
 	sql, args, _ := psql.Select("text").From("questions").Where(sq.Eq{
@@ -211,23 +310,80 @@ func (h EssayH) ListAnswers(ctx context.Context, questionID int) ([]models.Answe
 }
 func (h EssayH) GetUserDid(ctx context.Context, userH UserH) (*models.EssayUserDid, error) {
 	sql, args, _ := psql.
-		Select("vote_type AS vote").
-		From("votes").
-		Where(sq.Eq{"user_id": userH.id, "essay_id": h.id}).
+		Select().
+		Column("(SELECT vote_type FROM votes WHERE user_id = ? AND essay_id = ?) AS vote", userH.id, h.id).
+		Column("EXISTS(SELECT 1 FROM persuasions WHERE user_id = ? AND essay_id = ?) AS changed_view", userH.id, h.id).
+		Column("EXISTS(SELECT 1 FROM reports WHERE from_user_id = ? AND essay_id = ?) AS reported", userH.id, h.id).
 		ToSql()
 
 	did := &models.EssayUserDid{}
 	err := pgxscan.Get(ctx, h.sharedDB, did, sql, args...)
-	if pgxscan.NotFound(err) {
-		return did, nil
-	} else if err != nil {
+	if err != nil {
 		return nil, err
 	}
 
 	return did, nil
 }
-func (h EssayH) deleteEssay(ctx context.Context) error {
-	sql, args, _ := psql.Delete("essays").Where(sq.Eq{"id": h.id}).ToSql()
+
+// MarkChangedView records that this reply changed the view of the user.
+// The author of the reply gets notified.
+func (h EssayH) MarkChangedView(ctx context.Context, uH UserH) error {
+	if err := h.essayPerms.Require(models.PermCreateVote); err != nil {
+		return err
+	}
+	sql, args, _ := psql.
+		Insert("persuasions").
+		Columns("essay_id", "user_id").
+		Select(psql.
+			Select("essay_replies.from_id").
+			Column("?", uH.id).
+			From("essay_replies").
+			Join("essays ON essays.id = essay_replies.from_id").
+			Where(sq.Eq{"essay_replies.from_id": h.id}).
+			Where("essays.attributed_to_id IS DISTINCT FROM ?", uH.id),
+		).
+		ToSql()
+
+	tag, err := h.sharedDB.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return models.ErrInvalidPersuasion
+	}
+
+	essay, err := h.ReadView(ctx)
+	if err != nil {
+		return err
+	}
+	if essay.AttributedToID == 0 {
+		// The author deleted their account
+		return nil
+	}
+	url, err := url.Parse(fmt.Sprintf("/s/%s/%d", essay.PostedIn, h.id))
+	if err != nil {
+		return err
+	}
+	user, err := uH.Read(ctx)
+	if err != nil {
+		return err
+	}
+	return h.notifService.Send(ctx, &models.Notification{
+		Title:     user.Name,
+		Text:      "changed their view thanks to your essay",
+		NotifType: models.NotifTypePersuasion,
+		ActionURL: *url,
+	}, essay.AttributedToID)
+}
+func (h EssayH) UnmarkChangedView(ctx context.Context, uH UserH) error {
+	if err := h.essayPerms.Require(models.PermDeleteVote); err != nil {
+		return err
+	}
+	sql, args, _ := psql.
+		Delete("persuasions").
+		Where(sq.Eq{"user_id": uH.id, "essay_id": h.id}).
+		ToSql()
+
 	_, err := h.sharedDB.Exec(ctx, sql, args...)
 	return err
 }
@@ -0,0 +1,310 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"gitlab.com/ranfdev/discepto/internal/models"
+	"gitlab.com/ranfdev/discepto/internal/utils"
+)
+
+var selectInvites = psql.
+	Select(
+		"invites.id",
+		"invites.subdiscepto",
+		"invites.token",
+		"invites.invited_user_id",
+		"invited.name AS invited_user_name",
+		"roles.name AS role_name",
+		"creators.name AS created_by_name",
+		"invites.created_at",
+		"invites.expires_at",
+		"invites.max_uses",
+		"invites.uses",
+	).
+	From("invites").
+	LeftJoin("users AS invited ON invited.id = invites.invited_user_id").
+	LeftJoin("roles ON roles.id = invites.role_id").
+	LeftJoin("users AS creators ON creators.id = invites.created_by").
+	OrderBy("invites.created_at DESC")
+
+// Invites which can still be redeemed
+var inviteUsable = sq.Expr("(invites.expires_at IS NULL OR invites.expires_at > NOW()) AND (invites.max_uses IS NULL OR invites.uses < invites.max_uses)")
+
+func listInvites(ctx context.Context, db DBTX, where sq.Sqlizer) ([]models.Invite, error) {
+	sql, args, _ := selectInvites.Where(where).ToSql()
+
+	invites := []models.Invite{}
+	err := pgxscan.Select(ctx, db, &invites, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+// createInvite stores an invite link (when token is set) or a direct invitation.
+// The role must not grant more than what the creator already has, nor rank above the creator.
+func (h *SubdisceptoH) createInvite(ctx context.Context, uH UserH, token *string, invitedUserID *int, req models.InviteReq) (int, error) {
+	if err := h.subPerms.Require(models.PermManageInvites); err != nil {
+		return 0, err
+	}
+	if req.Days < 0 || req.MaxUses < 0 {
+		return 0, models.ErrInvalidInvite
+	}
+	var roleID *int
+	if req.Role != "" {
+		if err := h.subPerms.Require(models.PermManageRole); err != nil {
+			return 0, err
+		}
+		role, err := findRoleByName(ctx, h.sharedDB, h.rawSub.RoledomainID, req.Role)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, models.ErrInvalidInvite
+		} else if err != nil {
+			return 0, err
+		}
+		if role.Rank > h.rank {
+			return 0, models.ErrRoleRank
+		}
+		rolePerms, err := listRolePerms(ctx, h.sharedDB, role.ID)
+		if err != nil {
+			return 0, err
+		}
+		if err := h.subPerms.RequirePerms(rolePerms); err != nil {
+			return 0, err
+		}
+		roleID = &role.ID
+	}
+	var expiresAt, maxUses interface{}
+	if req.Days > 0 {
+		expiresAt = sq.Expr("NOW() + make_interval(days => ?)", req.Days)
+	}
+	if req.MaxUses > 0 {
+		maxUses = req.MaxUses
+	}
+
+	var inviteID int
+	err := execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		sql, args, _ := psql.
+			Insert("invites").
+			Columns("subdiscepto", "token", "invited_user_id", "role_id", "created_by", "expires_at", "max_uses").
+			Values(h.rawSub.Name, token, invitedUserID, roleID, uH.id, expiresAt, maxUses).
+			Suffix("RETURNING id").
+			ToSql()
+
+		err := tx.QueryRow(ctx, sql, args...).Scan(&inviteID)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.ConstraintName == "invites_invited_user_id_fkey" {
+			return models.ErrInvalidInvite
+		} else if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditInviteCreate, fmt.Sprintf("invite %d", inviteID), nil, req)
+	})
+	return inviteID, err
+}
+
+// CreateInviteLink returns the token of a new invite link
+func (h *SubdisceptoH) CreateInviteLink(ctx context.Context, uH UserH, req models.InviteReq) (string, error) {
+	token := utils.GenToken(16)
+	_, err := h.createInvite(ctx, uH, &token, nil, req)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// InviteUser invites an existing user, who gets notified
+func (h *SubdisceptoH) InviteUser(ctx context.Context, uH UserH, userID int, req models.InviteReq) error {
+	// A direct invitation is used once
+	req.MaxUses = 1
+	_, err := h.createInvite(ctx, uH, nil, &userID, req)
+	if err != nil {
+		return err
+	}
+	actionURL, err := url.Parse("/invites")
+	if err != nil {
+		return err
+	}
+	return h.notifService.Send(ctx, &models.Notification{
+		Title:     fmt.Sprintf("You are invited to s/%s", h.rawSub.Name),
+		Text:      h.rawSub.Description,
+		NotifType: models.NotifTypeInvite,
+		ActionURL: *actionURL,
+	}, userID)
+}
+
+// ListInvites lists the invites of the subdiscepto still usable
+func (h *SubdisceptoH) ListInvites(ctx context.Context) ([]models.Invite, error) {
+	if err := h.subPerms.Require(models.PermManageInvites); err != nil {
+		return nil, err
+	}
+	return listInvites(ctx, h.sharedDB, sq.And{sq.Eq{"invites.subdiscepto": h.rawSub.Name}, inviteUsable})
+}
+
+// DeleteInvite revokes an invite
+func (h *SubdisceptoH) DeleteInvite(ctx context.Context, inviteID int) error {
+	if err := h.subPerms.Require(models.PermManageInvites); err != nil {
+		return err
+	}
+	sql, args, _ := psql.
+		Delete("invites").
+		Where(sq.Eq{"id": inviteID, "subdiscepto": h.rawSub.Name}).
+		ToSql()
+
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		tag, err := tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		} else if tag.RowsAffected() == 0 {
+			return pgx.ErrNoRows
+		}
+		return h.audit.record(ctx, tx, models.AuditInviteDelete, fmt.Sprintf("invite %d", inviteID), nil, nil)
+	})
+}
+
+// redeemInvite makes the user a member of the subdiscepto of the matching invite,
+// assigning the role of the invite, all in one transaction. Returns the subdiscepto name.
+func redeemInvite(ctx context.Context, db DBTX, userID int, where sq.Eq) (string, error) {
+	var subName string
+	err := execTx(ctx, db, func(ctx context.Context, tx DBTX) error {
+		sql, args, _ := psql.
+			Select("invites.id", "invites.subdiscepto", "invites.role_id", "invites.invited_user_id IS NOT NULL").
+			From("invites").
+			Where(where).
+			Where(inviteUsable).
+			Suffix("FOR UPDATE").
+			ToSql()
+
+		var inviteID int
+		var roleID *int
+		var isDirect bool
+		err := tx.QueryRow(ctx, sql, args...).Scan(&inviteID, &subName, &roleID, &isDirect)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.ErrInviteExpired
+		} else if err != nil {
+			return err
+		}
+
+		rawSub, err := readRawSub(ctx, tx, subName)
+		if err != nil {
+			return err
+		}
+		// An invite doesn't lift a ban
+		ban, err := findActiveBan(ctx, tx, rawSub.RoledomainID, userID)
+		if err != nil {
+			return err
+		}
+		if ban != nil {
+			return models.ErrPermDenied
+		}
+
+		sql, args, _ = psql.
+			Select("COUNT(*)").
+			From("subdiscepto_users").
+			Where(sq.Eq{"user_id": userID, "subdiscepto": subName, "left_at": nil}).
+			ToSql()
+		var members int
+		err = tx.QueryRow(ctx, sql, args...).Scan(&members)
+		if err != nil {
+			return err
+		}
+		if members > 0 {
+			// Already a member: a link keeps its uses, a direct invitation is moot
+			if !isDirect {
+				return nil
+			}
+			sql, args, _ = psql.Delete("invites").Where(sq.Eq{"id": inviteID}).ToSql()
+			_, err = tx.Exec(ctx, sql, args...)
+			return err
+		}
+
+		err = addOrRejoinMember(ctx, tx, rawSub, userID)
+		if err != nil {
+			return err
+		}
+
+		if roleID != nil {
+			roles, err := listUserRoles(ctx, tx, userID, rawSub.RoledomainID)
+			if err != nil {
+				return err
+			}
+			hasRole := false
+			for _, r := range roles {
+				hasRole = hasRole || r.ID == *roleID
+			}
+			if !hasRole {
+				err = assignRole(ctx, tx, userID, *roleID)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if isDirect {
+			sql, args, _ = psql.Delete("invites").Where(sq.Eq{"id": inviteID}).ToSql()
+		} else {
+			sql, args, _ = psql.
+				Update("invites").
+				Set("uses", sq.Expr("uses + 1")).
+				Where(sq.Eq{"id": inviteID}).
+				ToSql()
+		}
+		_, err = tx.Exec(ctx, sql, args...)
+		return err
+	})
+	return subName, err
+}
+
+// ReadInvite returns the invite link matching the token, if still usable
+func (h *DisceptoH) ReadInvite(ctx context.Context, token string) (*models.Invite, error) {
+	invites, err := listInvites(ctx, h.sharedDB, sq.And{sq.Eq{"invites.token": token}, inviteUsable})
+	if err != nil {
+		return nil, err
+	}
+	if len(invites) == 0 {
+		return nil, models.ErrInviteExpired
+	}
+	return &invites[0], nil
+}
+
+// RedeemInvite joins the subdiscepto of an invite link, returning its name
+func (h *DisceptoH) RedeemInvite(ctx context.Context, userH *UserH, token string) (string, error) {
+	if !userH.perms.Read {
+		return "", models.ErrPermDenied
+	}
+	return redeemInvite(ctx, h.sharedDB, userH.id, sq.Eq{"invites.token": token})
+}
+
+// ListUserInvites lists the direct invitations received by the user
+func (h *DisceptoH) ListUserInvites(ctx context.Context, userH *UserH) ([]models.Invite, error) {
+	if !userH.perms.Read {
+		return nil, models.ErrPermDenied
+	}
+	return listInvites(ctx, h.sharedDB, sq.And{sq.Eq{"invites.invited_user_id": userH.id}, inviteUsable})
+}
+
+// AcceptInvite joins the subdiscepto of a direct invitation, returning its name
+func (h *DisceptoH) AcceptInvite(ctx context.Context, userH *UserH, inviteID int) (string, error) {
+	if !userH.perms.Read {
+		return "", models.ErrPermDenied
+	}
+	return redeemInvite(ctx, h.sharedDB, userH.id, sq.Eq{"invites.id": inviteID, "invites.invited_user_id": userH.id})
+}
+func (h *DisceptoH) DeclineInvite(ctx context.Context, userH *UserH, inviteID int) error {
+	if !userH.perms.Read {
+		return models.ErrPermDenied
+	}
+	sql, args, _ := psql.
+		Delete("invites").
+		Where(sq.Eq{"id": inviteID, "invited_user_id": userH.id}).
+		ToSql()
+
+	_, err := h.sharedDB.Exec(ctx, sql, args...)
+	return err
+}
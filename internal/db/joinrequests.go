@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+var selectJoinRequests = psql.
+	Select(
+		"join_requests.id",
+		"join_requests.subdiscepto",
+		"join_requests.user_id",
+		"users.name AS user_name",
+		"join_requests.message",
+		"join_requests.created_at",
+	).
+	From("join_requests").
+	Join("users ON users.id = join_requests.user_id").
+	Where(sq.Eq{"join_requests.decided_at": nil}).
+	OrderBy("join_requests.created_at")
+
+// RequestJoin asks the moderators of a subdiscepto, even a private one,
+// to become a member
+func (h *DisceptoH) RequestJoin(ctx context.Context, userH *UserH, subName string, message string) error {
+	if !userH.perms.Read {
+		return models.ErrPermDenied
+	}
+	if len(message) > 500 {
+		return models.ErrInvalidJoinRequest
+	}
+	rawSub, err := readRawSub(ctx, h.sharedDB, subName)
+	if err != nil {
+		return err
+	}
+	if rawSub.JoinMode != models.JoinByRequest {
+		return models.ErrInvalidJoinRequest
+	}
+	ban, err := findActiveBan(ctx, h.sharedDB, rawSub.RoledomainID, userH.id)
+	if err != nil {
+		return err
+	}
+	if ban != nil {
+		return models.ErrPermDenied
+	}
+
+	sql, args, _ := psql.
+		Insert("join_requests").
+		Columns("subdiscepto", "user_id", "message").
+		Values(rawSub.Name, userH.id, message).
+		Suffix("ON CONFLICT (subdiscepto, user_id) WHERE decided_at IS NULL DO NOTHING").
+		ToSql()
+
+	tag, err := h.sharedDB.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return models.ErrJoinRequestExists
+	}
+
+	mods, err := listModerators(ctx, h.sharedDB, rawSub.RoledomainID, models.PermManageMembers)
+	if err != nil {
+		return err
+	}
+	actionURL, err := url.Parse(fmt.Sprintf("/s/%s/joinrequests", rawSub.Name))
+	if err != nil {
+		return err
+	}
+	for _, modID := range mods {
+		err = h.notifService.Send(ctx, &models.Notification{
+			Title:     fmt.Sprintf("New request to join s/%s", rawSub.Name),
+			Text:      message,
+			NotifType: models.NotifTypeJoin,
+			ActionURL: *actionURL,
+		}, modID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadJoinRequest returns the pending request of the user to join the subdiscepto, if any
+func (h *DisceptoH) ReadJoinRequest(ctx context.Context, userH *UserH, subName string) (*models.JoinRequest, error) {
+	if !userH.perms.Read {
+		return nil, models.ErrPermDenied
+	}
+	sql, args, _ := selectJoinRequests.
+		Where(sq.Eq{"join_requests.subdiscepto": subName, "join_requests.user_id": userH.id}).
+		ToSql()
+
+	req := &models.JoinRequest{}
+	err := pgxscan.Get(ctx, h.sharedDB, req, sql, args...)
+	if pgxscan.NotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (h *SubdisceptoH) ListJoinRequests(ctx context.Context) ([]models.JoinRequest, error) {
+	if err := h.subPerms.Require(models.PermManageMembers); err != nil {
+		return nil, err
+	}
+	sql, args, _ := selectJoinRequests.
+		Where(sq.Eq{"join_requests.subdiscepto": h.rawSub.Name}).
+		ToSql()
+
+	reqs := []models.JoinRequest{}
+	err := pgxscan.Select(ctx, h.sharedDB, &reqs, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return reqs, nil
+}
+
+// DecideJoinRequest closes a pending request. When approved, the user becomes a member.
+// The user is notified either way.
+func (h *SubdisceptoH) DecideJoinRequest(ctx context.Context, uH UserH, requestID int, approved bool) error {
+	if err := h.subPerms.Require(models.PermManageMembers); err != nil {
+		return err
+	}
+	var userID int
+	err := execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		sql, args, _ := psql.
+			Update("join_requests").
+			Set("decided_by", uH.id).
+			Set("decided_at", sq.Expr("NOW()")).
+			Set("approved", approved).
+			Where(sq.Eq{"id": requestID, "subdiscepto": h.rawSub.Name, "decided_at": nil}).
+			Suffix("RETURNING user_id").
+			ToSql()
+
+		err := tx.QueryRow(ctx, sql, args...).Scan(&userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.ErrInvalidJoinRequest
+		} else if err != nil {
+			return err
+		}
+
+		action := models.AuditJoinDeny
+		if approved {
+			action = models.AuditJoinApprove
+			err = addOrRejoinMember(ctx, tx, h.rawSub, userID)
+			if err != nil {
+				return err
+			}
+		}
+		return h.audit.record(ctx, tx, action, fmt.Sprintf("user %d", userID), nil, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	title := fmt.Sprintf("Your request to join s/%s has been denied", h.rawSub.Name)
+	link := fmt.Sprintf("/join/%s", h.rawSub.Name)
+	if approved {
+		title = fmt.Sprintf("Welcome to s/%s", h.rawSub.Name)
+		link = fmt.Sprintf("/s/%s", h.rawSub.Name)
+	}
+	actionURL, err := url.Parse(link)
+	if err != nil {
+		return err
+	}
+	return h.notifService.Send(ctx, &models.Notification{
+		Title:     title,
+		Text:      h.rawSub.Description,
+		NotifType: models.NotifTypeJoin,
+		ActionURL: *actionURL,
+	}, userID)
+}
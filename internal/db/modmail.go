@@ -0,0 +1,315 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+func selectModmailThreads(viewerID int) sq.SelectBuilder {
+	return psql.
+		Select(
+			"modmail_threads.id",
+			"modmail_threads.subdiscepto",
+			"modmail_threads.user_id",
+			"users.name AS user_name",
+			"modmail_threads.subject",
+			"modmail_threads.created_at",
+			"modmail_threads.updated_at",
+			"modmail_threads.archived_at",
+			"modmail_threads.updated_at > COALESCE(modmail_reads.read_at, '-infinity') AS unread",
+		).
+		From("modmail_threads").
+		Join("users ON users.id = modmail_threads.user_id").
+		LeftJoin("modmail_reads ON modmail_reads.thread_id = modmail_threads.id AND modmail_reads.user_id = ?", viewerID).
+		OrderBy("modmail_threads.updated_at DESC")
+}
+func listModmailThreads(ctx context.Context, db DBTX, viewerID int, where sq.Sqlizer) ([]models.ModmailThread, error) {
+	sql, args, _ := selectModmailThreads(viewerID).Where(where).ToSql()
+
+	threads := []models.ModmailThread{}
+	err := pgxscan.Select(ctx, db, &threads, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
+
+// readModmailThread returns the matching thread with its messages, marking it as read.
+// When the viewer isn't a moderator, the names of the moderators are hidden.
+func readModmailThread(ctx context.Context, db DBTX, viewerID int, where sq.Eq, asMods bool) (*models.ModmailThreadView, error) {
+	thread := &models.ModmailThreadView{}
+	sql, args, _ := selectModmailThreads(viewerID).Where(where).ToSql()
+	err := pgxscan.Get(ctx, db, &thread.ModmailThread, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	authorName := "users.name AS author_name"
+	if !asMods {
+		authorName = "CASE WHEN modmail_messages.from_mods THEN NULL ELSE users.name END AS author_name"
+	}
+	sql, args, _ = psql.
+		Select(
+			"modmail_messages.id",
+			"modmail_messages.from_mods",
+			authorName,
+			"modmail_messages.text",
+			"modmail_messages.created_at",
+		).
+		From("modmail_messages").
+		LeftJoin("users ON users.id = modmail_messages.author_id").
+		Where(sq.Eq{"modmail_messages.thread_id": thread.ID}).
+		OrderBy("modmail_messages.id").
+		ToSql()
+
+	err = pgxscan.Select(ctx, db, &thread.Messages, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return thread, markModmailRead(ctx, db, thread.ID, viewerID)
+}
+func markModmailRead(ctx context.Context, db DBTX, threadID int, userID int) error {
+	sql, args, _ := psql.
+		Insert("modmail_reads").
+		Columns("thread_id", "user_id").
+		Values(threadID, userID).
+		Suffix("ON CONFLICT (thread_id, user_id) DO UPDATE SET read_at = NOW()").
+		ToSql()
+
+	_, err := db.Exec(ctx, sql, args...)
+	return err
+}
+
+// addModmailMessage appends a message to a thread, which gets unarchived
+func addModmailMessage(ctx context.Context, db DBTX, threadID int, authorID int, fromMods bool, text string) error {
+	if len(text) == 0 || len(text) > 5000 {
+		return models.ErrInvalidModmail
+	}
+	sql, args, _ := psql.
+		Insert("modmail_messages").
+		Columns("thread_id", "author_id", "from_mods", "text").
+		Values(threadID, authorID, fromMods, text).
+		ToSql()
+
+	_, err := db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	sql, args, _ = psql.
+		Update("modmail_threads").
+		Set("updated_at", sq.Expr("NOW()")).
+		Set("archived_at", nil).
+		Where(sq.Eq{"id": threadID}).
+		ToSql()
+
+	_, err = db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	return markModmailRead(ctx, db, threadID, authorID)
+}
+
+// Users holding the permission in the domain
+func listModerators(ctx context.Context, db DBTX, domain models.RoleDomain, perm models.Perm) ([]int, error) {
+	sql, args, _ := psql.
+		Select("DISTINCT user_roles.user_id").
+		From("user_roles").
+		Join("roles ON roles.id = user_roles.role_id").
+		Join("role_perms ON role_perms.role_id = roles.id").
+		Where(sq.Eq{"roles.roledomain_id": domain, "role_perms.permission": perm, "role_perms.deny": false}).
+		Where(activeUserRoles).
+		Where(notDenied).
+		ToSql()
+
+	ids := []int{}
+	err := pgxscan.Select(ctx, db, &ids, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+func notifyModerators(ctx context.Context, db DBTX, notifService models.NotificationService, sub *models.Subdiscepto, thread models.ModmailThread) error {
+	mods, err := listModerators(ctx, db, sub.RoledomainID, models.PermManageModmail)
+	if err != nil {
+		return err
+	}
+	actionURL, err := url.Parse(fmt.Sprintf("/s/%s/modmail/%d", sub.Name, thread.ID))
+	if err != nil {
+		return err
+	}
+	for _, modID := range mods {
+		if modID == thread.UserID {
+			continue
+		}
+		err = notifService.Send(ctx, &models.Notification{
+			Title:     fmt.Sprintf("s/%s modmail", sub.Name),
+			Text:      fmt.Sprintf("@%s: %s", thread.UserName, thread.Subject),
+			NotifType: models.NotifTypeModmail,
+			ActionURL: *actionURL,
+		}, modID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenModmail starts a conversation between the user and the moderators
+func (h *SubdisceptoH) OpenModmail(ctx context.Context, uH UserH, req models.ModmailReq) (int, error) {
+	if err := h.subPerms.Require(models.PermReadSubdiscepto); err != nil {
+		return 0, err
+	}
+	if len(req.Subject) == 0 || len(req.Subject) > 150 {
+		return 0, models.ErrInvalidModmail
+	}
+	var thread *models.ModmailThreadView
+	err := execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		sql, args, _ := psql.
+			Insert("modmail_threads").
+			Columns("subdiscepto", "user_id", "subject").
+			Values(h.rawSub.Name, uH.id, req.Subject).
+			Suffix("RETURNING id").
+			ToSql()
+
+		var threadID int
+		err := tx.QueryRow(ctx, sql, args...).Scan(&threadID)
+		if err != nil {
+			return err
+		}
+		err = addModmailMessage(ctx, tx, threadID, uH.id, false, req.Text)
+		if err != nil {
+			return err
+		}
+		thread, err = readModmailThread(ctx, tx, uH.id, sq.Eq{"modmail_threads.id": threadID}, false)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return thread.ID, notifyModerators(ctx, h.sharedDB, h.notifService, h.rawSub, thread.ModmailThread)
+}
+
+// ListModmail lists the conversations of the moderators of this subdiscepto
+func (h *SubdisceptoH) ListModmail(ctx context.Context, uH UserH, archived bool) ([]models.ModmailThread, error) {
+	if err := h.subPerms.Require(models.PermManageModmail); err != nil {
+		return nil, err
+	}
+	where := sq.And{sq.Eq{"modmail_threads.subdiscepto": h.rawSub.Name}}
+	if archived {
+		where = append(where, sq.NotEq{"modmail_threads.archived_at": nil})
+	} else {
+		where = append(where, sq.Eq{"modmail_threads.archived_at": nil})
+	}
+	return listModmailThreads(ctx, h.sharedDB, uH.id, where)
+}
+func (h *SubdisceptoH) ReadModmail(ctx context.Context, uH UserH, threadID int) (*models.ModmailThreadView, error) {
+	if err := h.subPerms.Require(models.PermManageModmail); err != nil {
+		return nil, err
+	}
+	return readModmailThread(ctx, h.sharedDB, uH.id,
+		sq.Eq{"modmail_threads.id": threadID, "modmail_threads.subdiscepto": h.rawSub.Name}, true)
+}
+
+// ReplyModmail answers the user on behalf of the moderation team
+func (h *SubdisceptoH) ReplyModmail(ctx context.Context, uH UserH, threadID int, text string) error {
+	if err := h.subPerms.Require(models.PermManageModmail); err != nil {
+		return err
+	}
+	var thread *models.ModmailThreadView
+	err := execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		var err error
+		thread, err = readModmailThread(ctx, tx, uH.id,
+			sq.Eq{"modmail_threads.id": threadID, "modmail_threads.subdiscepto": h.rawSub.Name}, true)
+		if err != nil {
+			return err
+		}
+		return addModmailMessage(ctx, tx, threadID, uH.id, true, text)
+	})
+	if err != nil {
+		return err
+	}
+
+	actionURL, err := url.Parse(fmt.Sprintf("/modmail/%d", threadID))
+	if err != nil {
+		return err
+	}
+	return h.notifService.Send(ctx, &models.Notification{
+		Title:     fmt.Sprintf("Moderators of s/%s", h.rawSub.Name),
+		Text:      thread.Subject,
+		NotifType: models.NotifTypeModmail,
+		ActionURL: *actionURL,
+	}, thread.UserID)
+}
+
+// ArchiveModmail hides a conversation from the inbox, until someone writes again
+func (h *SubdisceptoH) ArchiveModmail(ctx context.Context, threadID int, archived bool) error {
+	if err := h.subPerms.Require(models.PermManageModmail); err != nil {
+		return err
+	}
+	archivedAt := sq.Expr("NULL")
+	if archived {
+		archivedAt = sq.Expr("NOW()")
+	}
+	sql, args, _ := psql.
+		Update("modmail_threads").
+		Set("archived_at", archivedAt).
+		Where(sq.Eq{"id": threadID, "subdiscepto": h.rawSub.Name}).
+		ToSql()
+
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		tag, err := tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return pgx.ErrNoRows
+		}
+		return h.audit.record(ctx, tx, models.AuditModmailArchive, fmt.Sprintf("modmail %d", threadID),
+			nil, map[string]bool{"archived": archived})
+	})
+}
+
+// ListModmail lists the conversations the user opened with moderators
+func (h *DisceptoH) ListModmail(ctx context.Context, userH *UserH) ([]models.ModmailThread, error) {
+	if !userH.perms.Read {
+		return nil, models.ErrPermDenied
+	}
+	return listModmailThreads(ctx, h.sharedDB, userH.id, sq.Eq{"modmail_threads.user_id": userH.id})
+}
+func (h *DisceptoH) ReadModmail(ctx context.Context, userH *UserH, threadID int) (*models.ModmailThreadView, error) {
+	if !userH.perms.Read {
+		return nil, models.ErrPermDenied
+	}
+	return readModmailThread(ctx, h.sharedDB, userH.id,
+		sq.Eq{"modmail_threads.id": threadID, "modmail_threads.user_id": userH.id}, false)
+}
+func (h *DisceptoH) ReplyModmail(ctx context.Context, userH *UserH, threadID int, text string) error {
+	if !userH.perms.Read {
+		return models.ErrPermDenied
+	}
+	var thread *models.ModmailThreadView
+	err := execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		var err error
+		thread, err = readModmailThread(ctx, tx, userH.id,
+			sq.Eq{"modmail_threads.id": threadID, "modmail_threads.user_id": userH.id}, false)
+		if err != nil {
+			return err
+		}
+		return addModmailMessage(ctx, tx, threadID, userH.id, false, text)
+	})
+	if err != nil {
+		return err
+	}
+	sub, err := readRawSub(ctx, h.sharedDB, thread.Subdiscepto)
+	if err != nil {
+		return err
+	}
+	return notifyModerators(ctx, h.sharedDB, h.notifService, sub, thread.ModmailThread)
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"gitlab.com/ranfdev/discepto/internal/models"
+	"gitlab.com/ranfdev/discepto/internal/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// How long a password reset token can be used
+	PasswdResetTTL = 1 * time.Hour
+	// Reset requests allowed for each account in PasswdResetWindow
+	LimitPasswdResets, PasswdResetWindow = 3, 1 * time.Hour
+)
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequestPasswdReset creates a password reset token for the user with the email.
+// The token must be mailed to the user: only its hash is stored.
+func (sdb *SharedDB) RequestPasswdReset(ctx context.Context, email string) (*models.User, string, error) {
+	token := utils.GenToken(32)
+	user := &models.User{}
+	err := execTx(ctx, sdb.db, func(ctx context.Context, tx DBTX) error {
+		sql, args, _ := psql.
+			Select("id", "name", "email").
+			From("users").
+			Where(sq.Eq{"email": email}).
+			Suffix("FOR UPDATE").
+			ToSql()
+
+		err := pgxscan.Get(ctx, tx, user, sql, args...)
+		if err != nil {
+			return err
+		}
+
+		sql, args, _ = psql.
+			Select("COUNT(*)").
+			From("password_resets").
+			Where(sq.Eq{"user_id": user.ID}).
+			Where("created_at > NOW() - make_interval(secs => ?)", PasswdResetWindow.Seconds()).
+			ToSql()
+
+		var count int
+		err = tx.QueryRow(ctx, sql, args...).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count >= LimitPasswdResets {
+			return models.ErrTooManyResets
+		}
+
+		sql, args, _ = psql.
+			Insert("password_resets").
+			Columns("token_hash", "user_id", "expires_at").
+			Values(hashToken(token), user.ID, sq.Expr("NOW() + make_interval(secs => ?)", PasswdResetTTL.Seconds())).
+			ToSql()
+
+		_, err = tx.Exec(ctx, sql, args...)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return user, token, nil
+}
+
+// ResetPasswd sets a new password, consuming the token and every other pending
+// token of the user. The user is signed out of all the sessions.
+func (sdb *SharedDB) ResetPasswd(ctx context.Context, token string, passwd string) error {
+	return execTx(ctx, sdb.db, func(ctx context.Context, tx DBTX) error {
+		sql, args, _ := psql.
+			Select("users.id", "users.name", "users.email").
+			From("password_resets").
+			Join("users ON users.id = password_resets.user_id").
+			Where(sq.Eq{"password_resets.token_hash": hashToken(token), "password_resets.used_at": nil}).
+			Where("password_resets.expires_at > NOW()").
+			Suffix("FOR UPDATE OF password_resets").
+			ToSql()
+
+		user := models.User{}
+		err := pgxscan.Get(ctx, tx, &user, sql, args...)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.ErrInvalidResetToken
+		} else if err != nil {
+			return err
+		}
+
+		if !validatePasswd(passwd, []string{user.Email, user.Name}) {
+			return models.ErrWeakPasswd
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(passwd), sdb.bcryptCost)
+		if err != nil {
+			return err
+		}
+
+		sql, args, _ = psql.
+			Update("users").
+			Set("passwd_hash", hash).
+			Set("session_generation", sq.Expr("session_generation + 1")).
+			Where(sq.Eq{"id": user.ID}).
+			ToSql()
+
+		_, err = tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+
+		sql, args, _ = psql.
+			Update("password_resets").
+			Set("used_at", sq.Expr("NOW()")).
+			Where(sq.Eq{"user_id": user.ID, "used_at": nil}).
+			ToSql()
+
+		_, err = tx.Exec(ctx, sql, args...)
+		return err
+	})
+}
+
+// SessionGeneration changes every time the user must be signed out of all the sessions
+func (h UserH) SessionGeneration(ctx context.Context) (int, error) {
+	if !h.perms.Read {
+		return 0, models.ErrPermDenied
+	}
+	sql, args, _ := psql.
+		Select("session_generation").
+		From("users").
+		Where(sq.Eq{"id": h.id}).
+		ToSql()
+
+	var generation int
+	err := h.sharedDB.QueryRow(ctx, sql, args...).Scan(&generation)
+	return generation, err
+}
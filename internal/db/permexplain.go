@@ -0,0 +1,232 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+type roleReasons struct {
+	grants  []models.PermReason
+	denies  []models.PermReason
+	missing []models.PermReason
+}
+
+// explainRoles splits the roles of the domain granting the permission
+// between the ones the user has and the missing ones, and lists
+// the roles of the user denying the permission
+func explainRoles(ctx context.Context, db DBTX, domain models.RoleDomain, userID int, perm models.Perm, source models.PermSource, denySource models.PermSource) (*roleReasons, error) {
+	granting, err := listRolesWithPerm(ctx, db, domain, perm, false)
+	if err != nil {
+		return nil, err
+	}
+	denying, err := listRolesWithPerm(ctx, db, domain, perm, true)
+	if err != nil {
+		return nil, err
+	}
+	userRoles, err := listUserRoles(ctx, db, userID, domain)
+	if err != nil {
+		return nil, err
+	}
+	has := map[int]bool{}
+	for _, r := range userRoles {
+		has[r.ID] = true
+	}
+	res := &roleReasons{}
+	for _, r := range granting {
+		reason := models.PermReason{Source: source, Detail: r.Name}
+		if has[r.ID] {
+			res.grants = append(res.grants, reason)
+		} else {
+			res.missing = append(res.missing, reason)
+		}
+	}
+	for _, r := range denying {
+		if has[r.ID] {
+			res.denies = append(res.denies, models.PermReason{Source: denySource, Detail: r.Name})
+		}
+	}
+	return res, nil
+}
+
+// explainPerm computes the permissions of the user as GetDisceptoH, GetSubdisceptoH
+// and getEssayH do, recording what grants or takes away the permission on the way.
+// rawSub is nil for global permissions, essayID is 0 to ignore essays.
+func explainPerm(ctx context.Context, db DBTX, userID int, rawSub *models.Subdiscepto, essayID int, perm models.Perm) (*models.PermExplanation, error) {
+	e := &models.PermExplanation{UserID: userID, EssayID: essayID, Perm: perm}
+
+	// GetDisceptoH
+	globalPerms := models.NewPerms()
+	ban, err := findActiveBan(ctx, db, models.RoleDomainDiscepto, userID)
+	if err != nil {
+		return nil, err
+	}
+	reasons, err := explainRoles(ctx, db, models.RoleDomainDiscepto, userID, perm,
+		models.PermSourceGlobalRole, models.PermSourceGlobalDeny)
+	if err != nil {
+		return nil, err
+	}
+	e.Grants = append(e.Grants, reasons.grants...)
+	e.Blocks = append(e.Blocks, reasons.denies...)
+	e.Missing = append(e.Missing, reasons.missing...)
+	if ban != nil {
+		e.Blocks = append(e.Blocks, models.PermReason{Source: models.PermSourceGlobalBan, Detail: ban.Reason})
+	} else {
+		globalPerms, err = getUserPerms(ctx, db, models.RoleDomainDiscepto, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	verified, err := emailVerified(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !verified {
+		globalPerms = globalPerms.Deny(models.PermsDeniedUnverified...)
+		if globalPerms.Denies(perm) {
+			e.Blocks = append(e.Blocks, models.PermReason{Source: models.PermSourceUnverified, Detail: "the user must verify their email"})
+		}
+	}
+	if rawSub == nil {
+		e.Granted = globalPerms.Check(perm)
+		return e, nil
+	}
+	e.Subdiscepto = rawSub.Name
+
+	// GetSubdisceptoH
+	subPerms := models.NewPerms()
+	reasons, err = explainRoles(ctx, db, rawSub.RoledomainID, userID, perm,
+		models.PermSourceLocalRole, models.PermSourceLocalDeny)
+	if err != nil {
+		return nil, err
+	}
+	e.Grants = append(e.Grants, reasons.grants...)
+	var subBan *models.Ban
+	if globalPerms.Check(models.PermUseLocalPermissions) {
+		subBan, err = findActiveBan(ctx, db, rawSub.RoledomainID, userID)
+		if err != nil {
+			return nil, err
+		}
+		subPerms, err = getUserPerms(ctx, db, rawSub.RoledomainID, userID)
+		if err != nil {
+			return nil, err
+		}
+		e.Blocks = append(e.Blocks, reasons.denies...)
+	} else if len(reasons.grants) > 0 || len(reasons.denies) > 0 {
+		e.Blocks = append(e.Blocks, models.PermReason{
+			Source: models.PermSourceNoLocal,
+			Detail: fmt.Sprintf("missing %s", models.PermUseLocalPermissions),
+		})
+	}
+	subPerms = subPerms.Union(globalPerms)
+	if rawSub.Public {
+		subPerms = subPerms.Union(models.NewPerms(models.PermReadSubdiscepto))
+		if perm == models.PermReadSubdiscepto {
+			e.Grants = append(e.Grants, models.PermReason{Source: models.PermSourcePublic, Detail: rawSub.Name})
+		}
+	}
+	if subBan != nil {
+		subPerms = models.NewPerms()
+		e.Blocks = append(e.Blocks, models.PermReason{Source: models.PermSourceLocalBan, Detail: subBan.Reason})
+	} else if !subPerms.Check(models.PermReadSubdiscepto) {
+		subPerms = models.NewPerms()
+		if perm != models.PermReadSubdiscepto {
+			e.Blocks = append(e.Blocks, models.PermReason{
+				Source: models.PermSourceNoRead,
+				Detail: fmt.Sprintf("missing %s", models.PermReadSubdiscepto),
+			})
+		}
+	}
+
+	// getEssayH
+	if essayID != 0 {
+		sql, args, _ := psql.
+			Select("COALESCE(attributed_to_id, 0)", "held_at IS NOT NULL", "members_only").
+			From("essays").
+			Where(sq.Eq{"posted_in": rawSub.Name, "id": essayID}).
+			ToSql()
+
+		var authorID int
+		var held, membersOnly bool
+		err := db.QueryRow(ctx, sql, args...).Scan(&authorID, &held, &membersOnly)
+		if err != nil {
+			return nil, err
+		}
+		isOwner := authorID == userID
+		detail := fmt.Sprintf("essay %d", essayID)
+		if held && !isOwner && !subPerms.Check(models.PermRemoveEssay) {
+			subPerms = models.NewPerms()
+			e.Blocks = append(e.Blocks, models.PermReason{Source: models.PermSourceHeld, Detail: detail})
+		}
+		if membersOnly && !isOwner && !subPerms.Check(models.PermReadEssay) {
+			subPerms = models.NewPerms()
+			e.Blocks = append(e.Blocks, models.PermReason{Source: models.PermSourceMembersOnly, Detail: detail})
+		}
+		// Essays are reachable only with read access
+		if isOwner && subPerms.Check(models.PermReadSubdiscepto) {
+			subPerms = subPerms.Union(models.NewPerms(models.PermDeleteEssay))
+			if perm == models.PermDeleteEssay {
+				e.Grants = append(e.Grants, models.PermReason{Source: models.PermSourceOwner, Detail: detail})
+			}
+		}
+	}
+	e.Granted = subPerms.Check(perm)
+	e.Missing = append(e.Missing, reasons.missing...)
+	return e, nil
+}
+
+// ExplainPerm tells why a user has, or hasn't, a permission, globally
+// or inside a subdiscepto when subName isn't empty
+func (h *DisceptoH) ExplainPerm(ctx context.Context, userID int, subName string, essayID int, perm models.Perm) (*models.PermExplanation, error) {
+	if err := h.globalPerms.Require(models.PermManageGlobalRole); err != nil {
+		return nil, err
+	}
+	var rawSub *models.Subdiscepto
+	if subName != "" {
+		var err error
+		rawSub, err = readRawSub(ctx, h.sharedDB, subName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return explainPerm(ctx, h.sharedDB, userID, rawSub, essayID, perm)
+}
+
+// ExplainPerm tells why a user has, or hasn't, a permission inside the subdiscepto.
+// The global roles and bans are mentioned without their details,
+// which only the global role managers can see.
+func (h *SubdisceptoH) ExplainPerm(ctx context.Context, userID int, essayID int, perm models.Perm) (*models.PermExplanation, error) {
+	if err := h.subPerms.Require(models.PermManageRole); err != nil {
+		return nil, err
+	}
+	e, err := explainPerm(ctx, h.sharedDB, userID, h.rawSub, essayID, perm)
+	if err != nil {
+		return nil, err
+	}
+	e.Grants = hideGlobalDetails(e.Grants)
+	e.Blocks = hideGlobalDetails(e.Blocks)
+	e.Missing = hideGlobalDetails(e.Missing)
+	return e, nil
+}
+
+// hideGlobalDetails replaces the names of the global roles and the reason
+// of the global ban with generic details, merging the reasons left equal
+func hideGlobalDetails(reasons []models.PermReason) []models.PermReason {
+	res := make([]models.PermReason, 0, len(reasons))
+	seen := map[models.PermReason]bool{}
+	for _, r := range reasons {
+		switch r.Source {
+		case models.PermSourceGlobalRole, models.PermSourceGlobalDeny:
+			r.Detail = "global role"
+		case models.PermSourceGlobalBan:
+			r.Detail = "globally banned"
+		}
+		if !seen[r] {
+			seen[r] = true
+			res = append(res, r)
+		}
+	}
+	return res
+}
@@ -0,0 +1,237 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+// premoderate holds the essay when its author is new or has little karma in the subdiscepto,
+// and the subdiscepto asks for it. Moderators are never held.
+func (h *SubdisceptoH) premoderate(ctx context.Context, tx DBTX, sub *models.Subdiscepto, essay *models.Essay) error {
+	if sub.PremodMinDays <= 0 && sub.PremodMinKarma <= 0 || h.subPerms.Check(models.PermRemoveEssay) {
+		return nil
+	}
+	sql, args, _ := psql.
+		Select("EXTRACT(DAY FROM NOW() - users.created_at)::int").
+		Column(sq.Expr(`(SELECT COUNT(*) FROM votes
+			JOIN essays ON essays.id = votes.essay_id
+			WHERE essays.attributed_to_id = users.id AND essays.posted_in = ?
+			AND votes.vote_type = 'upvote' AND NOT votes.nullified)`, sub.Name)).
+		From("users").
+		Where(sq.Eq{"users.id": essay.AttributedToID}).
+		ToSql()
+
+	var accountAgeDays, karma int
+	err := tx.QueryRow(ctx, sql, args...).Scan(&accountAgeDays, &karma)
+	if err != nil {
+		return err
+	}
+	if accountAgeDays < sub.PremodMinDays {
+		return holdEssay(ctx, tx, essay.ID, fmt.Sprintf("Pre-moderation: account younger than %d days", sub.PremodMinDays))
+	}
+	if karma < sub.PremodMinKarma {
+		return holdEssay(ctx, tx, essay.ID, fmt.Sprintf("Pre-moderation: less than %d karma", sub.PremodMinKarma))
+	}
+	return nil
+}
+
+// ListHeldEssays lists the essays waiting for the approval of a moderator
+func (h *SubdisceptoH) ListHeldEssays(ctx context.Context) ([]models.HeldEssay, error) {
+	if err := h.subPerms.Require(models.PermRemoveEssay); err != nil {
+		return nil, err
+	}
+	sql, args, _ := psql.
+		Select(
+			"essays.id",
+			"essays.thesis",
+			authorIDColumn,
+			authorNameColumn,
+			"essays.held_at",
+			"essays.held_reason",
+		).
+		From("essays").
+		LeftJoin("users ON users.id = essays.attributed_to_id").
+		Where(sq.Eq{"essays.posted_in": h.rawSub.Name, "essays.removed_at": nil}).
+		Where(sq.NotEq{"essays.held_at": nil}).
+		OrderBy("essays.held_at").
+		ToSql()
+
+	essays := []models.HeldEssay{}
+	err := pgxscan.Select(ctx, h.sharedDB, &essays, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return essays, nil
+}
+
+// ApproveEssays approves many held essays at once. Either all of them are approved, or none.
+func (h *SubdisceptoH) ApproveEssays(ctx context.Context, uH *UserH, essayIDs []int) error {
+	essays := []*EssayH{}
+	err := execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		for _, id := range essayIDs {
+			esH, err := h.GetEssayH(ctx, id, uH)
+			if err != nil {
+				return err
+			}
+			if err := esH.essayPerms.Require(models.PermRemoveEssay); err != nil {
+				return err
+			}
+			err = esH.approveEssay(ctx, tx)
+			if err != nil {
+				return err
+			}
+			essays = append(essays, esH)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, esH := range essays {
+		err = esH.notifyApproval(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RejectEssays rejects many held essays at once, with the same removal reason.
+// Either all of them are rejected, or none.
+func (h *SubdisceptoH) RejectEssays(ctx context.Context, uH *UserH, essayIDs []int, reasonID int) error {
+	essays := []*EssayH{}
+	reasons := []string{}
+	err := execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		for _, id := range essayIDs {
+			esH, err := h.GetEssayH(ctx, id, uH)
+			if err != nil {
+				return err
+			}
+			if err := esH.essayPerms.Require(models.PermRemoveEssay); err != nil {
+				return err
+			}
+			reason, err := esH.rejectEssay(ctx, tx, reasonID)
+			if err != nil {
+				return err
+			}
+			essays = append(essays, esH)
+			reasons = append(reasons, reason)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, esH := range essays {
+		err = esH.notifyRejection(ctx, reasons[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RejectEssay removes an essay held for review, using one of the removal reasons of its subdiscepto
+func (h EssayH) RejectEssay(ctx context.Context, reasonID int) error {
+	if err := h.essayPerms.Require(models.PermRemoveEssay); err != nil {
+		return err
+	}
+	var reason string
+	err := execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		var err error
+		reason, err = h.rejectEssay(ctx, tx, reasonID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return h.notifyRejection(ctx, reason)
+}
+
+// rejectEssay removes the held essay, returning the removal reason
+func (h EssayH) rejectEssay(ctx context.Context, tx DBTX, reasonID int) (string, error) {
+	sql, args, _ := psql.
+		Select("1").
+		From("essays").
+		Where(sq.Eq{"id": h.id}).
+		Where(sq.NotEq{"held_at": nil}).
+		Suffix("FOR UPDATE").
+		ToSql()
+
+	var b int
+	err := tx.QueryRow(ctx, sql, args...).Scan(&b)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", models.ErrNotHeld
+	} else if err != nil {
+		return "", err
+	}
+
+	// Once removed, the essay is out of the queue.
+	// If it gets restored, it's published.
+	sql, args, _ = psql.
+		Update("essays").
+		Set("held_at", nil).
+		Set("held_reason", nil).
+		Where(sq.Eq{"id": h.id}).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return "", err
+	}
+	hTx := h
+	hTx.sharedDB = tx
+	err = hTx.RemoveEssay(ctx, reasonID)
+	if err != nil {
+		return "", err
+	}
+	sql, args, _ = psql.
+		Select("removal_reason").
+		From("essays").
+		Where(sq.Eq{"id": h.id}).
+		ToSql()
+
+	var reason string
+	err = tx.QueryRow(ctx, sql, args...).Scan(&reason)
+	return reason, err
+}
+func (h EssayH) notifyRejection(ctx context.Context, reason string) error {
+	return h.notifyModeration(ctx, fmt.Sprintf("Your essay has been rejected: %s", reason))
+}
+
+// notifyModeration tells the author of the essay about a decision of the moderators
+func (h EssayH) notifyModeration(ctx context.Context, text string) error {
+	sql, args, _ := psql.
+		Select("attributed_to_id", "posted_in", "thesis").
+		From("essays").
+		Where(sq.Eq{"id": h.id}).
+		ToSql()
+
+	var authorID *int
+	var postedIn, thesis string
+	err := h.sharedDB.QueryRow(ctx, sql, args...).Scan(&authorID, &postedIn, &thesis)
+	if err != nil {
+		return err
+	}
+	// The author deleted their account
+	if authorID == nil {
+		return nil
+	}
+	actionURL, err := url.Parse(fmt.Sprintf("/s/%s/%d", postedIn, h.id))
+	if err != nil {
+		return err
+	}
+	return h.notifService.Send(ctx, &models.Notification{
+		Title:     text,
+		Text:      thesis,
+		NotifType: models.NotifTypeModeration,
+		ActionURL: *actionURL,
+	}, *authorID)
+}
@@ -0,0 +1,244 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+func (h *SubdisceptoH) ListRemovalReasons(ctx context.Context) ([]models.RemovalReason, error) {
+	if !h.subPerms.Check(models.PermRemoveEssay) && !h.subPerms.Check(models.PermUpdateSubdiscepto) {
+		return nil, models.ErrPermDenied
+	}
+	sql, args, _ := psql.
+		Select("id", "subdiscepto", "text").
+		From("removal_reasons").
+		Where(sq.Eq{"subdiscepto": h.rawSub.Name}).
+		OrderBy("id").
+		ToSql()
+
+	reasons := []models.RemovalReason{}
+	err := pgxscan.Select(ctx, h.sharedDB, &reasons, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return reasons, nil
+}
+func (h *SubdisceptoH) CreateRemovalReason(ctx context.Context, text string) error {
+	if err := h.subPerms.Require(models.PermUpdateSubdiscepto); err != nil {
+		return err
+	}
+	if len(text) == 0 || len(text) > 200 {
+		return models.ErrInvalidRemovalReason
+	}
+	sql, args, _ := psql.
+		Insert("removal_reasons").
+		Columns("subdiscepto", "text").
+		Values(h.rawSub.Name, text).
+		ToSql()
+
+	_, err := h.sharedDB.Exec(ctx, sql, args...)
+	return err
+}
+func (h *SubdisceptoH) DeleteRemovalReason(ctx context.Context, reasonID int) error {
+	if err := h.subPerms.Require(models.PermUpdateSubdiscepto); err != nil {
+		return err
+	}
+	sql, args, _ := psql.
+		Delete("removal_reasons").
+		Where(sq.Eq{"id": reasonID, "subdiscepto": h.rawSub.Name}).
+		ToSql()
+
+	_, err := h.sharedDB.Exec(ctx, sql, args...)
+	return err
+}
+
+// ListRemovedEssays lists the removed essays whose content hasn't been purged yet
+func (h *SubdisceptoH) ListRemovedEssays(ctx context.Context) ([]models.RemovedEssay, error) {
+	if err := h.subPerms.Require(models.PermRemoveEssay); err != nil {
+		return nil, err
+	}
+	sql, args, _ := psql.
+		Select(
+			"essays.id",
+			"essays.thesis",
+			authorIDColumn,
+			authorNameColumn,
+			"essays.removed_at",
+			"moderators.name AS removed_by_name",
+			"essays.removal_reason",
+		).
+		From("essays").
+		LeftJoin("users ON users.id = essays.attributed_to_id").
+		LeftJoin("users AS moderators ON moderators.id = essays.removed_by").
+		Where(sq.Eq{"essays.posted_in": h.rawSub.Name, "essays.purged_at": nil}).
+		Where(sq.NotEq{"essays.removed_at": nil}).
+		OrderBy("essays.removed_at DESC").
+		ToSql()
+
+	essays := []models.RemovedEssay{}
+	err := pgxscan.Select(ctx, h.sharedDB, &essays, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return essays, nil
+}
+
+// RemoveEssay hides the essay, using one of the removal reasons of its subdiscepto.
+// Unlike DeleteEssay, the replies are kept and the essay can be restored.
+func (h EssayH) RemoveEssay(ctx context.Context, reasonID int) error {
+	if err := h.essayPerms.Require(models.PermRemoveEssay); err != nil {
+		return err
+	}
+	sql, args, _ := psql.
+		Select("removal_reasons.text").
+		From("removal_reasons").
+		Join("essays ON essays.posted_in = removal_reasons.subdiscepto").
+		Where(sq.Eq{"removal_reasons.id": reasonID, "essays.id": h.id}).
+		ToSql()
+
+	var reason string
+	err := h.sharedDB.QueryRow(ctx, sql, args...).Scan(&reason)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ErrInvalidRemovalReason
+	} else if err != nil {
+		return err
+	}
+
+	var removedBy interface{}
+	if h.audit.actorID != 0 {
+		removedBy = h.audit.actorID
+	}
+	sql, args, _ = psql.
+		Update("essays").
+		Set("removed_at", sq.Expr("NOW()")).
+		Set("removed_by", removedBy).
+		Set("removal_reason", reason).
+		Where(sq.Eq{"id": h.id, "removed_at": nil}).
+		Suffix("RETURNING thesis, content").
+		ToSql()
+
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		before := models.Essay{}
+		err := tx.QueryRow(ctx, sql, args...).Scan(&before.Thesis, &before.Content)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Already removed
+			return nil
+		} else if err != nil {
+			return err
+		}
+		err = labelSample(ctx, tx, h.id, true)
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditEssayRemove, fmt.Sprintf("essay %d", h.id),
+			map[string]string{"thesis": before.Thesis, "content": before.Content},
+			map[string]string{"reason": reason})
+	})
+}
+
+// RestoreEssay brings back a removed essay, if its content hasn't been purged yet
+func (h EssayH) RestoreEssay(ctx context.Context) error {
+	if err := h.essayPerms.Require(models.PermRemoveEssay); err != nil {
+		return err
+	}
+	return restoreEssay(ctx, h.sharedDB, h.audit, h.id)
+}
+func restoreEssay(ctx context.Context, db DBTX, audit auditor, essayID int) error {
+	return execTx(ctx, db, func(ctx context.Context, tx DBTX) error {
+		sql, args, _ := psql.
+			Select("removal_reason").
+			From("essays").
+			Where(sq.Eq{"id": essayID, "purged_at": nil}).
+			Where(sq.NotEq{"removed_at": nil}).
+			Suffix("FOR UPDATE").
+			ToSql()
+
+		var reason string
+		err := tx.QueryRow(ctx, sql, args...).Scan(&reason)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.ErrNotRemoved
+		} else if err != nil {
+			return err
+		}
+
+		sql, args, _ = psql.
+			Update("essays").
+			Set("removed_at", nil).
+			Set("removed_by", nil).
+			Set("removal_reason", nil).
+			Where(sq.Eq{"id": essayID}).
+			ToSql()
+
+		_, err = tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+		err = labelSample(ctx, tx, essayID, false)
+		if err != nil {
+			return err
+		}
+		return audit.record(ctx, tx, models.AuditEssayRestore, fmt.Sprintf("essay %d", essayID),
+			map[string]string{"reason": reason}, nil)
+	})
+}
+
+// PurgeRemovedEssays deletes the essays removed more than retentionDays ago.
+// Essays which still have replies are kept as empty placeholders, to keep the thread
+// together. They get deleted by a later run, once their replies are gone.
+// It's meant to be run periodically, in background.
+func (sdb SharedDB) PurgeRemovedEssays(ctx context.Context, retentionDays int) error {
+	expired := sq.And{
+		sq.NotEq{"essays.removed_at": nil},
+		sq.Expr("essays.removed_at < NOW() - make_interval(days => ?)", retentionDays),
+	}
+	return execTx(ctx, sdb.db, func(ctx context.Context, tx DBTX) error {
+		sql, args, _ := psql.
+			Delete("essays").
+			Where(expired).
+			Where("NOT EXISTS (SELECT 1 FROM essay_replies WHERE essay_replies.to_id = essays.id)").
+			ToSql()
+
+		_, err := tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+
+		sql, args, _ = psql.
+			Update("essays").
+			Set("thesis", "").
+			Set("content", "").
+			Set("purged_at", sq.Expr("NOW()")).
+			Where(expired).
+			Where(sq.Eq{"essays.purged_at": nil}).
+			ToSql()
+
+		_, err = tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+
+		sql, args, _ = psql.
+			Delete("essay_sources").
+			Where("essay_id IN (SELECT id FROM essays WHERE purged_at IS NOT NULL)").
+			ToSql()
+
+		_, err = tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+
+		sql, args, _ = psql.
+			Delete("essay_tags").
+			Where("essay_id IN (SELECT id FROM essays WHERE purged_at IS NOT NULL)").
+			ToSql()
+
+		_, err = tx.Exec(ctx, sql, args...)
+		return err
+	})
+}
@@ -2,16 +2,21 @@ package db
 
 import (
 	"context"
+	"fmt"
 
 	"gitlab.com/ranfdev/discepto/internal/models"
 )
 
 type RoleH struct {
-	id       int
-	name     string
-	preset   bool
-	domain   models.RoleDomain
-	sharedDB DBTX
+	id     int
+	name   string
+	preset bool
+	domain models.RoleDomain
+	rank   int
+	// Rank of the user managing the role
+	actorRank int
+	sharedDB  DBTX
+	audit     auditor
 }
 
 func (h *RoleH) ListActivePerms(ctx context.Context) (models.Perms, error) {
@@ -21,14 +26,68 @@ func (h *RoleH) UpdatePerms(ctx context.Context, perms models.Perms) error {
 	if h.preset {
 		return models.ErrRolePreset
 	}
-	return setPermissions(ctx, h.sharedDB, h.id, perms)
+	if h.rank > h.actorRank {
+		return models.ErrRoleRank
+	}
+	before, err := listRolePerms(ctx, h.sharedDB, h.id)
+	if err != nil {
+		return err
+	}
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		err := setPermissions(ctx, tx, h.id, perms)
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditRoleUpdatePerms, fmt.Sprintf("role %s", h.name),
+			permsRecord(before), permsRecord(perms))
+	})
+}
+
+// SetRank moves the role in the hierarchy, up to the rank of the user
+func (h *RoleH) SetRank(ctx context.Context, rank int) error {
+	if h.preset {
+		return models.ErrRolePreset
+	}
+	if rank < 0 || rank > h.actorRank || h.rank > h.actorRank {
+		return models.ErrRoleRank
+	}
+	err := execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		err := setRoleRank(ctx, tx, h.id, rank)
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditRoleUpdateRank, fmt.Sprintf("role %s", h.name),
+			map[string]int{"rank": h.rank}, map[string]int{"rank": rank})
+	})
+	if err != nil {
+		return err
+	}
+	h.rank = rank
+	return nil
+}
+func (h *RoleH) Rank() int {
+	return h.rank
 }
 func (h *RoleH) CanEdit() bool {
-	return !h.preset
+	return !h.preset && h.rank <= h.actorRank
 }
 func (h *RoleH) DeleteRole(ctx context.Context) error {
 	if h.preset {
 		return models.ErrPermDenied
 	}
-	return deleteRole(ctx, h.sharedDB, h.id)
+	if h.rank > h.actorRank {
+		return models.ErrRoleRank
+	}
+	before, err := listRolePerms(ctx, h.sharedDB, h.id)
+	if err != nil {
+		return err
+	}
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		err := deleteRole(ctx, tx, h.id)
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditRoleDelete, fmt.Sprintf("role %s", h.name),
+			permsRecord(before), nil)
+	})
 }
@@ -2,7 +2,6 @@ package db
 
 import (
 	"context"
-	"fmt"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/georgysavva/scany/pgxscan"
@@ -10,10 +9,21 @@ import (
 )
 
 var (
-	RoleDisceptoAdmin  = models.Role{ID: -123, Name: "admin", Preset: true}
+	RoleDisceptoAdmin  = models.Role{ID: -123, Name: "admin", Preset: true, Rank: models.RoleRankAdmin}
 	RoleDisceptoCommon = models.Role{ID: -100, Name: "common", Preset: true}
 )
 
+// Role assignments not expired yet
+var activeUserRoles = sq.Expr("(user_roles.expires_at IS NULL OR user_roles.expires_at > NOW())")
+
+// No active role of the user in the same domain denies the permission of role_perms
+var notDenied = sq.Expr(`NOT EXISTS (SELECT 1 FROM role_perms AS denies
+	JOIN user_roles AS denied_roles ON denied_roles.role_id = denies.role_id
+	JOIN roles AS denied_in ON denied_in.id = denies.role_id
+	WHERE denies.deny AND denies.permission = role_perms.permission
+	AND denied_roles.user_id = user_roles.user_id AND denied_in.roledomain_id = roles.roledomain_id
+	AND (denied_roles.expires_at IS NULL OR denied_roles.expires_at > NOW()))`)
+
 func createRoledomain(ctx context.Context, db DBTX, domainType string) (models.RoleDomain, error) {
 	sql, args, _ := psql.
 		Insert("roledomains").
@@ -28,9 +38,10 @@ func createRoledomain(ctx context.Context, db DBTX, domainType string) (models.R
 	return models.RoleDomain(id), err
 }
 func listRoles(ctx context.Context, db DBTX, domain models.RoleDomain) ([]models.Role, error) {
-	sql, args, _ := psql.Select("id", "name", "preset").
+	sql, args, _ := psql.Select("id", "name", "preset", "rank").
 		From("roles").
 		Where(sq.Eq{"roledomain_id": domain}).
+		OrderBy("rank DESC", "name").
 		ToSql()
 
 	rows, err := db.Query(ctx, sql, args...)
@@ -41,7 +52,7 @@ func listRoles(ctx context.Context, db DBTX, domain models.RoleDomain) ([]models
 	roles := []models.Role{}
 	for rows.Next() {
 		role := models.Role{}
-		err := rows.Scan(&role.ID, &role.Name, &role.Preset)
+		err := rows.Scan(&role.ID, &role.Name, &role.Preset, &role.Rank)
 		if err != nil {
 			return nil, err
 		}
@@ -50,10 +61,11 @@ func listRoles(ctx context.Context, db DBTX, domain models.RoleDomain) ([]models
 	return roles, err
 }
 func listUserRoles(ctx context.Context, db DBTX, userID int, domain models.RoleDomain) ([]models.Role, error) {
-	sql, args, _ := psql.Select("id", "name", "preset").
+	sql, args, _ := psql.Select("id", "name", "preset", "rank", "user_roles.expires_at").
 		From("roles").
 		Join("user_roles ON roles.id = user_roles.role_id").
 		Where(sq.Eq{"roledomain_id": domain, "user_id": userID}).
+		Where(activeUserRoles).
 		ToSql()
 
 	rows, err := db.Query(ctx, sql, args...)
@@ -64,7 +76,7 @@ func listUserRoles(ctx context.Context, db DBTX, userID int, domain models.RoleD
 	roles := []models.Role{}
 	for rows.Next() {
 		role := models.Role{}
-		err := rows.Scan(&role.ID, &role.Name, &role.Preset)
+		err := rows.Scan(&role.ID, &role.Name, &role.Preset, &role.Rank, &role.ExpiresAt)
 		if err != nil {
 			return nil, err
 		}
@@ -73,24 +85,29 @@ func listUserRoles(ctx context.Context, db DBTX, userID int, domain models.RoleD
 	return roles, err
 }
 func findRoleByName(ctx context.Context, db DBTX, domain models.RoleDomain, name string) (*models.Role, error) {
-	sql, args, _ := psql.Select("id", "name", "preset").
+	sql, args, _ := psql.Select("id", "name", "preset", "rank").
 		From("roles").
 		Where(sq.Eq{"roledomain_id": domain, "name": name}).
 		ToSql()
 
 	row := db.QueryRow(ctx, sql, args...)
 	role := models.Role{}
-	err := row.Scan(&role.ID, &role.Name, &role.Preset)
+	err := row.Scan(&role.ID, &role.Name, &role.Preset, &role.Rank)
 	if err != nil {
 		return nil, err
 	}
 	role.Domain = domain
 	return &role, nil
 }
-func listRolePerms(ctx context.Context, db DBTX, roleID int) (models.Perms, error) {
-	sql, args, _ := psql.Select("permission").
-		From("role_perms").
-		Where(sq.Eq{"role_id": roleID}).
+
+// listRolesWithPerm lists the roles of the domain granting the permission,
+// or denying it when deny is true
+func listRolesWithPerm(ctx context.Context, db DBTX, domain models.RoleDomain, perm models.Perm, deny bool) ([]models.Role, error) {
+	sql, args, _ := psql.Select("id", "name", "preset", "rank").
+		From("roles").
+		Join("role_perms ON role_perms.role_id = roles.id").
+		Where(sq.Eq{"roledomain_id": domain, "permission": perm, "deny": deny}).
+		OrderBy("rank DESC", "name").
 		ToSql()
 
 	rows, err := db.Query(ctx, sql, args...)
@@ -98,43 +115,93 @@ func listRolePerms(ctx context.Context, db DBTX, roleID int) (models.Perms, erro
 		return nil, err
 	}
 
-	perms := []models.Perm{}
+	roles := []models.Role{}
 	for rows.Next() {
-		perm := ""
-		err := rows.Scan(&perm)
+		role := models.Role{Domain: domain}
+		err := rows.Scan(&role.ID, &role.Name, &role.Preset, &role.Rank)
 		if err != nil {
 			return nil, err
 		}
-		perms = append(perms, models.Perm(perm))
+		roles = append(roles, role)
 	}
-	return models.NewPerms(perms...), err
+	return roles, err
 }
 
-func getUserPerms(ctx context.Context, db DBTX, domain models.RoleDomain, userID int) (models.Perms, error) {
-	sql, args, _ := psql.Select("permission").
-		From("user_roles").
-		Join("role_perms ON user_roles.role_id = role_perms.role_id").
-		Join("roles ON user_roles.role_id = roles.id").
-		Where(sq.Eq{"roledomain_id": domain, "user_id": userID}).
-		ToSql()
-
+// queryPerms reads rows of (permission, deny). Denies win over grants.
+func queryPerms(ctx context.Context, db DBTX, sql string, args ...interface{}) (models.Perms, error) {
 	rows, err := db.Query(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	arrPerms := []models.Perm{}
+	granted := []models.Perm{}
+	denied := []models.Perm{}
 	for rows.Next() {
 		perm := ""
-		err := rows.Scan(&perm)
+		deny := false
+		err := rows.Scan(&perm, &deny)
 		if err != nil {
 			return nil, err
 		}
-		arrPerms = append(arrPerms, models.Perm(perm))
+		if deny {
+			denied = append(denied, models.Perm(perm))
+		} else {
+			granted = append(granted, models.Perm(perm))
+		}
 	}
-	return models.NewPerms(arrPerms...), nil
+	return models.NewPerms(granted...).Deny(denied...), rows.Err()
+}
+func listRolePerms(ctx context.Context, db DBTX, roleID int) (models.Perms, error) {
+	sql, args, _ := psql.Select("permission", "deny").
+		From("role_perms").
+		Where(sq.Eq{"role_id": roleID}).
+		ToSql()
+
+	return queryPerms(ctx, db, sql, args...)
+}
+
+// getUserPerms sums the permissions of the active roles of the user in the domain.
+// A permission denied by any role isn't granted.
+func getUserPerms(ctx context.Context, db DBTX, domain models.RoleDomain, userID int) (models.Perms, error) {
+	sql, args, _ := psql.Select("permission", "deny").
+		From("user_roles").
+		Join("role_perms ON user_roles.role_id = role_perms.role_id").
+		Join("roles ON user_roles.role_id = roles.id").
+		Where(sq.Eq{"roledomain_id": domain, "user_id": userID}).
+		Where(activeUserRoles).
+		ToSql()
+
+	return queryPerms(ctx, db, sql, args...)
 }
 
+// getUserRank returns the rank of the highest active role of the user in the domain,
+// 0 when the user has no role
+func getUserRank(ctx context.Context, db DBTX, domain models.RoleDomain, userID int) (int, error) {
+	sql, args, _ := psql.
+		Select("COALESCE(MAX(roles.rank), 0)").
+		From("roles").
+		Join("user_roles ON roles.id = user_roles.role_id").
+		Where(sq.Eq{"roles.roledomain_id": domain, "user_roles.user_id": userID}).
+		Where(activeUserRoles).
+		ToSql()
+
+	var rank int
+	err := db.QueryRow(ctx, sql, args...).Scan(&rank)
+	return rank, err
+}
+
+// requireOutrank fails when the target user ranks at or above the actor
+func requireOutrank(ctx context.Context, db DBTX, domain models.RoleDomain, actorRank int, targetID int) error {
+	targetRank, err := getUserRank(ctx, db, domain, targetID)
+	if err != nil {
+		return err
+	}
+	if targetRank >= actorRank {
+		return models.ErrRoleRank
+	}
+	return nil
+}
 func assignRole(ctx context.Context, db DBTX, userID int, roleID int) error {
 	sql, args, _ := psql.Insert("user_roles").Columns("user_id", "role_id").Values(userID, roleID).ToSql()
 	_, err := db.Exec(ctx, sql, args...)
@@ -143,6 +210,36 @@ func assignRole(ctx context.Context, db DBTX, userID int, roleID int) error {
 	}
 	return nil
 }
+
+// assignRoleFor assigns a role for some days, 0 for a permanent assignment.
+// Assigning a role again replaces its expiry.
+func assignRoleFor(ctx context.Context, db DBTX, userID int, roleID int, days int) error {
+	var expiresAt interface{}
+	if days > 0 {
+		expiresAt = sq.Expr("NOW() + make_interval(days => ?)", days)
+	}
+	sql, args, _ := psql.
+		Insert("user_roles").
+		Columns("user_id", "role_id", "expires_at").
+		Values(userID, roleID, expiresAt).
+		Suffix("ON CONFLICT (user_id, role_id) DO UPDATE SET expires_at = EXCLUDED.expires_at").
+		ToSql()
+
+	_, err := db.Exec(ctx, sql, args...)
+	return err
+}
+
+// DeleteExpiredRoles deletes the expired role assignments.
+// It's meant to be run periodically, in background.
+func (sdb SharedDB) DeleteExpiredRoles(ctx context.Context) error {
+	sql, args, _ := psql.
+		Delete("user_roles").
+		Where("user_roles.expires_at <= NOW()").
+		ToSql()
+
+	_, err := sdb.db.Exec(ctx, sql, args...)
+	return err
+}
 func unassignRole(ctx context.Context, db DBTX, userID int, roleID int) error {
 	sql, args, _ := psql.Delete("user_roles").Where(sq.Eq{"user_id": userID, "role_id": roleID}).ToSql()
 	_, err := db.Exec(ctx, sql, args...)
@@ -172,8 +269,8 @@ func createRole(ctx context.Context, db DBTX, role models.Role, m models.Perms)
 	err := execTx(ctx, db, func(ctx context.Context, tx DBTX) error {
 		sql, args, _ := psql.
 			Insert("roles").
-			Columns("roledomain_id", "name", "preset").
-			Values(role.Domain, role.Name, role.Preset).
+			Columns("roledomain_id", "name", "preset", "rank").
+			Values(role.Domain, role.Name, role.Preset, role.Rank).
 			Suffix("RETURNING id").
 			ToSql()
 
@@ -191,6 +288,16 @@ func createRole(ctx context.Context, db DBTX, role models.Role, m models.Perms)
 	})
 	return rowID, err
 }
+func setRoleRank(ctx context.Context, db DBTX, roleID int, rank int) error {
+	sql, args, _ := psql.
+		Update("roles").
+		Set("rank", rank).
+		Where(sq.Eq{"id": roleID}).
+		ToSql()
+
+	_, err := db.Exec(ctx, sql, args...)
+	return err
+}
 func setPermissions(ctx context.Context, db DBTX, roleID int, perms models.Perms) error {
 	sql, args, _ := psql.
 		Delete("role_perms").
@@ -203,10 +310,10 @@ func setPermissions(ctx context.Context, db DBTX, roleID int, perms models.Perms
 	}
 	q := psql.
 		Insert("role_perms").
-		Columns("role_id", "permission")
+		Columns("role_id", "permission", "deny")
 
-	for perm := range perms {
-		q = q.Values(roleID, perm)
+	for perm, granted := range perms {
+		q = q.Values(roleID, perm, !granted)
 	}
 	sql, args, _ = q.ToSql()
 	_, err = db.Exec(ctx, sql, args...)
@@ -235,14 +342,16 @@ func listDomainsWithPerms(ctx context.Context,
 		Where(
 			sq.Eq{"user_roles.user_id": userID,
 				"roledomains.domain_type": domainType,
-				"permission":              perms.List()},
+				"permission":              perms.List(),
+				"role_perms.deny":         false},
 		).
+		Where(activeUserRoles).
+		Where(notDenied).
 		GroupBy("user_roles.user_id", "roledomains.id").
 		Having(sq.Eq{"COUNT(DISTINCT permission)": len(perms.List())}).
 		ToSql()
 
 	res := []models.RoleDomain{}
-	fmt.Println(sql, args)
 	err := pgxscan.Select(ctx, db, &res, sql, args...)
 	if err != nil {
 		return nil, err
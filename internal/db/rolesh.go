@@ -2,6 +2,8 @@ package db
 
 import (
 	"context"
+	"fmt"
+	"math"
 
 	"gitlab.com/ranfdev/discepto/internal/models"
 )
@@ -10,25 +12,46 @@ type RolesH struct {
 	contextPerms models.Perms
 	rolesPerms   models.Perms
 	domain       models.RoleDomain
-	sharedDB     DBTX
+	// Rank of the highest role of the user. Users and roles ranking at or
+	// above it can't be managed.
+	rank     int
+	sharedDB DBTX
+	audit    auditor
 }
 
-func newUnsafeRolesH(db DBTX, perms models.Perms, domain models.RoleDomain) *RolesH {
+func newUnsafeRolesH(db DBTX, perms models.Perms, domain models.RoleDomain, audit auditor) *RolesH {
 	return &RolesH{
 		contextPerms: perms,
 		rolesPerms:   models.NewPerms(models.PermManageRole),
 		domain:       domain,
+		rank:         math.MaxInt32,
 		sharedDB:     db,
+		audit:        audit,
 	}
 }
 
 func (h *RolesH) Assign(ctx context.Context, toUser int, roleH RoleH) error {
+	return h.AssignFor(ctx, toUser, roleH, 0)
+}
+
+// AssignFor assigns a role which expires after some days, 0 for a permanent role
+func (h *RolesH) AssignFor(ctx context.Context, toUser int, roleH RoleH, days int) error {
+	if days < 0 {
+		return models.ErrInvalidExpiry
+	}
 	if err := h.rolesPerms.Require(models.PermManageRole); err != nil {
 		return err
 	}
 	if roleH.domain != h.domain {
 		return models.ErrPermDenied
 	}
+	// Admins can make other admins
+	if roleH.rank > h.rank {
+		return models.ErrRoleRank
+	}
+	if err := requireOutrank(ctx, h.sharedDB, h.domain, h.rank, toUser); err != nil {
+		return err
+	}
 	newRolePerms, err := roleH.ListActivePerms(ctx)
 	if err != nil {
 		return err
@@ -36,7 +59,14 @@ func (h *RolesH) Assign(ctx context.Context, toUser int, roleH RoleH) error {
 	if err := h.contextPerms.RequirePerms(newRolePerms); err != nil {
 		return err
 	}
-	return assignRole(ctx, h.sharedDB, toUser, roleH.id)
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		err := assignRoleFor(ctx, tx, toUser, roleH.id, days)
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditRoleAssign, fmt.Sprintf("user %d", toUser),
+			nil, map[string]interface{}{"role": roleH.name, "days": days})
+	})
 }
 
 func (h *RolesH) Unassign(ctx context.Context, toUser int, roleH RoleH) error {
@@ -46,6 +76,9 @@ func (h *RolesH) Unassign(ctx context.Context, toUser int, roleH RoleH) error {
 	if roleH.domain != h.domain {
 		return models.ErrPermDenied
 	}
+	if err := requireOutrank(ctx, h.sharedDB, h.domain, h.rank, toUser); err != nil {
+		return err
+	}
 	newRolePerms, err := roleH.ListActivePerms(ctx)
 	if err != nil {
 		return err
@@ -53,7 +86,14 @@ func (h *RolesH) Unassign(ctx context.Context, toUser int, roleH RoleH) error {
 	if err := h.contextPerms.RequirePerms(newRolePerms); err != nil {
 		return err
 	}
-	return unassignRole(ctx, h.sharedDB, toUser, roleH.id)
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		err := unassignRole(ctx, tx, toUser, roleH.id)
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditRoleUnassign, fmt.Sprintf("user %d", toUser),
+			map[string]string{"role": roleH.name}, nil)
+	})
 }
 
 func (h *RolesH) ListRoles(ctx context.Context) ([]models.Role, error) {
@@ -76,7 +116,25 @@ func (h *RolesH) UnassignAll(ctx context.Context, userID int) error {
 	if err := h.rolesPerms.Require(models.PermManageRole); err != nil {
 		return err
 	}
-	return unassignAll(ctx, h.sharedDB, userID, h.domain)
+	if err := requireOutrank(ctx, h.sharedDB, h.domain, h.rank, userID); err != nil {
+		return err
+	}
+	roles, err := listUserRoles(ctx, h.sharedDB, userID, h.domain)
+	if err != nil {
+		return err
+	}
+	roleNames := []string{}
+	for _, r := range roles {
+		roleNames = append(roleNames, r.Name)
+	}
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		err := unassignAll(ctx, tx, userID, h.domain)
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditRoleUnassignAll, fmt.Sprintf("user %d", userID),
+			map[string][]string{"roles": roleNames}, nil)
+	})
 }
 
 func (h *RolesH) CreateRole(ctx context.Context, roleName string) (*RoleH, error) {
@@ -87,17 +145,33 @@ func (h *RolesH) CreateRole(ctx context.Context, roleName string) (*RoleH, error
 		Domain: h.domain,
 		Name:   roleName,
 		Preset: false,
-	}
-	id, err := createRole(ctx, h.sharedDB, role, models.NewPerms())
+		Rank:   models.RoleRankDefault,
+	}
+	if role.Rank > h.rank {
+		return nil, models.ErrRoleRank
+	}
+	var id int
+	err := execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		var err error
+		id, err = createRole(ctx, tx, role, models.NewPerms())
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditRoleCreate, fmt.Sprintf("role %s", roleName),
+			nil, map[string]string{"role": roleName})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &RoleH{
-		id:       id,
-		name:     roleName,
-		domain:   h.domain,
-		preset:   false,
-		sharedDB: h.sharedDB,
+		id:        id,
+		name:      roleName,
+		domain:    h.domain,
+		preset:    false,
+		rank:      role.Rank,
+		actorRank: h.rank,
+		sharedDB:  h.sharedDB,
+		audit:     h.audit,
 	}, nil
 }
 
@@ -115,11 +189,14 @@ func (h *RolesH) GetRoleH(ctx context.Context, roleName string) (*RoleH, error)
 	}
 
 	return &RoleH{
-		id:       role.ID,
-		name:     role.Name,
-		preset:   role.Preset,
-		domain:   h.domain,
-		sharedDB: h.sharedDB,
+		id:        role.ID,
+		name:      role.Name,
+		preset:    role.Preset,
+		domain:    h.domain,
+		rank:      role.Rank,
+		actorRank: h.rank,
+		sharedDB:  h.sharedDB,
+		audit:     h.audit,
 	}, nil
 }
 
@@ -128,6 +205,8 @@ func (h *RolesH) withTx(tx DBTX) RolesH {
 		contextPerms: h.contextPerms,
 		rolesPerms:   h.rolesPerms,
 		domain:       h.domain,
+		rank:         h.rank,
 		sharedDB:     tx,
+		audit:        h.audit,
 	}
 }
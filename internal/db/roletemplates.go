@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+// ExportRoles describes the roles of the subdiscepto, to be imported elsewhere
+func (h *SubdisceptoH) ExportRoles(ctx context.Context) (*models.RoleTemplate, error) {
+	if err := h.rolesPerms.Require(models.PermManageRole); err != nil {
+		return nil, err
+	}
+	roles, err := listRoles(ctx, h.sharedDB, h.domain)
+	if err != nil {
+		return nil, err
+	}
+	t := &models.RoleTemplate{Roles: []models.RoleTemplateEntry{}}
+	for _, r := range roles {
+		perms, err := listRolePerms(ctx, h.sharedDB, r.ID)
+		if err != nil {
+			return nil, err
+		}
+		t.Roles = append(t.Roles, models.RoleTemplateEntry{
+			Name:  r.Name,
+			Rank:  r.Rank,
+			Perms: sortedPerms(perms.List()),
+			Deny:  sortedPerms(perms.Denied()),
+		})
+	}
+	return t, nil
+}
+
+// ImportRoles creates the roles of the template, or updates the ones with the same name.
+// Preset roles are left as they are. The template can't grant more than what the user
+// has, nor rank roles above the user.
+func (h *SubdisceptoH) ImportRoles(ctx context.Context, t models.RoleTemplate) error {
+	if err := h.rolesPerms.Require(models.PermManageRole); err != nil {
+		return err
+	}
+	if err := t.Validate(h.ListAvailablePerms()); err != nil {
+		return err
+	}
+	for _, r := range t.Roles {
+		if err := h.contextPerms.RequirePerms(r.PermSet()); err != nil {
+			return err
+		}
+		if r.Rank > h.rank {
+			return models.ErrRoleRank
+		}
+	}
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		for _, r := range t.Roles {
+			perms := r.PermSet()
+			role, err := findRoleByName(ctx, tx, h.domain, r.Name)
+			if errors.Is(err, pgx.ErrNoRows) {
+				_, err = createRole(ctx, tx, models.Role{Domain: h.domain, Name: r.Name, Rank: r.Rank}, perms)
+				if err != nil {
+					return err
+				}
+				continue
+			} else if err != nil {
+				return err
+			}
+			if role.Preset {
+				continue
+			}
+			if role.Rank > h.rank {
+				return models.ErrRoleRank
+			}
+			err = setPermissions(ctx, tx, role.ID, perms)
+			if err != nil {
+				return err
+			}
+			err = setRoleRank(ctx, tx, role.ID, r.Rank)
+			if err != nil {
+				return err
+			}
+		}
+		return h.audit.record(ctx, tx, models.AuditRoleImport, "roles", nil, t)
+	})
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"gitlab.com/ranfdev/discepto/internal/spam"
+)
+
+const (
+	// New essays scoring at least this are held for review
+	SpamHoldThreshold = 0.95
+	// The classifier isn't used until it learned from this many samples of each kind
+	SpamMinSamples = 20
+)
+
+// spamModelCache keeps the decoded classifier in memory,
+// decoding it again only when it's retrained
+type spamModelCache struct {
+	sync.Mutex
+	trainedAt  time.Time
+	classifier *spam.Classifier
+}
+
+var spamModel spamModelCache
+
+func (c *spamModelCache) set(trainedAt time.Time, classifier *spam.Classifier) {
+	c.Lock()
+	defer c.Unlock()
+	c.trainedAt = trainedAt
+	c.classifier = classifier
+}
+
+// labelSample records the decision of a moderator about an essay,
+// to train the spam classifier. The latest decision wins.
+// Purged essays have nothing left to learn from and are skipped.
+func labelSample(ctx context.Context, db DBTX, essayID int, isSpam bool) error {
+	sql, args, _ := psql.
+		Insert("spam_samples").
+		Columns("essay_id", "text", "spam").
+		Select(psql.
+			Select("id", "thesis || E'\\n' || content").
+			Column("?::boolean", isSpam).
+			From("essays").
+			Where(sq.Eq{"id": essayID}).
+			Where("purged_at IS NULL")).
+		Suffix("ON CONFLICT (essay_id) DO UPDATE SET spam = EXCLUDED.spam, labeled_at = NOW()").
+		ToSql()
+
+	_, err := db.Exec(ctx, sql, args...)
+	return err
+}
+
+// RetrainSpamModel trains a new spam classifier from every labeled sample,
+// replacing the stored one. It returns the number of samples used.
+func (sdb SharedDB) RetrainSpamModel(ctx context.Context) (int, error) {
+	sql, args, _ := psql.
+		Select("text", "spam").
+		From("spam_samples").
+		ToSql()
+
+	var samples []struct {
+		Text string
+		Spam bool
+	}
+	err := pgxscan.Select(ctx, sdb.db, &samples, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	classifier := spam.New()
+	for _, s := range samples {
+		classifier.Train(s.Text, s.Spam)
+	}
+
+	sql, args, _ = psql.
+		Insert("spam_model").
+		Columns("model", "samples").
+		Values(classifier, len(samples)).
+		Suffix("ON CONFLICT (id) DO UPDATE SET model = EXCLUDED.model, samples = EXCLUDED.samples, trained_at = NOW()").
+		Suffix("RETURNING trained_at").
+		ToSql()
+
+	var trainedAt time.Time
+	err = sdb.db.QueryRow(ctx, sql, args...).Scan(&trainedAt)
+	if err != nil {
+		return 0, err
+	}
+	spamModel.set(trainedAt, classifier)
+	return len(samples), nil
+}
+
+// loadSpamModel returns the stored classifier, or nil if it was never trained.
+// The model is decoded again only when it was retrained since the last time,
+// possibly by another process.
+func loadSpamModel(ctx context.Context, db DBTX) (*spam.Classifier, error) {
+	sql, args, _ := psql.
+		Select("trained_at").
+		From("spam_model").
+		ToSql()
+
+	var trainedAt time.Time
+	err := db.QueryRow(ctx, sql, args...).Scan(&trainedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	spamModel.Lock()
+	defer spamModel.Unlock()
+	if spamModel.classifier != nil && spamModel.trainedAt.Equal(trainedAt) {
+		return spamModel.classifier, nil
+	}
+
+	sql, args, _ = psql.
+		Select("model").
+		From("spam_model").
+		ToSql()
+
+	classifier := spam.New()
+	err = db.QueryRow(ctx, sql, args...).Scan(classifier)
+	if err != nil {
+		return nil, err
+	}
+	spamModel.trainedAt = trainedAt
+	spamModel.classifier = classifier
+	return classifier, nil
+}
+
+// checkSpam holds the essay for review if the classifier thinks it's spam
+func checkSpam(ctx context.Context, db DBTX, essayID int, text string) error {
+	classifier, err := loadSpamModel(ctx, db)
+	if err != nil || classifier == nil || !classifier.Ready(SpamMinSamples) {
+		return err
+	}
+	score := classifier.Score(text)
+	if score < SpamHoldThreshold {
+		return nil
+	}
+	return holdEssay(ctx, db, essayID, fmt.Sprintf("Spam filter: %.0f%%", score*100))
+}
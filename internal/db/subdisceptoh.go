@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
 
 	sq "github.com/Masterminds/squirrel"
 	"gitlab.com/ranfdev/discepto/internal/models"
@@ -21,11 +22,18 @@ type SubdisceptoH struct {
 	rawSub       *models.Subdiscepto
 	subPerms     models.Perms
 	notifService models.NotificationService
+	ban          *models.Ban
+	nsfwPref     models.NsfwPref
 }
 
 func (h *SubdisceptoH) Perms() models.Perms {
 	return h.subPerms
 }
+
+// Nsfw tells whether the whole subdiscepto is marked as NSFW
+func (h *SubdisceptoH) Nsfw() bool {
+	return h.rawSub.Nsfw
+}
 func (h *SubdisceptoH) ReadView(ctx context.Context, userH *UserH) (*models.SubdisceptoView, error) {
 	if err := h.subPerms.Require(models.PermReadSubdiscepto); err != nil {
 		return nil, err
@@ -73,39 +81,53 @@ func (h *SubdisceptoH) CreateEssayReply(ctx context.Context, e *models.Essay, pH
 		return nil, err
 	}
 
-	// Prepare and send notification
-	parentEssayH, err := h.GetEssayH(ctx, int(e.InReplyTo.Int32), nil)
+	err = essay.notifyReply(ctx)
 	if err != nil {
 		return nil, err
 	}
-	parentEssay, err := parentEssayH.ReadView(ctx)
-	if err != nil {
-		return nil, err
+	return essay, nil
+}
+
+// notifyReply tells the author of the parent essay about the reply.
+// Held replies are notified once approved.
+func (h EssayH) notifyReply(ctx context.Context) error {
+	sql, args, _ := psql.
+		Select("essays.attributed_to_id", "essays.posted_in", "parent.attributed_to_id", "essays.held_at IS NOT NULL").
+		From("essays").
+		Join("essay_replies ON essay_replies.from_id = essays.id").
+		Join("essays AS parent ON parent.id = essay_replies.to_id").
+		Where(sq.Eq{"essays.id": h.id}).
+		ToSql()
+
+	var authorID, parentAuthorID *int
+	var postedIn string
+	var held bool
+	err := h.sharedDB.QueryRow(ctx, sql, args...).Scan(&authorID, &postedIn, &parentAuthorID, &held)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Not a reply
+		return nil
+	} else if err != nil {
+		return err
 	}
-	if parentEssay.AttributedToID == e.AttributedToID {
-		// Don't notify to self
-		return essay, nil
+	// Don't notify to self, nor to deleted users
+	if held || authorID == nil || parentAuthorID == nil || *authorID == *parentAuthorID {
+		return nil
 	}
-	url, err := url.Parse(fmt.Sprintf("/s/%s/%d", e.PostedIn, e.ID))
+	url, err := url.Parse(fmt.Sprintf("/s/%s/%d", postedIn, h.id))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	user, err := readPublicUser(ctx, h.sharedDB, e.AttributedToID)
+	user, err := readPublicUser(ctx, h.sharedDB, *authorID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	err = h.notifService.Send(ctx, &models.Notification{
+	return h.notifService.Send(ctx, &models.Notification{
 		Title:     user.Name,
 		Text:      "replied to your essay",
 		NotifType: models.NotifTypeReply,
 		ActionURL: *url,
-	}, parentEssay.AttributedToID)
-	if err != nil {
-		return nil, err
-	}
-
-	return essay, nil
+	}, *parentAuthorID)
 }
 func (h *SubdisceptoH) ListAvailablePerms() models.Perms {
 	return models.PermsSubAdmin
@@ -117,6 +139,9 @@ func (h *SubdisceptoH) AddMember(ctx context.Context, userH UserH) error {
 	if err := h.subPerms.Require(models.PermReadSubdiscepto); err != nil {
 		return err
 	}
+	if h.rawSub.JoinMode != models.JoinOpen && !h.subPerms.Check(models.PermManageMembers) {
+		return models.ErrJoinClosed
+	}
 	err := addMember(ctx, h.sharedDB, h.rawSub, userH.id)
 	if err != nil {
 		return rejoin(ctx, h.sharedDB, h.rawSub, userH.id, h.subPerms)
@@ -135,7 +160,7 @@ func (h *SubdisceptoH) RemoveMember(ctx context.Context, userH UserH) error {
 		if err != nil {
 			return err
 		}
-		rolesH := newUnsafeRolesH(h.sharedDB, h.subPerms, h.domain).withTx(tx)
+		rolesH := newUnsafeRolesH(h.sharedDB, h.subPerms, h.domain, h.audit).withTx(tx)
 		err = rolesH.UnassignAll(ctx, userH.id)
 		if err != nil {
 			return err
@@ -151,13 +176,19 @@ func (h *SubdisceptoH) RemoveMember(ctx context.Context, userH UserH) error {
 	})
 }
 
-func (h *SubdisceptoH) ListReports(ctx context.Context) ([]models.ReportView, error) {
+func (h *SubdisceptoH) ListReports(ctx context.Context, filter models.ReportFilter) ([]models.ReportView, error) {
 	if err := h.subPerms.Require(models.PermViewReport); err != nil {
 		return nil, err
 	}
-	sql, args, _ := psql.Select(
+	query := psql.Select(
 		"reports.id",
 		"reports.description",
+		"reports.flag_type",
+		"reports.state",
+		"reports.created_at",
+		"moderators.name AS moderator_name",
+		"reports.resolution",
+		"reports.closed_at",
 		"essay_view.thesis AS \"essay_view.thesis\"",
 		"essay_view.content AS \"essay_view.content\"",
 		"essay_view.id AS \"essay_view.id\"",
@@ -172,7 +203,16 @@ func (h *SubdisceptoH) ListReports(ctx context.Context) ([]models.ReportView, er
 			"essay_view",
 		).
 		Join("reports ON essay_view.id = reports.essay_id").
-		ToSql()
+		LeftJoin("users AS moderators ON moderators.id = reports.moderator_id").
+		OrderBy("reports.created_at DESC")
+
+	if filter.State != "" {
+		query = query.Where(sq.Eq{"reports.state": filter.State})
+	}
+	if filter.FlagType != "" {
+		query = query.Where(sq.Eq{"reports.flag_type": filter.FlagType})
+	}
+	sql, args, _ := query.ToSql()
 
 	reports := []models.ReportView{}
 	err := pgxscan.Select(ctx, h.sharedDB, &reports, sql, args...)
@@ -181,25 +221,122 @@ func (h *SubdisceptoH) ListReports(ctx context.Context) ([]models.ReportView, er
 	}
 	return reports, nil
 }
+
+// ClaimReport assigns an open report to the moderator
+func (h *SubdisceptoH) ClaimReport(ctx context.Context, id int, uH UserH) error {
+	if err := h.subPerms.Require(models.PermViewReport); err != nil {
+		return err
+	}
+	sql, args, _ := psql.
+		Update("reports").
+		Set("state", models.ReportStateClaimed).
+		Set("moderator_id", uH.id).
+		Where(sq.Eq{"id": id, "state": models.ReportStateOpen}).
+		Where("essay_id IN (SELECT id FROM essays WHERE posted_in = ?)", h.rawSub.Name).
+		ToSql()
+
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		tag, err := tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return models.ErrInvalidReport
+		}
+		return h.audit.record(ctx, tx, models.AuditReportClaim, fmt.Sprintf("report %d", id),
+			map[string]models.ReportState{"state": models.ReportStateOpen},
+			map[string]models.ReportState{"state": models.ReportStateClaimed})
+	})
+}
+
+// CloseReport marks a report as resolved or dismissed, recording the resolution.
+// A claimed report can only be closed by the moderator who claimed it.
+func (h *SubdisceptoH) CloseReport(ctx context.Context, id int, uH UserH, state models.ReportState, resolution string) error {
+	if err := h.subPerms.Require(models.PermDeleteReport); err != nil {
+		return err
+	}
+	if state != models.ReportStateResolved && state != models.ReportStateDismissed {
+		return models.ErrInvalidReport
+	}
+	if len(resolution) > 500 {
+		return models.ErrInvalidReport
+	}
+	sql, args, _ := psql.
+		Update("reports").
+		Set("state", state).
+		Set("moderator_id", uH.id).
+		Set("resolution", resolution).
+		Set("closed_at", sq.Expr("NOW()")).
+		Where(sq.Or{
+			sq.Eq{"state": models.ReportStateOpen},
+			sq.Eq{"state": models.ReportStateClaimed, "moderator_id": uH.id},
+		}).
+		Where(sq.Eq{"id": id}).
+		Where("essay_id IN (SELECT id FROM essays WHERE posted_in = ?)", h.rawSub.Name).
+		Suffix("RETURNING essay_id, flag_type").
+		ToSql()
+
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		var essayID int
+		var flagType models.FlagType
+		err := tx.QueryRow(ctx, sql, args...).Scan(&essayID, &flagType)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.ErrInvalidReport
+		} else if err != nil {
+			return err
+		}
+
+		// Teach the spam classifier
+		if state == models.ReportStateDismissed {
+			err = labelSample(ctx, tx, essayID, false)
+		} else if flagType == models.FlagTypeSpam {
+			err = labelSample(ctx, tx, essayID, true)
+		}
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditReportClose, fmt.Sprintf("report %d", id),
+			nil, map[string]interface{}{"state": state, "resolution": resolution})
+	})
+}
 func (h *SubdisceptoH) DeleteReport(ctx context.Context, id int) error {
 	if err := h.subPerms.Require(models.PermDeleteReport); err != nil {
 		return err
 	}
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		before, err := deleteReport(ctx, tx, sq.Eq{"id": id})
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditReportDelete, fmt.Sprintf("report %d", id), before, nil)
+	})
+}
+
+// deleteReport deletes the matching report, returning it
+func deleteReport(ctx context.Context, db DBTX, where sq.Sqlizer) (*models.Report, error) {
 	sql, args, _ := psql.
 		Delete("reports").
-		Where(sq.Eq{"id": id}).
+		Where(where).
+		// AutoMod reports have no author
+		Suffix("RETURNING id, description, flag_type, essay_id, COALESCE(from_user_id, 0)").
 		ToSql()
 
-	_, err := h.sharedDB.Exec(ctx, sql, args...)
+	report := &models.Report{}
+	err := db.QueryRow(ctx, sql, args...).
+		Scan(&report.ID, &report.Description, &report.FlagType, &report.EssayID, &report.FromUserID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return report, nil
 }
 func (h *SubdisceptoH) Update(ctx context.Context, subReq *models.SubdisceptoReq) error {
 	if err := h.subPerms.Require(models.PermUpdateSubdiscepto); err != nil {
 		return err
 	}
+	joinMode, err := parseJoinMode(subReq.JoinMode)
+	if err != nil {
+		return err
+	}
 	sql, args, _ := psql.
 		Update("subdisceptos").
 		Set("description", subReq.Description).
@@ -207,11 +344,32 @@ func (h *SubdisceptoH) Update(ctx context.Context, subReq *models.SubdisceptoReq
 		Set("nsfw", subReq.Nsfw).
 		Set("public", subReq.Public).
 		Set("min_length", subReq.MinLength).
+		Set("weighted_votes", subReq.WeightedVotes).
+		Set("premod_min_days", subReq.PremodMinDays).
+		Set("premod_min_karma", subReq.PremodMinKarma).
+		Set("join_mode", joinMode).
 		Where(sq.Eq{"name": h.rawSub.Name}).
 		ToSql()
 
-	_, err := h.sharedDB.Exec(ctx, sql, args...)
-	return err
+	before := models.SubdisceptoReq{
+		Name:              h.rawSub.Name,
+		Description:       h.rawSub.Description,
+		MinLength:         h.rawSub.MinLength,
+		QuestionsRequired: h.rawSub.QuestionsRequired,
+		Public:            h.rawSub.Public,
+		Nsfw:              h.rawSub.Nsfw,
+		WeightedVotes:     h.rawSub.WeightedVotes,
+		PremodMinDays:     h.rawSub.PremodMinDays,
+		PremodMinKarma:    h.rawSub.PremodMinKarma,
+		JoinMode:          h.rawSub.JoinMode,
+	}
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		_, err := tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditSubUpdate, fmt.Sprintf("subdiscepto %s", h.rawSub.Name), before, subReq)
+	})
 }
 func createReply(ctx context.Context, db DBTX, fromID int, toID int, replyType string) error {
 	_, err := db.Exec(ctx,
@@ -228,14 +386,14 @@ func (h *SubdisceptoH) GetEssayH(ctx context.Context, id int, uH *UserH) (*Essay
 func (h *SubdisceptoH) getEssayH(ctx context.Context, id int, uH *UserH) (*EssayH, error) {
 	// Check if essay is inside this subdiscepto
 	sql, args, _ := psql.
-		Select("1").
+		Select("held_at IS NOT NULL", "members_only", "nsfw").
 		From("essays").
 		Where(sq.Eq{"posted_in": h.rawSub.Name, "id": id}).
 		ToSql()
 
 	row := h.sharedDB.QueryRow(ctx, sql, args...)
-	var b int
-	err := row.Scan(&b)
+	var held, membersOnly, nsfw bool
+	err := row.Scan(&held, &membersOnly, &nsfw)
 
 	if err != nil {
 		return nil, err
@@ -246,6 +404,14 @@ func (h *SubdisceptoH) getEssayH(ctx context.Context, id int, uH *UserH) (*Essay
 		// Check if user owns the essay
 		isOwner = isEssayOwner(ctx, h.sharedDB, id, uH.id)
 	}
+	// Until approved, held essays are visible only to their author and moderators
+	if held && !isOwner && !h.subPerms.Check(models.PermRemoveEssay) {
+		return nil, pgx.ErrNoRows
+	}
+	// Members-only essays are hidden, as if they didn't exist, to who can't read them
+	if membersOnly && !isOwner && !h.subPerms.Check(models.PermReadEssay) {
+		return nil, pgx.ErrNoRows
+	}
 
 	essayPerms := h.subPerms
 
@@ -254,7 +420,7 @@ func (h *SubdisceptoH) getEssayH(ctx context.Context, id int, uH *UserH) (*Essay
 	}
 
 	// Finally assign capabilities
-	e := &EssayH{h.sharedDB, id, essayPerms, h.notifService}
+	e := &EssayH{h.sharedDB, id, essayPerms, h.notifService, h.audit, nsfw}
 	return e, nil
 }
 func (h *SubdisceptoH) ListEssays(ctx context.Context) ([]models.EssayView, error) {
@@ -323,9 +489,21 @@ func (h *SubdisceptoH) createEssay(ctx context.Context, tx DBTX, essay *models.E
 	if err != nil {
 		return nil, err
 	}
+	err = h.applyAutomod(ctx, tx, essay)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSpam(ctx, tx, essay.ID, essay.Thesis+"\n"+essay.Content)
+	if err != nil {
+		return nil, err
+	}
+	err = h.premoderate(ctx, tx, subData, essay)
+	if err != nil {
+		return nil, err
+	}
 	essayPerms := h.subPerms.Union(models.NewPerms(models.PermDeleteEssay))
 
-	return &EssayH{h.sharedDB, essay.ID, essayPerms, h.notifService}, err
+	return &EssayH{h.sharedDB, essay.ID, essayPerms, h.notifService, h.audit, essay.Nsfw}, err
 }
 func insertEssay(ctx context.Context, tx DBTX, essay *models.Essay) error {
 	// Insert essay
@@ -337,6 +515,8 @@ func insertEssay(ctx context.Context, tx DBTX, essay *models.Essay) error {
 			"attributed_to_id",
 			"published",
 			"posted_in",
+			"nsfw",
+			"members_only",
 		).
 		Suffix("RETURNING id").
 		Values(
@@ -345,6 +525,8 @@ func insertEssay(ctx context.Context, tx DBTX, essay *models.Essay) error {
 			essay.AttributedToID,
 			essay.Published,
 			essay.PostedIn,
+			essay.Nsfw,
+			essay.MembersOnly,
 		).
 		ToSql()
 
@@ -387,6 +569,8 @@ func selectSubdiscepto(userID *int) sq.SelectBuilder {
 	return psql.Select(
 		"name",
 		"description",
+		"subdisceptos.nsfw",
+		"subdisceptos.join_mode",
 		"COUNT(DISTINCT subdiscepto_users.user_id) AS members_count",
 	).
 		Column("bool_or(CASE subdiscepto_users.user_id WHEN ? THEN true ELSE false END) AS is_member", userID).
@@ -420,10 +604,17 @@ func (h *SubdisceptoH) ReadRaw(ctx context.Context) (*models.Subdiscepto, error)
 func (h *SubdisceptoH) listEssays(ctx context.Context) ([]models.EssayView, error) {
 	var essays []models.EssayView
 
-	sql, args, _ := selectEssayWithJoins.
+	query := selectEssayWithJoins
+	orderBy := []string{"essays.id DESC"}
+	if h.rawSub.WeightedVotes {
+		query = query.Column(weightedScoreColumn)
+		orderBy = append([]string{"weighted_score DESC"}, orderBy...)
+	}
+	query = filterMembersOnly(query, h.subPerms)
+	sql, args, _ := filterNsfw(query, h.nsfwPref).
 		GroupBy("essays.id", "users.name", "essay_replies.to_id", "essay_replies.reply_type").
-		Where(sq.Eq{"posted_in": h.rawSub.Name}).
-		OrderBy("essays.id DESC").
+		Where(sq.Eq{"posted_in": h.rawSub.Name, "essays.removed_at": nil, "essays.held_at": nil}).
+		OrderBy(orderBy...).
 		ToSql()
 
 	err := pgxscan.Select(ctx, h.sharedDB, &essays, sql, args...)
@@ -435,14 +626,14 @@ func (h *SubdisceptoH) listReplies(ctx context.Context, e EssayH, replyType *str
 		filterByType = sq.Eq{"reply_type": replyType}
 	}
 
-	sql, args, _ := selectEssay.
+	sql, args, _ := filterMembersOnly(selectEssay, h.subPerms).
 		From("essay_replies").
 		Join("essays ON essays.id = essay_replies.from_id ").
-		LeftJoin("votes ON essays.id = votes.essay_id").
-		Join("users ON essays.attributed_to_id = users.id").
+		LeftJoin("votes ON essays.id = votes.essay_id AND NOT votes.nullified").
+		LeftJoin("users ON essays.attributed_to_id = users.id").
 		Where(
 			sq.And{
-				sq.Eq{"essay_replies.to_id": e.id},
+				sq.Eq{"essay_replies.to_id": e.id, "essays.held_at": nil},
 				filterByType,
 			},
 		).
@@ -458,12 +649,16 @@ func (h *SubdisceptoH) listReplies(ctx context.Context, e EssayH, replyType *str
 }
 func (h *SubdisceptoH) deleteSubdiscepto(ctx context.Context) error {
 	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		err := h.audit.record(ctx, tx, models.AuditSubDelete, fmt.Sprintf("subdiscepto %s", h.rawSub.Name), h.rawSub, nil)
+		if err != nil {
+			return err
+		}
 		sql, args, _ := psql.
 			Delete("subdisceptos").
 			Where(sq.Eq{"name": h.rawSub.Name}).
 			ToSql()
 
-		_, err := tx.Exec(ctx, sql, args...)
+		_, err = tx.Exec(ctx, sql, args...)
 		if err != nil {
 			return err
 		}
@@ -510,6 +705,29 @@ func addMember(ctx context.Context, db DBTX, rawSub *models.Subdiscepto, userID
 	})
 	return err
 }
+
+// addOrRejoinMember makes the user a member, as AddMember does,
+// on behalf of a moderator or an invite
+func addOrRejoinMember(ctx context.Context, db DBTX, rawSub *models.Subdiscepto, userID int) error {
+	err := addMember(ctx, db, rawSub, userID)
+	if err == nil {
+		return nil
+	}
+	perms, err := getUserPerms(ctx, db, rawSub.RoledomainID, userID)
+	if err != nil {
+		return err
+	}
+	return rejoin(ctx, db, rawSub, userID, perms)
+}
+func parseJoinMode(m models.JoinMode) (models.JoinMode, error) {
+	if m == "" {
+		return models.JoinOpen, nil
+	}
+	if !m.Valid() {
+		return "", models.ErrInvalidFormat
+	}
+	return m, nil
+}
 func rejoin(ctx context.Context, db DBTX, rawSub *models.Subdiscepto, userID int, perms models.Perms) error {
 	sql, args, _ := psql.
 		Update("subdiscepto_users").
@@ -575,6 +793,10 @@ func insertSubdiscepto(ctx context.Context, db DBTX, sub models.Subdiscepto) err
 			"questions_required",
 			"nsfw",
 			"public",
+			"weighted_votes",
+			"premod_min_days",
+			"premod_min_karma",
+			"join_mode",
 			"roledomain_id").
 		Values(sub.Name,
 			sub.Description,
@@ -582,6 +804,10 @@ func insertSubdiscepto(ctx context.Context, db DBTX, sub models.Subdiscepto) err
 			sub.QuestionsRequired,
 			sub.Nsfw,
 			sub.Public,
+			sub.WeightedVotes,
+			sub.PremodMinDays,
+			sub.PremodMinKarma,
+			sub.JoinMode,
 			sub.RoledomainID).
 		ToSql()
 	_, err := db.Exec(ctx, sql, args...)
@@ -2,9 +2,12 @@ package db
 
 import (
 	"context"
+	"fmt"
+	"net"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
 	"gitlab.com/ranfdev/discepto/internal/models"
 )
 
@@ -58,23 +61,169 @@ func (h UserH) Read(ctx context.Context) (*models.User, error) {
 	}
 	return user, nil
 }
-func (h UserH) Delete(ctx context.Context) error {
+
+// Delete deletes the account of the user, choosing what happens to their essays
+func (h UserH) Delete(ctx context.Context, essays models.EssayDeletion) error {
 	if !h.perms.Delete {
 		return models.ErrPermDenied
 	}
-	return h.deleteUser(ctx)
+	return deleteUser(ctx, h.sharedDB, h.id, essays)
 }
-func (h *UserH) deleteUser(ctx context.Context) error {
-	sql, args, _ := psql.Delete("users").Where(sq.Eq{"id": h.id}).ToSql()
+
+// setSignupIP records the address the user signed up from.
+// It's used to find accounts potentially controlled by the same person.
+func setSignupIP(ctx context.Context, db DBTX, userID int, ip net.IP) error {
+	sql, args, _ := psql.
+		Update("users").
+		Set("signup_ip", ip.String()).
+		Where(sq.Eq{"id": userID}).
+		ToSql()
+	_, err := db.Exec(ctx, sql, args...)
+	return err
+}
+func (h UserH) ReadNsfwPref(ctx context.Context) (models.NsfwPref, error) {
+	if !h.perms.Read {
+		return "", models.ErrPermDenied
+	}
+	sql, args, _ := psql.
+		Select("nsfw_pref").
+		From("users").
+		Where(sq.Eq{"id": h.id}).
+		ToSql()
+
+	var pref models.NsfwPref
+	err := h.sharedDB.QueryRow(ctx, sql, args...).Scan(&pref)
+	return pref, err
+}
+
+// SetNsfwPref chooses whether NSFW content is hidden, blurred or shown
+func (h UserH) SetNsfwPref(ctx context.Context, pref models.NsfwPref) error {
+	if !h.perms.Read {
+		return models.ErrPermDenied
+	}
+	if !pref.Valid() {
+		return models.ErrInvalidNsfwPref
+	}
+	sql, args, _ := psql.
+		Update("users").
+		Set("nsfw_pref", pref).
+		Where(sq.Eq{"id": h.id}).
+		ToSql()
 	_, err := h.sharedDB.Exec(ctx, sql, args...)
 	return err
 }
-func listUserEssays(ctx context.Context, db DBTX, userID int) ([]models.EssayView, error) {
+func deleteUser(ctx context.Context, db DBTX, userID int, essays models.EssayDeletion) error {
+	if !essays.Valid() {
+		return models.ErrInvalidDeletion
+	}
+	return execTx(ctx, db, func(ctx context.Context, tx DBTX) error {
+		if essays == models.EssayDeletionPurge {
+			err := purgeUserEssays(ctx, tx, userID)
+			if err != nil {
+				return err
+			}
+		}
+		// The remaining essays lose their author
+		sql, args, _ := psql.Delete("users").Where(sq.Eq{"id": userID}).ToSql()
+		tag, err := tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return pgx.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// purgeUserEssays deletes the essays of the user
+func purgeUserEssays(ctx context.Context, tx DBTX, userID int) error {
+	sql, args, _ := psql.
+		Select("id").
+		From("essays").
+		Where(sq.Eq{"attributed_to_id": userID}).
+		ToSql()
+
+	var ids []int
+	err := pgxscan.Select(ctx, tx, &ids, sql, args...)
+	if err != nil {
+		return err
+	}
+	return purgeEssays(ctx, tx, ids, "Deleted along with the account of the author")
+}
+
+// purgeEssays deletes the essays. As in PurgeRemovedEssays,
+// essays which have replies are kept as empty placeholders.
+func purgeEssays(ctx context.Context, tx DBTX, ids []int, reason string) error {
+	sql, args, _ := psql.
+		Delete("essays").
+		Where(sq.Eq{"id": ids}).
+		Where("NOT EXISTS (SELECT 1 FROM essay_replies WHERE essay_replies.to_id = essays.id)").
+		ToSql()
+
+	_, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	sql, args, _ = psql.
+		Update("essays").
+		Set("thesis", "").
+		Set("content", "").
+		Set("removed_at", sq.Expr("COALESCE(removed_at, NOW())")).
+		Set("removal_reason", sq.Expr("COALESCE(removal_reason, ?)", reason)).
+		Set("purged_at", sq.Expr("NOW()")).
+		Where(sq.Eq{"id": ids}).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"essay_sources", "essay_tags"} {
+		sql, args, _ = psql.
+			Delete(table).
+			Where(sq.Eq{"essay_id": ids}).
+			ToSql()
+
+		_, err = tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteUser deletes the account of another user.
+// Users with a global role can be deleted only by who outranks them.
+func (h *DisceptoH) DeleteUser(ctx context.Context, userID int, essays models.EssayDeletion) error {
+	if err := h.globalPerms.Require(models.PermDeleteUser); err != nil {
+		return err
+	}
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		err := requireOutrank(ctx, tx, models.RoleDomainDiscepto, h.rank, userID)
+		if err != nil {
+			return err
+		}
+		user, err := readPublicUser(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		err = deleteUser(ctx, tx, userID, essays)
+		if err != nil {
+			return err
+		}
+		return h.audit.record(ctx, tx, models.AuditUserDelete, fmt.Sprintf("user %d", userID),
+			map[string]interface{}{"name": user.Name, "essays": essays}, nil)
+	})
+}
+func listUserEssays(ctx context.Context, db DBTX, query sq.SelectBuilder, userID int) ([]models.EssayView, error) {
 	essayPreviews := []models.EssayView{}
-	sql, args, _ := selectEssayWithJoins.
+	sql, args, _ := query.
 		Join("subdisceptos ON subdisceptos.name = essays.posted_in").
 		GroupBy("essays.id", "essay_replies.from_id", "users.name").
-		Where(sq.Eq{"subdisceptos.public": true, "users.id": userID}).
+		Where(sq.Eq{"subdisceptos.public": true, "users.id": userID, "essays.removed_at": nil, "essays.held_at": nil}).
 		OrderBy("essays.id DESC").
 		ToSql()
 
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+// Thresholds used when looking for suspicious voters
+const (
+	// Two accounts must have voted the same way on at least this many essays...
+	CoVotingMinShared = 5
+	// ...covering at least this fraction of the votes of the less active account
+	CoVotingMinRatio = 0.8
+	// Accounts younger than this are checked for bursts of votes
+	BurstMaxAccountAge = 7 * 24 * time.Hour
+	// Number of votes inside BurstWindow to consider it a burst
+	BurstMinVotes = 10
+	BurstWindow   = 1 * time.Hour
+)
+
+// Flags are upserted: a flag already resolved by a moderator isn't reopened
+const upsertVoteFlag = `
+INSERT INTO vote_flags (subdiscepto, flag_type, user_ids, details)
+%s
+ON CONFLICT (subdiscepto, flag_type, user_ids) DO UPDATE
+SET details = EXCLUDED.details
+WHERE vote_flags.resolved_at IS NULL`
+
+const selectCoVoting = `
+WITH sub_votes AS (
+	SELECT votes.user_id, votes.essay_id, votes.vote_type, essays.posted_in
+	FROM votes
+	JOIN essays ON essays.id = votes.essay_id
+	WHERE NOT votes.nullified
+), totals AS (
+	SELECT user_id, posted_in, COUNT(*) AS total
+	FROM sub_votes
+	GROUP BY user_id, posted_in
+)
+SELECT a.posted_in, 'co_voting'::vote_flag_type, ARRAY[a.user_id, b.user_id],
+	format('voted the same way on %s essays', COUNT(*))
+FROM sub_votes a
+JOIN sub_votes b ON a.essay_id = b.essay_id AND a.vote_type = b.vote_type AND a.user_id < b.user_id
+JOIN totals ta ON ta.user_id = a.user_id AND ta.posted_in = a.posted_in
+JOIN totals tb ON tb.user_id = b.user_id AND tb.posted_in = a.posted_in
+GROUP BY a.posted_in, a.user_id, b.user_id, ta.total, tb.total
+HAVING COUNT(*) >= $1 AND COUNT(*) >= $2 * LEAST(ta.total, tb.total)`
+
+const selectBurst = `
+SELECT essays.posted_in, 'burst'::vote_flag_type, ARRAY[users.id],
+	format('%s votes in a short time from an account created on %s', COUNT(*), users.created_at::date)
+FROM votes
+JOIN essays ON essays.id = votes.essay_id
+JOIN users ON users.id = votes.user_id
+WHERE users.created_at > $1 AND votes.created_at > $2 AND NOT votes.nullified
+GROUP BY essays.posted_in, users.id
+HAVING COUNT(*) >= $3`
+
+const selectSharedIP = `
+SELECT essays.posted_in, 'shared_ip'::vote_flag_type, array_agg(DISTINCT users.id ORDER BY users.id),
+	format('%s voting accounts signed up from the same address', COUNT(DISTINCT users.id))
+FROM users
+JOIN votes ON votes.user_id = users.id
+JOIN essays ON essays.id = votes.essay_id
+WHERE users.signup_ip IS NOT NULL AND NOT votes.nullified
+GROUP BY essays.posted_in, users.signup_ip
+HAVING COUNT(DISTINCT users.id) > 1`
+
+// DetectVoteRings analyzes the votes table, flagging suspicious groups of voters.
+// It's meant to be run periodically, in background.
+func (sdb SharedDB) DetectVoteRings(ctx context.Context) error {
+	now := time.Now()
+	queries := []struct {
+		sql  string
+		args []interface{}
+	}{
+		{selectCoVoting, []interface{}{CoVotingMinShared, CoVotingMinRatio}},
+		{selectBurst, []interface{}{now.Add(-BurstMaxAccountAge), now.Add(-BurstWindow), BurstMinVotes}},
+		{selectSharedIP, nil},
+	}
+	return execTx(ctx, sdb.db, func(ctx context.Context, tx DBTX) error {
+		for _, q := range queries {
+			_, err := tx.Exec(ctx, fmt.Sprintf(upsertVoteFlag, q.sql), q.args...)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (h *SubdisceptoH) ListVoteFlags(ctx context.Context) ([]models.VoteFlag, error) {
+	if err := h.subPerms.Require(models.PermReviewVotes); err != nil {
+		return nil, err
+	}
+	sql, args, _ := psql.
+		Select(
+			"id",
+			"subdiscepto",
+			"flag_type",
+			"user_ids",
+			"ARRAY(SELECT name FROM users WHERE users.id = ANY(vote_flags.user_ids) ORDER BY users.id) AS user_names",
+			"details",
+			"created_at",
+			"resolved_at",
+			"nullified",
+		).
+		From("vote_flags").
+		Where(sq.Eq{"subdiscepto": h.rawSub.Name}).
+		OrderBy("resolved_at DESC NULLS FIRST", "id DESC").
+		ToSql()
+
+	flags := []models.VoteFlag{}
+	err := pgxscan.Select(ctx, h.sharedDB, &flags, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+// ResolveVoteFlag closes a flag. When nullify is true, every vote cast
+// inside this subdiscepto by the flagged accounts stops being counted.
+func (h *SubdisceptoH) ResolveVoteFlag(ctx context.Context, flagID int, nullify bool) error {
+	if err := h.subPerms.Require(models.PermReviewVotes); err != nil {
+		return err
+	}
+	return execTx(ctx, h.sharedDB, func(ctx context.Context, tx DBTX) error {
+		sql, args, _ := psql.
+			Update("vote_flags").
+			Set("resolved_at", sq.Expr("NOW()")).
+			Set("nullified", nullify).
+			Where(sq.Eq{"id": flagID, "subdiscepto": h.rawSub.Name, "resolved_at": nil}).
+			Suffix("RETURNING user_ids").
+			ToSql()
+
+		var userIDs []int
+		err := tx.QueryRow(ctx, sql, args...).Scan(&userIDs)
+		if err != nil {
+			return err
+		}
+		err = h.audit.record(ctx, tx, models.AuditVoteFlagResolve, fmt.Sprintf("vote flag %d", flagID),
+			nil, map[string]interface{}{"user_ids": userIDs, "nullified": nullify})
+		if err != nil {
+			return err
+		}
+		if !nullify {
+			return nil
+		}
+
+		sql, args, _ = psql.
+			Update("votes").
+			Set("nullified", true).
+			Where(sq.Eq{"user_id": userIDs}).
+			Where("essay_id IN (SELECT id FROM essays WHERE posted_in = ?)", h.rawSub.Name).
+			ToSql()
+		_, err = tx.Exec(ctx, sql, args...)
+		return err
+	})
+}
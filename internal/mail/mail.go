@@ -0,0 +1,81 @@
+// Package mail offers the ways discepto can send emails
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gitlab.com/ranfdev/discepto/internal/models"
+	"gitlab.com/ranfdev/discepto/internal/utils"
+)
+
+// New returns a mailer using SMTP when configured, or writing to the spool directory otherwise
+func New(config *models.EnvConfig) models.Mailer {
+	if config.SMTPAddr == "" {
+		return &SpoolMailer{From: config.MailFrom, Dir: config.MailSpoolDir}
+	}
+	return &SMTPMailer{
+		From:     config.MailFrom,
+		Addr:     config.SMTPAddr,
+		User:     config.SMTPUser,
+		Password: config.SMTPPassword,
+	}
+}
+
+// format builds the message, with its headers, as expected by SMTP servers
+func format(from string, mail *models.Mail, date time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", mail.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(mail.Body)
+	return buf.Bytes()
+}
+
+type SMTPMailer struct {
+	From string
+	// host:port of the server
+	Addr     string
+	User     string
+	Password string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, mail *models.Mail) error {
+	var auth smtp.Auth
+	if m.User != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.User, m.Password, host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{mail.To}, format(m.From, mail, time.Now()))
+}
+
+// SpoolMailer writes every mail to a file of Dir, instead of sending it.
+// It's meant for development and tests.
+type SpoolMailer struct {
+	From string
+	Dir  string
+}
+
+func (m *SpoolMailer) Send(ctx context.Context, mail *models.Mail) error {
+	err := os.MkdirAll(m.Dir, 0700)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%d-%s.eml", now.UnixNano(), utils.GenToken(4))
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, mail, now), 0600)
+}
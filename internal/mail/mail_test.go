@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+func TestSpoolMailer(t *testing.T) {
+	require := require.New(t)
+	dir := filepath.Join(t.TempDir(), "spool")
+	mailer := New(&models.EnvConfig{MailFrom: "discepto@example.com", MailSpoolDir: dir})
+	require.IsType(&SpoolMailer{}, mailer)
+
+	err := mailer.Send(context.Background(), &models.Mail{
+		To:      "user@example.com",
+		Subject: "Reset your password",
+		Body:    "Open the link",
+	})
+	require.Nil(err)
+
+	files, err := os.ReadDir(dir)
+	require.Nil(err)
+	require.Len(files, 1)
+	content, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.Nil(err)
+	require.Contains(string(content), "To: user@example.com\r\n")
+	require.Contains(string(content), "Subject: Reset your password\r\n")
+	require.Contains(string(content), "\r\n\r\nOpen the link")
+}
+func TestNewSMTPMailer(t *testing.T) {
+	require := require.New(t)
+	mailer := New(&models.EnvConfig{SMTPAddr: "localhost:25"})
+	require.IsType(&SMTPMailer{}, mailer)
+}
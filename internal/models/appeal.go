@@ -0,0 +1,55 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidAppeal    = errors.New("invalid appeal")
+	ErrAppealExists     = errors.New("this action has already been appealed")
+	ErrAppealSelfReview = errors.New("moderators can't review appeals against their own actions")
+)
+
+type AppealOutcome string
+
+const (
+	// The sanction stays in place
+	AppealUpheld AppealOutcome = "upheld"
+	// The sanction is lifted
+	AppealOverturned AppealOutcome = "overturned"
+)
+
+func (o AppealOutcome) Valid() bool {
+	return o == AppealUpheld || o == AppealOverturned
+}
+
+type AppealReq struct {
+	Statement string
+}
+type AppealReview struct {
+	Outcome  AppealOutcome
+	Response string
+}
+
+type Appeal struct {
+	ID int
+	// Exactly one of BanID and EssayID is valid
+	BanID   sql.NullInt32
+	EssayID sql.NullInt32
+	// Null for appeals of global bans
+	Subdiscepto sql.NullString
+	UserID      int
+	UserName    string
+	ActedByName sql.NullString
+	// Reason of the sanction
+	Reason    string
+	Statement string
+	CreatedAt time.Time
+	// Null until a moderator reviews the appeal
+	ReviewedByName sql.NullString
+	ReviewedAt     sql.NullTime
+	Outcome        sql.NullString
+	Response       sql.NullString
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	AuditRoleAssign      AuditAction = "role_assign"
+	AuditRoleUnassign    AuditAction = "role_unassign"
+	AuditRoleUnassignAll AuditAction = "role_unassign_all"
+	AuditRoleCreate      AuditAction = "role_create"
+	AuditRoleUpdatePerms AuditAction = "role_update_perms"
+	AuditRoleDelete      AuditAction = "role_delete"
+	AuditRoleUpdateRank  AuditAction = "role_update_rank"
+	AuditRoleImport      AuditAction = "role_import"
+	AuditEssayDelete     AuditAction = "essay_delete"
+	AuditReportDelete    AuditAction = "report_delete"
+	AuditReportClaim     AuditAction = "report_claim"
+	AuditReportClose     AuditAction = "report_close"
+	AuditSubUpdate       AuditAction = "subdiscepto_update"
+	AuditSubDelete       AuditAction = "subdiscepto_delete"
+	AuditVoteFlagResolve AuditAction = "vote_flag_resolve"
+	AuditBadgeCreate     AuditAction = "badge_create"
+	AuditBadgeDelete     AuditAction = "badge_delete"
+	AuditBadgeAward      AuditAction = "badge_award"
+	AuditBadgeRevoke     AuditAction = "badge_revoke"
+	AuditUserBan         AuditAction = "user_ban"
+	AuditUserUnban       AuditAction = "user_unban"
+	AuditUserDelete      AuditAction = "user_delete"
+	AuditUserVerify      AuditAction = "user_verify"
+	AuditEssayRemove     AuditAction = "essay_remove"
+	AuditEssayRestore    AuditAction = "essay_restore"
+	AuditEssayApprove    AuditAction = "essay_approve"
+	AuditAutomodCreate   AuditAction = "automod_rule_create"
+	AuditAutomodDelete   AuditAction = "automod_rule_delete"
+	AuditAutomodToggle   AuditAction = "automod_rule_toggle"
+	AuditModmailArchive  AuditAction = "modmail_archive"
+	AuditAppealUphold    AuditAction = "appeal_uphold"
+	AuditAppealOverturn  AuditAction = "appeal_overturn"
+	AuditInviteCreate    AuditAction = "invite_create"
+	AuditInviteDelete    AuditAction = "invite_delete"
+	AuditJoinApprove     AuditAction = "join_request_approve"
+	AuditJoinDeny        AuditAction = "join_request_deny"
+)
+
+// An entry of the moderation audit log.
+// Before and After hold the JSON encoded state of the target.
+type AuditEntry struct {
+	ID        int
+	Domain    RoleDomain
+	ActorID   sql.NullInt32
+	ActorName sql.NullString
+	Action    AuditAction
+	Target    string
+	Before    json.RawMessage
+	After     json.RawMessage
+	CreatedAt time.Time
+}
@@ -0,0 +1,228 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var ErrInvalidAutomodRule = errors.New("invalid automod rule")
+
+// Returned when an AutoMod rule rejects a new essay
+type ErrAutomodRejected struct {
+	Rule string
+}
+
+func (e ErrAutomodRejected) Error() string {
+	return fmt.Sprintf("essay rejected by the rule %q", e.Rule)
+}
+
+type AutomodField string
+
+const (
+	AutomodFieldThesis     AutomodField = "thesis"
+	AutomodFieldContent    AutomodField = "content"
+	AutomodFieldTags       AutomodField = "tags"
+	AutomodFieldLinkDomain AutomodField = "link_domain"
+)
+
+type AutomodMatchType string
+
+const (
+	AutomodMatchKeyword AutomodMatchType = "keyword"
+	AutomodMatchRegex   AutomodMatchType = "regex"
+)
+
+type AutomodAction string
+
+const (
+	AutomodActionReject AutomodAction = "reject"
+	// Save the essay, but hide it until a moderator approves it
+	AutomodActionHold AutomodAction = "hold"
+	// File a report with the category in ActionArg
+	AutomodActionReport AutomodAction = "report"
+	// Add the tag in ActionArg
+	AutomodActionTag AutomodAction = "tag"
+)
+
+type AutomodRuleReq struct {
+	Name      string
+	Field     AutomodField
+	MatchType AutomodMatchType
+	Pattern   string
+	// 0 to apply the rule to every account
+	MaxAccountAgeDays int
+	Action            AutomodAction
+	ActionArg         string
+}
+
+func (r AutomodRuleReq) Validate() error {
+	if len(r.Name) == 0 || len(r.Name) > 50 || len(r.Pattern) == 0 || len(r.Pattern) > 255 {
+		return ErrInvalidAutomodRule
+	}
+	switch r.Field {
+	case AutomodFieldThesis, AutomodFieldContent, AutomodFieldTags, AutomodFieldLinkDomain:
+	default:
+		return ErrInvalidAutomodRule
+	}
+	switch r.MatchType {
+	case AutomodMatchKeyword:
+	case AutomodMatchRegex:
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return ErrInvalidAutomodRule
+		}
+	default:
+		return ErrInvalidAutomodRule
+	}
+	if r.MaxAccountAgeDays < 0 {
+		return ErrInvalidAutomodRule
+	}
+	switch r.Action {
+	case AutomodActionReject, AutomodActionHold:
+	case AutomodActionReport:
+		if !FlagType(r.ActionArg).Valid() {
+			return ErrInvalidAutomodRule
+		}
+	case AutomodActionTag:
+		if len(r.ActionArg) == 0 || len(r.ActionArg) > 15 {
+			return ErrInvalidAutomodRule
+		}
+	default:
+		return ErrInvalidAutomodRule
+	}
+	return nil
+}
+
+// Rule builds the rule described by this request, ready to be matched
+func (r AutomodRuleReq) Rule() AutomodRule {
+	rule := AutomodRule{
+		Name:              r.Name,
+		Field:             r.Field,
+		MatchType:         r.MatchType,
+		Pattern:           r.Pattern,
+		MaxAccountAgeDays: sql.NullInt32{Int32: int32(r.MaxAccountAgeDays), Valid: r.MaxAccountAgeDays > 0},
+		Action:            r.Action,
+		ActionArg:         sql.NullString{String: r.ActionArg, Valid: r.ActionArg != ""},
+		Enabled:           true,
+	}
+	rule.Compile()
+	return rule
+}
+
+type AutomodRule struct {
+	ID                int
+	Subdiscepto       string
+	Name              string
+	Field             AutomodField
+	MatchType         AutomodMatchType
+	Pattern           string
+	MaxAccountAgeDays sql.NullInt32
+	Action            AutomodAction
+	ActionArg         sql.NullString
+	Enabled           bool
+	CreatedAt         time.Time
+	// Set by Compile, for regex rules
+	regex *regexp.Regexp
+}
+
+// Compile prepares the regex of the rule, so that it isn't compiled again at every match
+func (r *AutomodRule) Compile() {
+	if r.MatchType == AutomodMatchRegex {
+		r.regex, _ = regexp.Compile(r.Pattern)
+	}
+}
+
+// The data of an essay checked by AutoMod rules
+type AutomodSubject struct {
+	ID               int
+	Thesis           string
+	Content          string
+	Tags             []string
+	Sources          []string
+	AttributedToName string
+	// Age of the author's account when the essay was posted
+	AccountAgeDays int
+}
+
+func NewAutomodSubject(essay *Essay, accountAgeDays int) AutomodSubject {
+	sources := make([]string, 0, len(essay.Sources))
+	for _, s := range essay.Sources {
+		sources = append(sources, s.String())
+	}
+	return AutomodSubject{
+		ID:             essay.ID,
+		Thesis:         essay.Thesis,
+		Content:        essay.Content,
+		Tags:           essay.Tags,
+		Sources:        sources,
+		AccountAgeDays: accountAgeDays,
+	}
+}
+
+// Domains of the sources and of the links found in the content
+func (s AutomodSubject) LinkDomains() []string {
+	links := append([]string{}, s.Sources...)
+	for _, l := range FindMDLinks(s.Content) {
+		links = append(links, l.URL)
+	}
+	domains := []string{}
+	for _, l := range links {
+		u, err := url.Parse(l)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		domains = append(domains, strings.ToLower(u.Hostname()))
+	}
+	return domains
+}
+
+func (r AutomodRule) Matches(s AutomodSubject) bool {
+	if r.MaxAccountAgeDays.Valid && s.AccountAgeDays >= int(r.MaxAccountAgeDays.Int32) {
+		return false
+	}
+
+	var match func(text string) bool
+	if r.MatchType == AutomodMatchRegex {
+		regex := r.regex
+		if regex == nil {
+			var err error
+			regex, err = regexp.Compile(r.Pattern)
+			if err != nil {
+				return false
+			}
+		}
+		match = regex.MatchString
+	} else {
+		keyword := strings.ToLower(r.Pattern)
+		match = func(text string) bool {
+			return strings.Contains(strings.ToLower(text), keyword)
+		}
+	}
+
+	switch r.Field {
+	case AutomodFieldThesis:
+		return match(s.Thesis)
+	case AutomodFieldContent:
+		return match(s.Content)
+	case AutomodFieldTags:
+		for _, t := range s.Tags {
+			if r.MatchType == AutomodMatchKeyword && strings.EqualFold(t, r.Pattern) ||
+				r.MatchType == AutomodMatchRegex && match(t) {
+				return true
+			}
+		}
+	case AutomodFieldLinkDomain:
+		for _, d := range s.LinkDomains() {
+			// A keyword matches the domain and its subdomains
+			if r.MatchType == AutomodMatchKeyword && (d == strings.ToLower(r.Pattern) || strings.HasSuffix(d, "."+strings.ToLower(r.Pattern))) ||
+				r.MatchType == AutomodMatchRegex && match(d) {
+				return true
+			}
+		}
+	}
+	return false
+}
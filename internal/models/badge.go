@@ -0,0 +1,44 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrInvalidBadge = errors.New("invalid badge")
+
+type BadgeRule string
+
+const (
+	// Replies that changed the view of the author of the parent essay
+	BadgeRuleAcceptedCorrection BadgeRule = "accepted_correction"
+	// Supporting replies received by the user's essays
+	BadgeRuleSupportsReceived BadgeRule = "supports_received"
+	// Days passed since the signup
+	BadgeRuleMembershipDays BadgeRule = "membership_days"
+)
+
+// A badge with a Rule is awarded automatically once the user reaches Threshold,
+// otherwise it's awarded by hand. Badges with a Subdiscepto belong to that community.
+type Badge struct {
+	ID          int
+	Name        string
+	Description string
+	Subdiscepto sql.NullString
+	Rule        sql.NullString
+	Threshold   int
+}
+type BadgeReq struct {
+	Name        string
+	Description string
+}
+type BadgeView struct {
+	Badge
+	HolderIDs   []int
+	HolderNames []string
+}
+type UserBadge struct {
+	Badge
+	AwardedAt time.Time
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrInvalidBan = errors.New("invalid ban")
+
+type Ban struct {
+	ID     int
+	Domain RoleDomain
+	// Null for global bans
+	Subdiscepto  sql.NullString
+	UserID       int
+	UserName     string
+	BannedByName sql.NullString
+	Reason       string
+	CreatedAt    time.Time
+	// Null for permanent bans
+	ExpiresAt sql.NullTime
+}
+type BanReq struct {
+	Reason string
+	// Duration of the ban in days, 0 for permanent bans
+	Days int
+}
@@ -13,6 +13,16 @@ type EnvConfig struct {
 	Port            string
 	SessionKey      []byte
 	Debug           bool
+	// Days a removed essay is kept before its content gets purged
+	RemovedRetentionDays int
+	// Used to build the links sent by email, e.g. "https://discepto.example.com"
+	BaseURL string
+	// Mails are sent with SMTP when SMTPAddr is set, otherwise they're written to MailSpoolDir
+	SMTPAddr     string
+	SMTPUser     string
+	SMTPPassword string
+	MailFrom     string
+	MailSpoolDir string
 }
 
 func ReadEnvConfig() EnvConfig {
@@ -27,12 +37,36 @@ func ReadEnvConfig() EnvConfig {
 		fmt.Println("Using default value for DISCEPTO_POSTS_PER_MINUTE")
 		postsPerMinute = 2
 	}
+	removedRetentionDays, err := strconv.Atoi(os.Getenv("DISCEPTO_REMOVED_RETENTION_DAYS"))
+	if err != nil {
+		fmt.Println("Using default value for DISCEPTO_REMOVED_RETENTION_DAYS")
+		removedRetentionDays = 30
+	}
+	baseURL := os.Getenv("DISCEPTO_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:" + port
+	}
+	mailFrom := os.Getenv("DISCEPTO_MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "discepto@localhost"
+	}
+	mailSpoolDir := os.Getenv("DISCEPTO_MAIL_SPOOL_DIR")
+	if mailSpoolDir == "" {
+		mailSpoolDir = "mail-spool"
+	}
 	return EnvConfig{
-		GoogleClientID:  os.Getenv("DISCEPTO_GOOGLE_CLIENT_ID"),
-		DatabaseURL:     os.Getenv("DISCEPTO_DATABASE_URL"),
-		PostsPerSeconds: postsPerMinute,
-		Port:            port,
-		SessionKey:      []byte(sessionKey),
-		Debug:           debug,
+		GoogleClientID:       os.Getenv("DISCEPTO_GOOGLE_CLIENT_ID"),
+		DatabaseURL:          os.Getenv("DISCEPTO_DATABASE_URL"),
+		PostsPerSeconds:      postsPerMinute,
+		Port:                 port,
+		SessionKey:           []byte(sessionKey),
+		Debug:                debug,
+		RemovedRetentionDays: removedRetentionDays,
+		BaseURL:              baseURL,
+		SMTPAddr:             os.Getenv("DISCEPTO_SMTP_ADDR"),
+		SMTPUser:             os.Getenv("DISCEPTO_SMTP_USER"),
+		SMTPPassword:         os.Getenv("DISCEPTO_SMTP_PASSWORD"),
+		MailFrom:             mailFrom,
+		MailSpoolDir:         mailSpoolDir,
 	}
 }
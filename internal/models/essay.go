@@ -11,6 +11,12 @@ import (
 var (
 	ErrTooManyTags   = errors.New("too many tags")
 	ErrBadContentLen = errors.New("bad content length")
+	// Only replies written by someone else can change the view of a user
+	ErrInvalidPersuasion = errors.New("can't mark this essay as persuasive")
+	// The removal reason doesn't exist in the subdiscepto of the essay
+	ErrInvalidRemovalReason = errors.New("invalid removal reason")
+	ErrNotRemoved           = errors.New("essay isn't removed, or its content has been purged")
+	ErrNotHeld              = errors.New("essay isn't held for review")
 )
 var (
 	ReplyTypeSupports = sql.NullString{String: "supports", Valid: true}
@@ -34,9 +40,12 @@ type Essay struct {
 	Published      time.Time
 	PostedIn       string
 	AttributedToID int `db:"attributed_to_id"`
-	Tags           []string
-	Sources        []url.URL
-	Questions      []Question
+	Nsfw           bool
+	// Only readable with the read_essay permission
+	MembersOnly bool
+	Tags        []string
+	Sources     []url.URL
+	Questions   []Question
 	Replying
 }
 
@@ -51,21 +60,69 @@ type EssayView struct {
 	AttributedToName string
 	Upvotes          int
 	Downvotes        int
-	Tags             []string
+	// Only present when listing essays of a subdiscepto using weighted votes
+	WeightedScore sql.NullFloat64
+	// Number of users who changed their view thanks to this essay
+	Persuasions int
+	// True if the author of the parent essay changed their view
+	PersuadedParentAuthor bool
+	// Set when a moderator removed the essay. Thesis and Content are then empty.
+	RemovalReason sql.NullString
+	// Set while the essay waits for the approval of a moderator
+	HeldReason sql.NullString
+	// True when the essay, or its subdiscepto, is marked as NSFW
+	Nsfw bool
+	// True when the essay is readable only with the read_essay permission
+	MembersOnly bool
+	Tags        []string
 	Replying
 }
 type EssayRow struct {
+	ID                    int
+	Thesis                string
+	Content               string
+	Published             time.Time
+	PostedIn              string
+	AttributedToID        int `db:"attributed_to_id"`
+	AttributedToName      string
+	Upvotes               int
+	Downvotes             int
+	Persuasions           int
+	PersuadedParentAuthor bool
+	RemovalReason         sql.NullString
+	HeldReason            sql.NullString
+	Nsfw                  bool
+	MembersOnly           bool
+	Tag                   string
+	Replying
+}
+
+// A removal reason template of a subdiscepto
+type RemovalReason struct {
+	ID          int
+	Subdiscepto string
+	Text        string
+}
+
+// An essay removed by a moderator, still available to be restored
+type RemovedEssay struct {
 	ID               int
 	Thesis           string
-	Content          string
-	Published        time.Time
-	PostedIn         string
 	AttributedToID   int `db:"attributed_to_id"`
 	AttributedToName string
-	Upvotes          int
-	Downvotes        int
-	Tag              string
-	Replying
+	RemovedAt        time.Time
+	RemovedByName    sql.NullString
+	RemovalReason    string
+}
+
+// An essay waiting for the approval of a moderator
+type HeldEssay struct {
+	ID               int
+	Thesis           string
+	AttributedToID   int `db:"attributed_to_id"`
+	AttributedToName string
+	HeldAt           time.Time
+	HeldReason       string
 }
 
 type Replying struct {
@@ -5,4 +5,7 @@ import "database/sql"
 type EssayUserDid struct {
 	Favourite bool
 	Vote      sql.NullString
+	// The user marked the essay as having changed their view
+	ChangedView bool
+	Reported    bool
 }
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidInvite = errors.New("invalid invite")
+	ErrInviteExpired = errors.New("the invite is expired or used up")
+)
+
+type InviteReq struct {
+	// Role assigned on top of the common one, empty for none
+	Role string
+	// Validity of the invite in days, 0 for invites that never expire
+	Days int
+	// 0 for unlimited uses
+	MaxUses int
+}
+
+// An invite link, or a direct invitation to a user
+type Invite struct {
+	ID          int
+	Subdiscepto string
+	// Null for direct invitations
+	Token           sql.NullString
+	InvitedUserID   sql.NullInt32
+	InvitedUserName sql.NullString
+	RoleName        sql.NullString
+	CreatedByName   sql.NullString
+	CreatedAt       time.Time
+	ExpiresAt       sql.NullTime
+	MaxUses         sql.NullInt32
+	Uses            int
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidJoinRequest = errors.New("invalid join request")
+	ErrJoinRequestExists  = errors.New("a request to join is already pending")
+)
+
+// A pending request to join a subdiscepto
+type JoinRequest struct {
+	ID          int
+	Subdiscepto string
+	UserID      int
+	UserName    string
+	Message     string
+	CreatedAt   time.Time
+}
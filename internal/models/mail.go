@@ -0,0 +1,14 @@
+package models
+
+import "context"
+
+// A plain text email
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, mail *Mail) error
+}
@@ -121,6 +121,65 @@ func TestSubset(t *testing.T) {
 		require.Equal(r.IsSubset, ok, fmt.Sprintf("p1=%v, p2=%v", p1, p2))
 	}
 }
+func TestDeny(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	table := []struct {
+		P1      Perms
+		P2      Perms
+		Granted bool
+	}{
+		{
+			NewPerms(PermCreateEssay),
+			NewPerms(PermCreateEssay),
+			true,
+		},
+		{
+			NewPerms(PermCreateEssay),
+			NewPerms().Deny(PermCreateEssay),
+			false,
+		},
+		{
+			NewPerms().Deny(PermCreateEssay),
+			NewPerms(PermCreateEssay),
+			false,
+		},
+		{
+			NewPerms(PermCreateEssay).Deny(PermCreateEssay),
+			NewPerms(),
+			false,
+		},
+		{
+			NewPerms().Deny(PermCreateEssay),
+			NewPerms().Deny(PermCreateEssay),
+			false,
+		},
+		{
+			NewPerms(PermCreateEssay),
+			NewPerms().Deny(PermCreateVote),
+			true,
+		},
+	}
+	for _, r := range table {
+		ps := r.P1.Union(r.P2)
+		require.Equal(r.Granted, ps.Check(PermCreateEssay), fmt.Sprintf("p1=%v, p2=%v", r.P1, r.P2))
+		require.Equal(!r.Granted, ps.Require(PermCreateEssay) != nil)
+	}
+
+	ps := NewPerms(PermCreateEssay, PermCreateVote).Deny(PermCreateVote)
+	require.Equal([]Perm{PermCreateEssay}, ps.List())
+	require.Equal([]Perm{PermCreateVote}, ps.Denied())
+	require.True(ps.Denies(PermCreateVote))
+	require.False(ps.Denies(PermCreateEssay))
+	// Managing a role denying a permission requires the permission
+	require.NotNil(NewPerms(PermCreateEssay).RequirePerms(ps))
+	require.Nil(NewPerms(PermCreateEssay, PermCreateVote).RequirePerms(ps))
+
+	// A grant isn't a subset of a deny, nor the reverse
+	require.False(NewPerms(PermCreateVote).SubsetOf(ps))
+	require.False(NewPerms().Deny(PermCreateEssay).SubsetOf(ps))
+	require.True(NewPerms().Deny(PermCreateVote).SubsetOf(ps))
+}
 func TestMDLinks(t *testing.T) {
 	require := require.New(t)
 	tests := []struct {
@@ -146,3 +205,37 @@ func TestMDLinks(t *testing.T) {
 		require.Equal(links, t.res)
 	}
 }
+func TestAutomodMatches(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	subject := AutomodSubject{
+		Thesis:         "Buy cheap pills",
+		Content:        "Visit [my shop](https://shop.example.com) now",
+		Tags:           []string{"Health"},
+		AccountAgeDays: 2,
+	}
+	tests := []struct {
+		rule  AutomodRuleReq
+		match bool
+	}{
+		{AutomodRuleReq{Field: AutomodFieldThesis, MatchType: AutomodMatchKeyword, Pattern: "CHEAP"}, true},
+		{AutomodRuleReq{Field: AutomodFieldContent, MatchType: AutomodMatchKeyword, Pattern: "cheap"}, false},
+		{AutomodRuleReq{Field: AutomodFieldThesis, MatchType: AutomodMatchRegex, Pattern: `^Buy \w+`}, true},
+		{AutomodRuleReq{Field: AutomodFieldTags, MatchType: AutomodMatchKeyword, Pattern: "health"}, true},
+		{AutomodRuleReq{Field: AutomodFieldTags, MatchType: AutomodMatchKeyword, Pattern: "heal"}, false},
+		{AutomodRuleReq{Field: AutomodFieldLinkDomain, MatchType: AutomodMatchKeyword, Pattern: "example.com"}, true},
+		{AutomodRuleReq{Field: AutomodFieldLinkDomain, MatchType: AutomodMatchKeyword, Pattern: "ample.com"}, false},
+		{AutomodRuleReq{Field: AutomodFieldThesis, MatchType: AutomodMatchKeyword, Pattern: "pills", MaxAccountAgeDays: 3}, true},
+		{AutomodRuleReq{Field: AutomodFieldThesis, MatchType: AutomodMatchKeyword, Pattern: "pills", MaxAccountAgeDays: 2}, false},
+	}
+	for _, test := range tests {
+		require.Equal(test.match, test.rule.Rule().Matches(subject), fmt.Sprintf("rule=%+v", test.rule))
+	}
+
+	require.Equal(ErrInvalidAutomodRule, AutomodRuleReq{
+		Name: "bad", Field: AutomodFieldThesis, MatchType: AutomodMatchRegex, Pattern: "(", Action: AutomodActionHold,
+	}.Validate())
+	require.Equal(ErrInvalidAutomodRule, AutomodRuleReq{
+		Name: "bad", Field: AutomodFieldThesis, MatchType: AutomodMatchKeyword, Pattern: "x", Action: AutomodActionReport, ActionArg: "nope",
+	}.Validate())
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrInvalidModmail = errors.New("invalid modmail message")
+
+type ModmailReq struct {
+	Subject string
+	Text    string
+}
+
+type ModmailThread struct {
+	ID          int
+	Subdiscepto string
+	UserID      int
+	UserName    string
+	Subject     string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	ArchivedAt  sql.NullTime
+	// True if there are messages the viewer hasn't read yet
+	Unread bool
+}
+
+type ModmailMessage struct {
+	ID       int
+	FromMods bool
+	// Hidden to the user when the message comes from the moderators
+	AuthorName sql.NullString
+	Text       string
+	CreatedAt  time.Time
+}
+
+type ModmailThreadView struct {
+	ModmailThread
+	Messages []ModmailMessage
+}
@@ -8,8 +8,14 @@ import (
 type NotifType string
 
 const (
-	NotifTypeReply  = "reply"
-	NotifTypeUpvote = "upvote"
+	NotifTypeReply      = "reply"
+	NotifTypeUpvote     = "upvote"
+	NotifTypePersuasion = "persuasion"
+	NotifTypeModmail    = "modmail"
+	NotifTypeAppeal     = "appeal"
+	NotifTypeModeration = "moderation"
+	NotifTypeInvite     = "invite"
+	NotifTypeJoin       = "join_request"
 )
 
 type Notification struct {
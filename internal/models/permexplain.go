@@ -0,0 +1,42 @@
+package models
+
+type PermSource string
+
+const (
+	PermSourceGlobalRole PermSource = "global role"
+	PermSourceLocalRole  PermSource = "local role"
+	PermSourcePublic     PermSource = "public subdiscepto"
+	PermSourceOwner      PermSource = "essay author"
+	PermSourceGlobalDeny PermSource = "global role denying it"
+	PermSourceLocalDeny  PermSource = "local role denying it"
+	PermSourceGlobalBan  PermSource = "global ban"
+	PermSourceLocalBan   PermSource = "local ban"
+	PermSourceUnverified PermSource = "unverified email"
+	// Local roles count only with the use_local_permissions global permission
+	PermSourceNoLocal PermSource = "local permissions disabled"
+	// Without read access, nothing is granted inside the subdiscepto
+	PermSourceNoRead PermSource = "no read access"
+	// Held and members-only essays are hidden, as if they didn't exist
+	PermSourceHeld        PermSource = "essay held for review"
+	PermSourceMembersOnly PermSource = "members-only essay"
+)
+
+type PermReason struct {
+	Source PermSource
+	Detail string
+}
+
+// PermExplanation tells why a user has, or hasn't, a permission
+type PermExplanation struct {
+	UserID      int
+	Subdiscepto string
+	EssayID     int
+	Perm        Perm
+	Granted     bool
+	// What grants the permission
+	Grants []PermReason
+	// What takes away the permissions, even if granted
+	Blocks []PermReason
+	// Roles which would grant the permission, when missing
+	Missing []PermReason
+}
@@ -8,16 +8,28 @@ import (
 var ErrPermDenied = errors.New("missing permissions to execute action")
 
 type Perm string
-type Perms map[Perm]struct{}
+
+// Perms maps each permission to true when granted, or false when explicitly denied.
+// A denied permission is never granted, whatever else grants it.
+type Perms map[Perm]bool
 
 func NewPerms(perms ...Perm) Perms {
 	ps := Perms{}
 	for _, p := range perms {
-		ps[p] = struct{}{}
+		ps[p] = true
 	}
 	return ps
 }
 
+// Deny returns a copy of the permissions, denying perms too
+func (ps Perms) Deny(perms ...Perm) Perms {
+	res := ps.Union(Perms{})
+	for _, p := range perms {
+		res[p] = false
+	}
+	return res
+}
+
 const (
 	PermCreateSubdiscepto   Perm = "create_subdiscepto"
 	PermReadSubdiscepto     Perm = "read_subdiscepto"
@@ -39,13 +51,21 @@ const (
 	PermBanUser             Perm = "ban_user"
 	PermCreateVote          Perm = "create_vote"
 	PermDeleteVote          Perm = "delete_vote"
+	PermReviewVotes         Perm = "review_votes"
+	PermManageBadges        Perm = "manage_badges"
+	PermViewAuditLog        Perm = "view_audit_log"
+	PermRemoveEssay         Perm = "remove_essay"
+	PermManageAutomod       Perm = "manage_automod"
+	PermManageModmail       Perm = "manage_modmail"
+	PermManageInvites       Perm = "manage_invites"
+	PermManageMembers       Perm = "manage_members"
 )
 
 var PermsSubAdmin = NewPerms(
 	PermReadSubdiscepto,
+	PermReadEssay,
 	PermUpdateSubdiscepto,
 	PermCreateEssay,
-	PermDeleteEssay,
 	PermBanUser,
 	PermChangeRanking,
 	PermDeleteSubdiscepto,
@@ -54,14 +74,22 @@ var PermsSubAdmin = NewPerms(
 	PermCreateReport,
 	PermViewReport,
 	PermDeleteReport,
+	PermReviewVotes,
+	PermManageBadges,
+	PermViewAuditLog,
+	PermRemoveEssay,
+	PermManageAutomod,
+	PermManageModmail,
+	PermManageInvites,
+	PermManageMembers,
 )
 
 var PermsGlobalAdmin = NewPerms(
 	PermReadSubdiscepto,
+	PermReadEssay,
 	PermCreateSubdiscepto,
 	PermUpdateSubdiscepto,
 	PermCreateEssay,
-	PermDeleteEssay,
 	PermBanUser,
 	PermBanUserGlobally,
 	PermChangeRanking,
@@ -78,6 +106,14 @@ var PermsGlobalAdmin = NewPerms(
 	PermUseLocalPermissions,
 	PermCreateVote,
 	PermDeleteVote,
+	PermReviewVotes,
+	PermManageBadges,
+	PermViewAuditLog,
+	PermRemoveEssay,
+	PermManageAutomod,
+	PermManageModmail,
+	PermManageInvites,
+	PermManageMembers,
 )
 
 var PermsGlobalCommon = NewPerms(
@@ -86,8 +122,17 @@ var PermsGlobalCommon = NewPerms(
 	PermDeleteVote,
 )
 
+// Denied everywhere to users who haven't verified their email yet
+var PermsDeniedUnverified = []Perm{
+	PermCreateEssay,
+	PermCreateVote,
+	PermCreateReport,
+	PermCreateSubdiscepto,
+}
+
 var PermsSubCommon = NewPerms(
 	PermReadSubdiscepto,
+	PermReadEssay,
 	PermCreateEssay,
 	PermCommonAfterRejoin,
 	PermCreateReport,
@@ -104,7 +149,7 @@ func (mp ErrMissingPerms) Error() string {
 func (ps Perms) Require(reqPerms ...Perm) error {
 	missing := []Perm{}
 	for _, p := range reqPerms {
-		if _, ok := ps[p]; !ok {
+		if !ps[p] {
 			missing = append(missing, p)
 			return ErrMissingPerms{missing}
 		}
@@ -112,10 +157,12 @@ func (ps Perms) Require(reqPerms ...Perm) error {
 	return nil
 }
 
+// RequirePerms checks every permission of reqPerms is granted,
+// including the ones reqPerms denies
 func (ps Perms) RequirePerms(reqPerms Perms) error {
 	missing := []Perm{}
 	for p := range reqPerms {
-		if _, ok := ps[p]; !ok {
+		if !ps[p] {
 			missing = append(missing, p)
 			return ErrMissingPerms{missing}
 		}
@@ -127,38 +174,64 @@ func (ps Perms) Check(reqPerms ...Perm) bool {
 	return ps.Require(reqPerms...) == nil
 }
 
+// Denies tells whether the permission is explicitly denied
+func (ps Perms) Denies(p Perm) bool {
+	granted, ok := ps[p]
+	return ok && !granted
+}
+
+// List lists the granted permissions
 func (ps Perms) List() []Perm {
 	perms := []Perm{}
-	for k := range ps {
-		perms = append(perms, k)
+	for k, granted := range ps {
+		if granted {
+			perms = append(perms, k)
+		}
+	}
+	return perms
+}
+
+// Denied lists the denied permissions
+func (ps Perms) Denied() []Perm {
+	perms := []Perm{}
+	for k, granted := range ps {
+		if !granted {
+			perms = append(perms, k)
+		}
 	}
 	return perms
 }
 
+// SubsetOf tells whether ps2 grants and denies everything ps does
 func (ps Perms) SubsetOf(ps2 Perms) bool {
-	for p := range ps {
-		if _, ok := ps2[p]; !ok {
+	for p, granted := range ps {
+		if granted2, ok := ps2[p]; !ok || granted2 != granted {
 			return false
 		}
 	}
 	return true
 }
+
+// Union sums the permissions. A deny in either wins over a grant.
 func (ps Perms) Union(ps2 Perms) Perms {
-	allPerms := []Perm{}
-	for p := range ps {
-		allPerms = append(allPerms, p)
+	res := Perms{}
+	for p, granted := range ps {
+		res[p] = granted
 	}
-	for p := range ps2 {
-		allPerms = append(allPerms, p)
+	for p, granted := range ps2 {
+		if prev, ok := res[p]; ok {
+			granted = granted && prev
+		}
+		res[p] = granted
 	}
-	return NewPerms(allPerms...)
+	return res
 }
 
 func (ps Perms) Intersect(ps2 Perms) Perms {
 	res := Perms{}
-	for p := range ps {
-		if _, ok := ps2[p]; ok {
-			res[p] = struct{}{}
+	for p, granted := range ps {
+		if granted2, ok := ps2[p]; ok {
+			res[p] = granted && granted2
 		}
 	}
 	return res
@@ -1,14 +1,87 @@
 package models
 
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrInvalidReport = errors.New("invalid report")
+
+type FlagType string
+
+const (
+	FlagTypeOffensive  FlagType = "offensive"
+	FlagTypeFake       FlagType = "fake"
+	FlagTypeSpam       FlagType = "spam"
+	FlagTypeInaccurate FlagType = "inaccurate"
+)
+
+var FlagTypes = []FlagType{
+	FlagTypeOffensive,
+	FlagTypeFake,
+	FlagTypeSpam,
+	FlagTypeInaccurate,
+}
+
+func (f FlagType) Valid() bool {
+	for _, ft := range FlagTypes {
+		if f == ft {
+			return true
+		}
+	}
+	return false
+}
+
+// A report is open until a moderator claims it,
+// then it's closed as resolved or dismissed
+type ReportState string
+
+const (
+	ReportStateOpen      ReportState = "open"
+	ReportStateClaimed   ReportState = "claimed"
+	ReportStateResolved  ReportState = "resolved"
+	ReportStateDismissed ReportState = "dismissed"
+)
+
+var ReportStates = []ReportState{
+	ReportStateOpen,
+	ReportStateClaimed,
+	ReportStateResolved,
+	ReportStateDismissed,
+}
+
+func (s ReportState) Valid() bool {
+	for _, rs := range ReportStates {
+		if s == rs {
+			return true
+		}
+	}
+	return false
+}
+
 type Report struct {
 	ID          int
 	Description string
+	FlagType    FlagType
 	EssayID     int `db:"essay_id"`
 	FromUserID  int `db:"from_user_id"`
 	Essay
 }
 type ReportView struct {
-	ID          int
-	Description string
-	EssayView   EssayView
+	ID            int
+	Description   string
+	FlagType      FlagType
+	State         ReportState
+	CreatedAt     time.Time
+	ModeratorName sql.NullString
+	Resolution    sql.NullString
+	ClosedAt      sql.NullTime
+	EssayView     EssayView
+}
+
+// Empty fields match any report
+type ReportFilter struct {
+	State    ReportState
+	FlagType FlagType
 }
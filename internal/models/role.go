@@ -1,6 +1,9 @@
 package models
 
-import "errors"
+import (
+	"database/sql"
+	"errors"
+)
 
 type PermissionType string
 
@@ -8,9 +11,17 @@ const RoleDefault = 0
 const RoleAdmin = -123
 const SubRoleAdminPreset = -123
 
+// Ranks of the roles. A user can act only on users ranking lower.
+const RoleRankAdmin = 100
+const RoleRankDefault = 1
+
 type RoleDomain int
 
-var ErrRolePreset = errors.New("can't edit preset role")
+var (
+	ErrRolePreset    = errors.New("can't edit preset role")
+	ErrInvalidExpiry = errors.New("invalid role expiry")
+	ErrRoleRank      = errors.New("can't act on users or roles ranking at or above yours")
+)
 
 const RoleDomainDiscepto = RoleDomain(-123)
 
@@ -19,4 +30,9 @@ type Role struct {
 	Name   string
 	Preset bool
 	Domain RoleDomain
+	// Higher ranks can act on lower ones
+	Rank int
+	// When the assignment expires, if the role was listed for a user.
+	// Null for permanent assignments.
+	ExpiresAt sql.NullTime
 }
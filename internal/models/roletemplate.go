@@ -0,0 +1,68 @@
+package models
+
+import "errors"
+
+var ErrInvalidRoleTemplate = errors.New("invalid role template")
+
+// RoleTemplate describes the roles of a subdiscepto and their permissions,
+// to be exported and imported into other subdisceptos
+type RoleTemplate struct {
+	Roles []RoleTemplateEntry `json:"roles" yaml:"roles"`
+}
+type RoleTemplateEntry struct {
+	Name  string `json:"name" yaml:"name"`
+	Rank  int    `json:"rank" yaml:"rank"`
+	Perms []Perm `json:"perms" yaml:"perms"`
+	// Permissions taken away from the members with the role
+	Deny []Perm `json:"deny,omitempty" yaml:"deny,omitempty"`
+}
+
+// PermSet returns the granted and denied permissions of the role
+func (r RoleTemplateEntry) PermSet() Perms {
+	return NewPerms(r.Perms...).Deny(r.Deny...)
+}
+
+// Validate checks the template grants or denies only available permissions
+func (t RoleTemplate) Validate(available Perms) error {
+	names := map[string]bool{}
+	for _, r := range t.Roles {
+		if r.Name == "" || len(r.Name) > 100 || names[r.Name] || r.Rank < 0 {
+			return ErrInvalidRoleTemplate
+		}
+		names[r.Name] = true
+		perms := r.PermSet()
+		// Only available permissions can be denied, and not while granted
+		if !NewPerms(r.Perms...).SubsetOf(available) || !NewPerms(r.Deny...).SubsetOf(available) ||
+			len(perms) != len(r.Perms)+len(r.Deny) {
+			return ErrInvalidRoleTemplate
+		}
+	}
+	return nil
+}
+
+// Roles created along with a subdiscepto. They must all define
+// the common, common-after-rejoin and admin roles.
+const RolePresetDefault = "default"
+
+var RoleTemplatePresets = map[string]RoleTemplate{
+	RolePresetDefault: {Roles: []RoleTemplateEntry{
+		{Name: "common", Rank: 0, Perms: PermsSubCommon.List()},
+		{Name: "common-after-rejoin", Rank: 0, Perms: []Perm{PermCommonAfterRejoin}},
+		{Name: "admin", Rank: RoleRankAdmin, Perms: PermsSubAdmin.List()},
+	}},
+	// With a moderator role, to share the work without handing out admin rights
+	"moderated": {Roles: []RoleTemplateEntry{
+		{Name: "common", Rank: 0, Perms: PermsSubCommon.List()},
+		{Name: "common-after-rejoin", Rank: 0, Perms: []Perm{PermCommonAfterRejoin}},
+		{Name: "moderator", Rank: RoleRankDefault, Perms: []Perm{
+			PermRemoveEssay,
+			PermBanUser,
+			PermViewReport,
+			PermDeleteReport,
+			PermReviewVotes,
+			PermManageModmail,
+			PermViewAuditLog,
+		}},
+		{Name: "admin", Rank: RoleRankAdmin, Perms: PermsSubAdmin.List()},
+	}},
+}
@@ -1,5 +1,23 @@
 package models
 
+import "errors"
+
+var ErrJoinClosed = errors.New("this subdiscepto can't be joined freely")
+
+// How users become members of a subdiscepto
+type JoinMode string
+
+const (
+	JoinOpen JoinMode = "open"
+	// Moderators approve or deny the requests to join
+	JoinByRequest JoinMode = "request"
+	JoinByInvite  JoinMode = "invite"
+)
+
+func (m JoinMode) Valid() bool {
+	return m == JoinOpen || m == JoinByRequest || m == JoinByInvite
+}
+
 type SubdisceptoReq struct {
 	Name              string
 	Description       string
@@ -7,6 +25,13 @@ type SubdisceptoReq struct {
 	QuestionsRequired bool
 	Public            bool
 	Nsfw              bool
+	WeightedVotes     bool
+	PremodMinDays     int
+	PremodMinKarma    int
+	// Empty for JoinOpen
+	JoinMode JoinMode
+	// One of RoleTemplatePresets, used only on creation. Empty for RolePresetDefault.
+	RolePreset string
 }
 type Subdiscepto struct {
 	Name              string
@@ -16,11 +41,21 @@ type Subdiscepto struct {
 	QuestionsRequired bool
 	Nsfw              bool
 	Public            bool
+	// When true, essays are ranked by votes weighted on the voter's local reputation
+	WeightedVotes bool
+	// Essays from accounts younger than PremodMinDays, or with less karma than
+	// PremodMinKarma in this subdiscepto, are held until a moderator approves them.
+	// 0 disables each check.
+	PremodMinDays  int
+	PremodMinKarma int
+	JoinMode       JoinMode
 }
 
 type SubdisceptoView struct {
 	Name         string
 	Description  string
+	Nsfw         bool
 	MembersCount int
 	IsMember     bool
+	JoinMode     JoinMode
 }
@@ -10,8 +10,49 @@ var (
 	ErrEmailAlreadyUsed = errors.New("email already used")
 	ErrInvalidFormat    = errors.New("invalid email format")
 	ErrWeakPasswd       = errors.New("weak password")
+	ErrInvalidNsfwPref  = errors.New("invalid NSFW preference")
+	ErrInvalidDeletion  = errors.New("invalid deletion mode")
+	// The password reset link is wrong, expired or already used
+	ErrInvalidResetToken = errors.New("invalid password reset token")
+	ErrTooManyResets     = errors.New("too many password reset requests")
+	// The verification link is wrong, expired or already used
+	ErrInvalidVerifyToken = errors.New("invalid email verification token")
+	ErrTooManyVerifyMails = errors.New("too many verification emails")
+	ErrAlreadyVerified    = errors.New("email already verified")
 )
 
+// Shown in place of the name of the author, once their account is deleted
+const DeletedUserName = "[deleted]"
+
+// What happens to the essays of a deleted account
+type EssayDeletion string
+
+const (
+	// Essays stay, attributed to DeletedUserName
+	EssayDeletionAnonymize EssayDeletion = "anonymize"
+	// Essays are deleted. Those with replies are kept as empty placeholders,
+	// to keep the threads together.
+	EssayDeletionPurge EssayDeletion = "purge"
+)
+
+func (d EssayDeletion) Valid() bool {
+	return d == EssayDeletionAnonymize || d == EssayDeletionPurge
+}
+
+// How a user wants NSFW content to be shown
+type NsfwPref string
+
+const (
+	// NSFW subdisceptos and essays are left out of listings, search and the home feed
+	NsfwHide NsfwPref = "hide"
+	NsfwBlur NsfwPref = "blur"
+	NsfwShow NsfwPref = "show"
+)
+
+func (p NsfwPref) Valid() bool {
+	return p == NsfwHide || p == NsfwBlur || p == NsfwShow
+}
+
 type User struct {
 	ID    int
 	Name  string
@@ -23,6 +64,9 @@ type UserView struct {
 	User
 	Karma     int
 	CreatedAt time.Time
+	// Number of times the user's essays changed someone's view
+	Persuasions int
+	Badges      []UserBadge
 }
 
 type Member struct {
@@ -30,4 +74,6 @@ type Member struct {
 	Name   string
 	Roles  []Role
 	LeftAt sql.NullTime
+	// Set only when listing the users of the whole site
+	EmailVerified sql.NullBool
 }
@@ -1,5 +1,13 @@
 package models
 
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrVoteNullified = errors.New("the vote has been nullified and can't be changed")
+
 type VoteType string
 
 const (
@@ -12,3 +20,27 @@ type Vote struct {
 	EssayID  int `db:"essay_id"`
 	VoteType VoteType
 }
+
+type VoteFlagType string
+
+const (
+	// Accounts voting the same way on the same essays unusually often
+	VoteFlagCoVoting VoteFlagType = "co_voting"
+	// New accounts casting a lot of votes in a short time
+	VoteFlagBurst VoteFlagType = "burst"
+	// Voting accounts signed up from the same IP address
+	VoteFlagSharedIP VoteFlagType = "shared_ip"
+)
+
+// A suspicious group of voters inside a subdiscepto
+type VoteFlag struct {
+	ID          int
+	Subdiscepto string
+	FlagType    VoteFlagType
+	UserIDs     []int
+	UserNames   []string
+	Details     string
+	CreatedAt   time.Time
+	ResolvedAt  sql.NullTime
+	Nullified   bool
+}
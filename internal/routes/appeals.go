@@ -0,0 +1,123 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"gitlab.com/ranfdev/discepto/internal/models"
+	"gitlab.com/ranfdev/discepto/internal/utils"
+)
+
+// User side
+func (routes *Routes) UserAppealsRouter(r chi.Router) {
+	r.Get("/", routes.GetUserAppeals)
+	r.Post("/ban/{banID}", routes.PostBanAppeal)
+	r.Post("/essay/{essayID}", routes.PostRemovalAppeal)
+}
+func (routes *Routes) GetUserAppeals(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	userH := GetUserH(r)
+	appeals, err := disceptoH.ListUserAppeals(r.Context(), userH)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.tmpls.RenderHTML(w, "userAppeals", appeals)
+}
+func (routes *Routes) PostBanAppeal(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	userH := GetUserH(r)
+	banID, err := strconv.Atoi(chi.URLParam(r, "banID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	req := models.AppealReq{}
+	err = utils.ParseFormStruct(r, &req)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = disceptoH.AppealBan(r.Context(), userH, banID, req)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/u/appeals", http.StatusSeeOther)
+}
+func (routes *Routes) PostRemovalAppeal(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	userH := GetUserH(r)
+	essayID, err := strconv.Atoi(chi.URLParam(r, "essayID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	req := models.AppealReq{}
+	err = utils.ParseFormStruct(r, &req)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = disceptoH.AppealRemoval(r.Context(), userH, essayID, req)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/u/appeals", http.StatusSeeOther)
+}
+
+// Moderators side
+type AppealReviewer interface {
+	ListAppeals(ctx context.Context) ([]models.Appeal, error)
+	ReviewAppeal(ctx context.Context, appealID int, review models.AppealReview) error
+}
+type AppealReviewerExtract = func(r *http.Request) AppealReviewer
+
+func (routes *Routes) GlobalAppealsRouter(r chi.Router) {
+	routes.appealsRouter(r, func(r *http.Request) AppealReviewer {
+		return GetDisceptoH(r)
+	})
+}
+func (routes *Routes) SubAppealsRouter(r chi.Router) {
+	routes.appealsRouter(r, func(r *http.Request) AppealReviewer {
+		return GetSubdisceptoH(r)
+	})
+}
+func (routes *Routes) appealsRouter(r chi.Router, extract AppealReviewerExtract) {
+	r.Get("/", routes.getAppeals(extract))
+	r.Post("/{appealID}", routes.postAppealReview(extract))
+}
+func (routes *Routes) getAppeals(extract AppealReviewerExtract) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		appeals, err := extract(r).ListAppeals(r.Context())
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		routes.tmpls.RenderHTML(w, "appeals", appeals)
+	}
+}
+func (routes *Routes) postAppealReview(extract AppealReviewerExtract) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		appealID, err := strconv.Atoi(chi.URLParam(r, "appealID"))
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		review := models.AppealReview{}
+		err = utils.ParseFormStruct(r, &review)
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		err = extract(r).ReviewAppeal(r.Context(), appealID, review)
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		routes.getAppeals(extract)(w, r)
+	}
+}
@@ -0,0 +1,61 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+type AuditLogReader interface {
+	ListAuditLog(ctx context.Context) ([]models.AuditEntry, error)
+}
+type AuditLogExtract = func(r *http.Request) AuditLogReader
+
+func (routes *Routes) GlobalAuditLogRouter(r chi.Router) {
+	routes.auditLogRouter(r, func(r *http.Request) AuditLogReader {
+		return GetDisceptoH(r)
+	})
+}
+func (routes *Routes) SubAuditLogRouter(r chi.Router) {
+	routes.auditLogRouter(r, func(r *http.Request) AuditLogReader {
+		return GetSubdisceptoH(r)
+	})
+}
+func (routes *Routes) auditLogRouter(r chi.Router, extract AuditLogExtract) {
+	r.Get("/", routes.getAuditLog(extract))
+	r.Get("/export", routes.exportAuditLog(extract))
+}
+func (routes *Routes) getAuditLog(extract AuditLogExtract) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := extract(r).ListAuditLog(r.Context())
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		routes.tmpls.RenderHTML(w, "auditlog", struct {
+			Entries []models.AuditEntry
+		}{entries})
+	}
+}
+
+// exportAuditLog writes the audit log as JSON lines, one entry per line
+func (routes *Routes) exportAuditLog(extract AuditLogExtract) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := extract(r).ListAuditLog(r.Context())
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="auditlog.jsonl"`)
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+		}
+	}
+}
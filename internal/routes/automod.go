@@ -0,0 +1,112 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"gitlab.com/ranfdev/discepto/internal/models"
+	"gitlab.com/ranfdev/discepto/internal/utils"
+)
+
+type automodPageData struct {
+	Subdiscepto string
+	Rules       []models.AutomodRule
+	FlagTypes   []models.FlagType
+	// Only present after a dry run
+	DryRun        *models.AutomodRuleReq
+	DryRunMatches []models.AutomodSubject
+}
+
+func (routes *Routes) SubAutomodRouter(r chi.Router) {
+	r.Get("/", routes.GetAutomod)
+	r.Post("/", routes.PostAutomodRule)
+	r.Post("/dryrun", routes.PostAutomodDryRun)
+	r.Put("/{ruleID}", routes.PutAutomodRule)
+	r.Delete("/{ruleID}", routes.DeleteAutomodRule)
+}
+func (routes *Routes) automodPageData(r *http.Request) (*automodPageData, error) {
+	subH := GetSubdisceptoH(r)
+	rules, err := subH.ListAutomodRules(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	return &automodPageData{
+		Subdiscepto: subH.Name(),
+		Rules:       rules,
+		FlagTypes:   models.FlagTypes,
+	}, nil
+}
+func (routes *Routes) GetAutomod(w http.ResponseWriter, r *http.Request) {
+	data, err := routes.automodPageData(r)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.tmpls.RenderHTML(w, "automod", data)
+}
+func (routes *Routes) PostAutomodRule(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	rule := models.AutomodRuleReq{}
+	err := utils.ParseFormStruct(r, &rule)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = subH.CreateAutomodRule(r.Context(), rule)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.GetAutomod(w, r)
+}
+func (routes *Routes) PostAutomodDryRun(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	rule := models.AutomodRuleReq{}
+	err := utils.ParseFormStruct(r, &rule)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	matches, err := subH.DryRunAutomodRule(r.Context(), rule)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	data, err := routes.automodPageData(r)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	data.DryRun = &rule
+	data.DryRunMatches = matches
+	routes.tmpls.RenderHTML(w, "automod", data)
+}
+func (routes *Routes) PutAutomodRule(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	ruleID, err := strconv.Atoi(chi.URLParam(r, "ruleID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = subH.SetAutomodRuleEnabled(r.Context(), ruleID, r.FormValue("enabled") == "on")
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.GetAutomod(w, r)
+}
+func (routes *Routes) DeleteAutomodRule(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	ruleID, err := strconv.Atoi(chi.URLParam(r, "ruleID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = subH.DeleteAutomodRule(r.Context(), ruleID)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.GetAutomod(w, r)
+}
@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"gitlab.com/ranfdev/discepto/internal/models"
+	"gitlab.com/ranfdev/discepto/internal/utils"
+)
+
+func (routes *Routes) SubBadgesRouter(r chi.Router) {
+	r.Get("/", routes.GetBadges)
+	r.Post("/", routes.PostBadge)
+	r.Delete("/{badgeID}", routes.DeleteBadge)
+	r.Post("/{badgeID}/holders", routes.AwardBadge)
+	r.Delete("/{badgeID}/holders/{userID}", routes.RevokeBadge)
+}
+func (routes *Routes) GetBadges(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	badges, err := subH.ListBadges(r.Context())
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	members, err := subH.ListMembers(r.Context())
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.tmpls.RenderHTML(w, "badges", struct {
+		Badges   []models.BadgeView
+		Members  []models.Member
+		SubPerms models.Perms
+	}{
+		badges,
+		members,
+		subH.Perms(),
+	})
+}
+func (routes *Routes) PostBadge(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	badge := &models.BadgeReq{}
+	err := utils.ParseFormStruct(r, badge)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = subH.CreateBadge(r.Context(), badge)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.GetBadges(w, r)
+}
+func (routes *Routes) DeleteBadge(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	badgeID, err := strconv.Atoi(chi.URLParam(r, "badgeID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = subH.DeleteBadge(r.Context(), badgeID)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.GetBadges(w, r)
+}
+func (routes *Routes) AwardBadge(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	badgeID, err := strconv.Atoi(chi.URLParam(r, "badgeID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	userID, err := strconv.Atoi(r.FormValue("userID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = subH.AwardBadge(r.Context(), badgeID, userID)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.GetBadges(w, r)
+}
+func (routes *Routes) RevokeBadge(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	badgeID, err := strconv.Atoi(chi.URLParam(r, "badgeID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = subH.RevokeBadge(r.Context(), badgeID, userID)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.GetBadges(w, r)
+}
@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"gitlab.com/ranfdev/discepto/internal/models"
+	"gitlab.com/ranfdev/discepto/internal/utils"
+)
+
+type BanManager interface {
+	ListMembers(ctx context.Context) ([]models.Member, error)
+	ListBans(ctx context.Context) ([]models.Ban, error)
+	BanUser(ctx context.Context, userID int, req models.BanReq) error
+	UnbanUser(ctx context.Context, banID int) error
+}
+type BanManagerExtract = func(r *http.Request) BanManager
+
+func (routes *Routes) GlobalBansRouter(r chi.Router) {
+	routes.bansRouter(r, func(r *http.Request) BanManager {
+		return GetDisceptoH(r)
+	})
+}
+func (routes *Routes) SubBansRouter(r chi.Router) {
+	routes.bansRouter(r, func(r *http.Request) BanManager {
+		return GetSubdisceptoH(r)
+	})
+}
+func (routes *Routes) bansRouter(r chi.Router, extract BanManagerExtract) {
+	r.Get("/", routes.getBans(extract))
+	r.Post("/", routes.postBan(extract))
+	r.Delete("/{banID}", routes.deleteBan(extract))
+}
+func (routes *Routes) getBans(extract BanManagerExtract) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		banManager := extract(r)
+		bans, err := banManager.ListBans(r.Context())
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		members, err := banManager.ListMembers(r.Context())
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		routes.tmpls.RenderHTML(w, "bans", struct {
+			Bans    []models.Ban
+			Members []models.Member
+		}{bans, members})
+	}
+}
+func (routes *Routes) postBan(extract BanManagerExtract) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(r.FormValue("userID"))
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		req := models.BanReq{}
+		err = utils.ParseFormStruct(r, &req)
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		err = extract(r).BanUser(r.Context(), userID, req)
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		routes.getBans(extract)(w, r)
+	}
+}
+func (routes *Routes) deleteBan(extract BanManagerExtract) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		banID, err := strconv.Atoi(chi.URLParam(r, "banID"))
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		err = extract(r).UnbanUser(r.Context(), banID)
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		routes.getBans(extract)(w, r)
+	}
+}
@@ -0,0 +1,124 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/hlog"
+	"gitlab.com/ranfdev/discepto/internal/db"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+// sendVerificationMail mails the link verifying the address
+func (routes *Routes) sendVerificationMail(ctx context.Context, name string, email string, token string) error {
+	link := fmt.Sprintf("%s/verifyemail/%s", routes.envConfig.BaseURL, token)
+	return routes.mailer.Send(ctx, &models.Mail{
+		To:      email,
+		Subject: "Verify your Discepto email",
+		Body: fmt.Sprintf(`Hi %s,
+
+to verify this email for your Discepto account, open this link within %v:
+
+%s
+
+Until then, you can't post nor vote.
+If you don't have an account, ignore this email.
+`, name, db.EmailVerifyTTL, link),
+	})
+}
+
+// requestSignupVerification mails the verification link to a new user.
+// The account is already created, so failures are only logged: the user can ask for another link.
+func (routes *Routes) requestSignupVerification(r *http.Request, userH *db.UserH) {
+	user, token, err := userH.RequestEmailVerification(r.Context())
+	if err == nil {
+		err = routes.sendVerificationMail(r.Context(), user.Name, user.Email, token)
+	}
+	if err != nil {
+		hlog.FromRequest(r).
+			Error().
+			Err(err).
+			Int("user_id", userH.ID()).
+			Msg("Sending the verification email")
+	}
+}
+func (routes *Routes) GetVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	err := routes.db.VerifyEmail(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/u", http.StatusSeeOther)
+}
+func (routes *Routes) PostResendVerification(w http.ResponseWriter, r *http.Request) {
+	userH := GetUserH(r)
+	user, token, err := userH.RequestEmailVerification(r.Context())
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = routes.sendVerificationMail(r.Context(), user.Name, user.Email, token)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/u", http.StatusSeeOther)
+}
+func (routes *Routes) PostEmailChange(w http.ResponseWriter, r *http.Request) {
+	userH := GetUserH(r)
+	email := r.FormValue("email")
+	user, err := userH.Read(r.Context())
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	token, err := userH.RequestEmailChange(r.Context(), email)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = routes.sendVerificationMail(r.Context(), user.Name, email, token)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/u", http.StatusSeeOther)
+}
+
+// The admins can verify users by hand, or send them a new link
+func (routes *Routes) postForceVerify(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = disceptoH.ForceVerifyEmail(r.Context(), userID)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/members", http.StatusSeeOther)
+}
+func (routes *Routes) postResendUserVerification(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	user, token, err := disceptoH.ResendEmailVerification(r.Context(), userID)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = routes.sendVerificationMail(r.Context(), user.Name, user.Email, token)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/members", http.StatusSeeOther)
+}
@@ -20,7 +20,11 @@ func (routes *Routes) EssaysRouter(r chi.Router) {
 	specificEssay.With(routes.EnforceCtx(UserHCtxKey)).Put("/{essayID}", routes.UpdateEssay)
 	specificEssay.With(routes.EnforceCtx(UserHCtxKey)).Delete("/{essayID}", routes.DeleteEssay)
 	specificEssay.With(routes.EnforceCtx(UserHCtxKey)).Post("/{essayID}/vote", routes.PostVote)
+	specificEssay.With(routes.EnforceCtx(UserHCtxKey)).Post("/{essayID}/persuaded", routes.PostPersuaded)
 	specificEssay.With(routes.EnforceCtx(UserHCtxKey)).Post("/{essayID}/report", routes.PostReport)
+	specificEssay.With(routes.EnforceCtx(UserHCtxKey)).Post("/{essayID}/remove", routes.RemoveEssay)
+	specificEssay.With(routes.EnforceCtx(UserHCtxKey)).Post("/{essayID}/restore", routes.RestoreEssay)
+	specificEssay.With(routes.EnforceCtx(UserHCtxKey)).Post("/{essayID}/approve", routes.ApproveEssay)
 }
 func (routes *Routes) EssayCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -39,6 +43,21 @@ func (routes *Routes) EssayCtx(next http.Handler) http.Handler {
 			routes.HandleErr(w, r, err)
 			return
 		}
+		// The essays of NSFW subdisceptos are already behind the gate of the subdiscepto
+		if esH.Nsfw() && GetDisceptoH(r).NsfwPref() == models.NsfwHide {
+			routes.tmpls.RenderHTML(w, "nsfwGate", struct {
+				Subdiscepto string
+				Essay       bool
+				LoggedIn    bool
+				Next        string
+			}{
+				subH.Name(),
+				true,
+				userH != nil,
+				r.URL.Path,
+			})
+			return
+		}
 		ctx := context.WithValue(r.Context(), EssayHCtxKey, esH)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -144,6 +163,15 @@ func (routes *Routes) GetEssay(w http.ResponseWriter, r *http.Request) {
 
 	links := models.FindMDLinks(essay.Content)
 
+	removalReasons := []models.RemovalReason{}
+	if esH.Perms().Check(models.PermRemoveEssay) {
+		removalReasons, err = subH.ListRemovalReasons(r.Context())
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+	}
+
 	data := struct {
 		Subdiscepto     *models.SubdisceptoView
 		ParentEssay     *models.EssayView
@@ -156,6 +184,8 @@ func (routes *Routes) GetEssay(w http.ResponseWriter, r *http.Request) {
 		Perms           models.Perms
 		User            *models.UserView
 		Resources       []models.MDLink
+		RemovalReasons  []models.RemovalReason
+		IsAuthor        bool
 	}{
 		Subdiscepto:     subData,
 		ParentEssay:     parentEssayView,
@@ -168,6 +198,8 @@ func (routes *Routes) GetEssay(w http.ResponseWriter, r *http.Request) {
 		Perms:           esH.Perms().Union(subH.Perms()),
 		User:            user,
 		Resources:       links,
+		RemovalReasons:  removalReasons,
+		IsAuthor:        userH != nil && userH.ID() == essay.AttributedToID,
 	}
 
 	routes.tmpls.RenderHTML(w, "essay", data)
@@ -213,6 +245,8 @@ func (routes *Routes) PostEssay(w http.ResponseWriter, r *http.Request) {
 		AttributedToID: userH.ID(),
 		PostedIn:       subH.Name(),
 		Replying:       replyData,
+		Nsfw:           r.FormValue("nsfw") == "on",
+		MembersOnly:    r.FormValue("members_only") == "on",
 		Tags:           tags,
 	}
 
@@ -254,10 +288,17 @@ func (routes *Routes) PostReport(w http.ResponseWriter, r *http.Request) {
 	report := models.Report{}
 	report.EssayID = essayH.ID()
 	report.FromUserID = userH.ID()
+	report.FlagType = models.FlagType(r.FormValue("category"))
+	report.Description = r.FormValue("description")
 	err := essayH.CreateReport(r.Context(), report, *userH)
 	if err != nil {
 		routes.HandleErr(w, r, err)
+		return
 	}
+
+	subdiscepto := chi.URLParam(r, "subdiscepto")
+	essayID := chi.URLParam(r, "essayID")
+	http.Redirect(w, r, fmt.Sprintf("/s/%s/%s", subdiscepto, essayID), http.StatusSeeOther)
 }
 func (routes *Routes) DeleteEssay(w http.ResponseWriter, r *http.Request) {
 	essayH := GetEssayH(r)
@@ -269,6 +310,51 @@ func (routes *Routes) DeleteEssay(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("HX-Redirect", path.Dir(r.URL.Path))
 	http.Redirect(w, r, path.Dir(r.URL.Path), http.StatusAccepted)
 }
+func (routes *Routes) RemoveEssay(w http.ResponseWriter, r *http.Request) {
+	essayH := GetEssayH(r)
+	reasonID, err := strconv.Atoi(r.FormValue("reasonID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = essayH.RemoveEssay(r.Context(), reasonID)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+
+	subdiscepto := chi.URLParam(r, "subdiscepto")
+	essayID := chi.URLParam(r, "essayID")
+	http.Redirect(w, r, fmt.Sprintf("/s/%s/%s", subdiscepto, essayID), http.StatusSeeOther)
+}
+func (routes *Routes) RestoreEssay(w http.ResponseWriter, r *http.Request) {
+	essayH := GetEssayH(r)
+	err := essayH.RestoreEssay(r.Context())
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+
+	subdiscepto := chi.URLParam(r, "subdiscepto")
+	essayID := chi.URLParam(r, "essayID")
+	url := fmt.Sprintf("/s/%s/%s", subdiscepto, essayID)
+	w.Header().Add("HX-Redirect", url)
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+func (routes *Routes) ApproveEssay(w http.ResponseWriter, r *http.Request) {
+	essayH := GetEssayH(r)
+	err := essayH.ApproveEssay(r.Context())
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+
+	subdiscepto := chi.URLParam(r, "subdiscepto")
+	essayID := chi.URLParam(r, "essayID")
+	url := fmt.Sprintf("/s/%s/%s", subdiscepto, essayID)
+	w.Header().Add("HX-Redirect", url)
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
 func (routes *Routes) UpdateEssay(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("Nope")
 }
@@ -307,3 +393,28 @@ func (routes *Routes) PostVote(w http.ResponseWriter, r *http.Request) {
 	essayID := chi.URLParam(r, "essayID")
 	http.Redirect(w, r, fmt.Sprintf("/s/%s/%s", subdiscepto, essayID), http.StatusSeeOther)
 }
+
+// PostPersuaded toggles the "changed my view" marker on a reply
+func (routes *Routes) PostPersuaded(w http.ResponseWriter, r *http.Request) {
+	userH := GetUserH(r)
+	esH := GetEssayH(r)
+	userDid, err := esH.GetUserDid(r.Context(), *userH)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+
+	if userDid.ChangedView {
+		err = esH.UnmarkChangedView(r.Context(), *userH)
+	} else {
+		err = esH.MarkChangedView(r.Context(), *userH)
+	}
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+
+	subdiscepto := chi.URLParam(r, "subdiscepto")
+	essayID := chi.URLParam(r, "essayID")
+	http.Redirect(w, r, fmt.Sprintf("/s/%s/%s", subdiscepto, essayID), http.StatusSeeOther)
+}
@@ -0,0 +1,169 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"gitlab.com/ranfdev/discepto/internal/models"
+	"gitlab.com/ranfdev/discepto/internal/utils"
+)
+
+// Moderators side
+func (routes *Routes) SubInvitesRouter(r chi.Router) {
+	r.Get("/", routes.GetSubInvites)
+	r.Post("/", routes.PostSubInviteLink)
+	r.Post("/user", routes.PostSubInviteUser)
+	r.Delete("/{inviteID}", routes.DeleteSubInvite)
+}
+func (routes *Routes) renderSubInvites(w http.ResponseWriter, r *http.Request, newToken string) {
+	subH := GetSubdisceptoH(r)
+	invites, err := subH.ListInvites(r.Context())
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	// Only who can manage roles can attach one to an invite
+	roles, _ := subH.ListRoles(r.Context())
+	routes.tmpls.RenderHTML(w, "invites", struct {
+		Subdiscepto string
+		Invites     []models.Invite
+		Roles       []models.Role
+		NewToken    string
+	}{
+		subH.Name(),
+		invites,
+		roles,
+		newToken,
+	})
+}
+func (routes *Routes) GetSubInvites(w http.ResponseWriter, r *http.Request) {
+	routes.renderSubInvites(w, r, "")
+}
+func (routes *Routes) PostSubInviteLink(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	userH := GetUserH(r)
+	req := models.InviteReq{}
+	err := utils.ParseFormStruct(r, &req)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	token, err := subH.CreateInviteLink(r.Context(), *userH, req)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.renderSubInvites(w, r, token)
+}
+func (routes *Routes) PostSubInviteUser(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	userH := GetUserH(r)
+	invitedID, err := strconv.Atoi(r.FormValue("userID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	req := models.InviteReq{}
+	err = utils.ParseFormStruct(r, &req)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = subH.InviteUser(r.Context(), *userH, invitedID, req)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.renderSubInvites(w, r, "")
+}
+func (routes *Routes) DeleteSubInvite(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	inviteID, err := strconv.Atoi(chi.URLParam(r, "inviteID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = subH.DeleteInvite(r.Context(), inviteID)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.renderSubInvites(w, r, "")
+}
+
+// User side
+func (routes *Routes) UserInvitesRouter(r chi.Router) {
+	r.Get("/", routes.GetUserInvites)
+	r.Post("/{inviteID}/accept", routes.PostAcceptInvite)
+	r.Post("/{inviteID}/decline", routes.PostDeclineInvite)
+}
+func (routes *Routes) GetUserInvites(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	userH := GetUserH(r)
+	invites, err := disceptoH.ListUserInvites(r.Context(), userH)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.tmpls.RenderHTML(w, "userInvites", invites)
+}
+func (routes *Routes) PostAcceptInvite(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	userH := GetUserH(r)
+	inviteID, err := strconv.Atoi(chi.URLParam(r, "inviteID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	subName, err := disceptoH.AcceptInvite(r.Context(), userH, inviteID)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/s/%s", subName), http.StatusSeeOther)
+}
+func (routes *Routes) PostDeclineInvite(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	userH := GetUserH(r)
+	inviteID, err := strconv.Atoi(chi.URLParam(r, "inviteID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = disceptoH.DeclineInvite(r.Context(), userH, inviteID)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/invites", http.StatusSeeOther)
+}
+
+// Invite links, visible to anyone
+func (routes *Routes) GetInviteLink(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	token := chi.URLParam(r, "token")
+	invite, err := disceptoH.ReadInvite(r.Context(), token)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.tmpls.RenderHTML(w, "invite", struct {
+		Invite   *models.Invite
+		LoggedIn bool
+	}{
+		invite,
+		GetUserH(r) != nil,
+	})
+}
+func (routes *Routes) PostInviteLink(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	userH := GetUserH(r)
+	subName, err := disceptoH.RedeemInvite(r.Context(), userH, chi.URLParam(r, "token"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/s/%s", subName), http.StatusSeeOther)
+}
@@ -0,0 +1,73 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+// User side
+func (routes *Routes) GetJoinRequest(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	userH := GetUserH(r)
+	subName := chi.URLParam(r, "subdiscepto")
+	pending, err := disceptoH.ReadJoinRequest(r.Context(), userH, subName)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.tmpls.RenderHTML(w, "joinRequest", struct {
+		Subdiscepto string
+		Pending     *models.JoinRequest
+	}{
+		subName,
+		pending,
+	})
+}
+func (routes *Routes) PostJoinRequest(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	userH := GetUserH(r)
+	subName := chi.URLParam(r, "subdiscepto")
+	err := disceptoH.RequestJoin(r.Context(), userH, subName, r.FormValue("message"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/join/%s", subName), http.StatusSeeOther)
+}
+
+// Moderators side
+func (routes *Routes) SubJoinRequestsRouter(r chi.Router) {
+	r.Get("/", routes.GetSubJoinRequests)
+	r.Post("/{requestID}/approve", routes.decideJoinRequest(true))
+	r.Post("/{requestID}/deny", routes.decideJoinRequest(false))
+}
+func (routes *Routes) GetSubJoinRequests(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	reqs, err := subH.ListJoinRequests(r.Context())
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.tmpls.RenderHTML(w, "joinRequests", reqs)
+}
+func (routes *Routes) decideJoinRequest(approved bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subH := GetSubdisceptoH(r)
+		userH := GetUserH(r)
+		requestID, err := strconv.Atoi(chi.URLParam(r, "requestID"))
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		err = subH.DecideJoinRequest(r.Context(), *userH, requestID, approved)
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		routes.GetSubJoinRequests(w, r)
+	}
+}
@@ -10,6 +10,8 @@ import (
 func (routes *Routes) GlobalMembersRouter(r chi.Router) {
 	r.Use(RoleManagerCtx(GetRoleManagerDiscepto))
 	routes.membersRouter(r)
+	r.Post("/{userID}/verify", routes.postForceVerify)
+	r.Post("/{userID}/resendverification", routes.postResendUserVerification)
 }
 func (routes *Routes) SubMembersRouter(r chi.Router) {
 	r.Use(RoleManagerCtx(GetRoleManagerSubdiscepto))
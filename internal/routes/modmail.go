@@ -0,0 +1,172 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"gitlab.com/ranfdev/discepto/internal/models"
+	"gitlab.com/ranfdev/discepto/internal/utils"
+)
+
+type modmailThreadPageData struct {
+	*models.ModmailThreadView
+	// Where replies are posted
+	ReplyURL string
+	AsMods   bool
+}
+
+// User side
+func (routes *Routes) ModmailRouter(r chi.Router) {
+	r.Get("/", routes.GetModmail)
+	r.Post("/", routes.PostModmail)
+	r.Get("/{threadID}", routes.GetModmailThread)
+	r.Post("/{threadID}", routes.PostModmailReply)
+}
+func (routes *Routes) GetModmail(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	userH := GetUserH(r)
+	threads, err := disceptoH.ListModmail(r.Context(), userH)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.tmpls.RenderHTML(w, "modmail", struct {
+		Threads     []models.ModmailThread
+		Subdiscepto string
+	}{
+		threads,
+		r.URL.Query().Get("subdiscepto"),
+	})
+}
+func (routes *Routes) PostModmail(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	userH := GetUserH(r)
+	subH, err := disceptoH.GetSubdisceptoH(r.Context(), r.FormValue("subdiscepto"), userH)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	req := models.ModmailReq{}
+	err = utils.ParseFormStruct(r, &req)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	threadID, err := subH.OpenModmail(r.Context(), *userH, req)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/modmail/%d", threadID), http.StatusSeeOther)
+}
+func (routes *Routes) GetModmailThread(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	userH := GetUserH(r)
+	threadID, err := strconv.Atoi(chi.URLParam(r, "threadID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	thread, err := disceptoH.ReadModmail(r.Context(), userH, threadID)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.tmpls.RenderHTML(w, "modmailThread", modmailThreadPageData{
+		ModmailThreadView: thread,
+		ReplyURL:          fmt.Sprintf("/modmail/%d", threadID),
+	})
+}
+func (routes *Routes) PostModmailReply(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	userH := GetUserH(r)
+	threadID, err := strconv.Atoi(chi.URLParam(r, "threadID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = disceptoH.ReplyModmail(r.Context(), userH, threadID, r.FormValue("text"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/modmail/%d", threadID), http.StatusSeeOther)
+}
+
+// Moderators side
+func (routes *Routes) SubModmailRouter(r chi.Router) {
+	r.Get("/", routes.GetSubModmail)
+	r.Get("/{threadID}", routes.GetSubModmailThread)
+	r.Post("/{threadID}", routes.PostSubModmailReply)
+	r.Post("/{threadID}/archive", routes.PostSubModmailArchive)
+}
+func (routes *Routes) GetSubModmail(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	userH := GetUserH(r)
+	archived := r.URL.Query().Get("archived") != ""
+	threads, err := subH.ListModmail(r.Context(), *userH, archived)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.tmpls.RenderHTML(w, "subModmail", struct {
+		Subdiscepto string
+		Threads     []models.ModmailThread
+		Archived    bool
+	}{
+		subH.Name(),
+		threads,
+		archived,
+	})
+}
+func (routes *Routes) GetSubModmailThread(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	userH := GetUserH(r)
+	threadID, err := strconv.Atoi(chi.URLParam(r, "threadID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	thread, err := subH.ReadModmail(r.Context(), *userH, threadID)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.tmpls.RenderHTML(w, "modmailThread", modmailThreadPageData{
+		ModmailThreadView: thread,
+		ReplyURL:          fmt.Sprintf("/s/%s/modmail/%d", subH.Name(), threadID),
+		AsMods:            true,
+	})
+}
+func (routes *Routes) PostSubModmailReply(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	userH := GetUserH(r)
+	threadID, err := strconv.Atoi(chi.URLParam(r, "threadID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = subH.ReplyModmail(r.Context(), *userH, threadID, r.FormValue("text"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/s/%s/modmail/%d", subH.Name(), threadID), http.StatusSeeOther)
+}
+func (routes *Routes) PostSubModmailArchive(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	threadID, err := strconv.Atoi(chi.URLParam(r, "threadID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	archived := r.FormValue("archived") != "false"
+	err = subH.ArchiveModmail(r.Context(), threadID, archived)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/s/%s/modmail", subH.Name()), http.StatusSeeOther)
+}
@@ -0,0 +1,75 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog/hlog"
+	"gitlab.com/ranfdev/discepto/internal/db"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+func (routes *Routes) GetForgotPasswd(w http.ResponseWriter, r *http.Request) {
+	routes.tmpls.RenderHTML(w, "forgotPasswd", struct{ Sent bool }{})
+}
+func (routes *Routes) PostForgotPasswd(w http.ResponseWriter, r *http.Request) {
+	user, token, err := routes.db.RequestPasswdReset(r.Context(), r.FormValue("email"))
+	// Don't tell whether an account uses the email
+	if errors.Is(err, pgx.ErrNoRows) || errors.Is(err, models.ErrTooManyResets) {
+		routes.tmpls.RenderHTML(w, "forgotPasswd", struct{ Sent bool }{true})
+		return
+	} else if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+
+	// The mail is sent in the background, so that neither the response time
+	// nor a delivery failure tell whether the account exists
+	logger := hlog.FromRequest(r)
+	link := fmt.Sprintf("%s/resetpasswd/%s", routes.envConfig.BaseURL, token)
+	mail := &models.Mail{
+		To:      user.Email,
+		Subject: "Reset your Discepto password",
+		Body: fmt.Sprintf(`Hi %s,
+
+someone asked to reset the password of your Discepto account.
+To choose a new password, open this link within %v:
+
+%s
+
+If it wasn't you, ignore this email: your password won't change.
+`, user.Name, db.PasswdResetTTL, link),
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), MailTimeout)
+		defer cancel()
+		if err := routes.mailer.Send(ctx, mail); err != nil {
+			logger.Error().
+				Err(err).
+				Int("user_id", user.ID).
+				Msg("Sending the password reset email")
+		}
+	}()
+	routes.tmpls.RenderHTML(w, "forgotPasswd", struct{ Sent bool }{true})
+}
+func (routes *Routes) GetResetPasswd(w http.ResponseWriter, r *http.Request) {
+	routes.tmpls.RenderHTML(w, "resetPasswd", chi.URLParam(r, "token"))
+}
+func (routes *Routes) PostResetPasswd(w http.ResponseWriter, r *http.Request) {
+	err := routes.db.ResetPasswd(r.Context(), chi.URLParam(r, "token"), r.FormValue("password"))
+	switch {
+	case errors.Is(err, models.ErrInvalidResetToken):
+		err = &ErrBadRequest{Cause: err, Motivation: "The link is expired or has already been used"}
+	case errors.Is(err, models.ErrWeakPasswd):
+		err = &ErrBadRequest{Cause: err, Motivation: "The password is too weak"}
+	}
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
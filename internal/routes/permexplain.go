@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+type PermExplainExtract = func(r *http.Request, userID int, essayID int, perm models.Perm) (*models.PermExplanation, error)
+
+func (routes *Routes) GlobalPermExplainRouter(r chi.Router) {
+	r.Get("/", routes.getPermExplain(true, func(r *http.Request, userID int, essayID int, perm models.Perm) (*models.PermExplanation, error) {
+		return GetDisceptoH(r).ExplainPerm(r.Context(), userID, r.FormValue("sub"), essayID, perm)
+	}))
+}
+func (routes *Routes) SubPermExplainRouter(r chi.Router) {
+	r.Get("/", routes.getPermExplain(false, func(r *http.Request, userID int, essayID int, perm models.Perm) (*models.PermExplanation, error) {
+		return GetSubdisceptoH(r).ExplainPerm(r.Context(), userID, essayID, perm)
+	}))
+}
+
+// getPermExplain shows the form, and the explanation once a user and a permission are given
+func (routes *Routes) getPermExplain(global bool, explain PermExplainExtract) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var explanation *models.PermExplanation
+		if r.FormValue("user") != "" && r.FormValue("perm") != "" {
+			userID, err := strconv.Atoi(r.FormValue("user"))
+			if err != nil {
+				routes.HandleErr(w, r, err)
+				return
+			}
+			essayID := 0
+			if r.FormValue("essay") != "" {
+				essayID, err = strconv.Atoi(r.FormValue("essay"))
+				if err != nil {
+					routes.HandleErr(w, r, err)
+					return
+				}
+			}
+			explanation, err = explain(r, userID, essayID, models.Perm(r.FormValue("perm")))
+			if err != nil {
+				routes.HandleErr(w, r, err)
+				return
+			}
+		}
+		available := models.PermsSubAdmin
+		if global {
+			available = models.PermsGlobalAdmin
+		}
+		routes.tmpls.RenderHTML(w, "permExplain", struct {
+			Global         bool
+			AvailablePerms models.Perms
+			Explanation    *models.PermExplanation
+		}{
+			global,
+			available,
+			explanation,
+		})
+	}
+}
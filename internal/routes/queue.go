@@ -0,0 +1,89 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+func (routes *Routes) SubQueueRouter(r chi.Router) {
+	r.Get("/", routes.GetQueue)
+	r.Post("/approve", routes.PostQueueApprove)
+	r.Post("/reject", routes.PostQueueReject)
+}
+func (routes *Routes) GetQueue(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	essays, err := subH.ListHeldEssays(r.Context())
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	reasons, err := subH.ListRemovalReasons(r.Context())
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.tmpls.RenderHTML(w, "queue", struct {
+		Subdiscepto    string
+		Essays         []models.HeldEssay
+		RemovalReasons []models.RemovalReason
+	}{
+		subH.Name(),
+		essays,
+		reasons,
+	})
+}
+
+// Essays selected in the queue
+func parseEssayIDs(r *http.Request) ([]int, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	for _, v := range r.Form["essayID"] {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+func (routes *Routes) PostQueueApprove(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	userH := GetUserH(r)
+	ids, err := parseEssayIDs(r)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = subH.ApproveEssays(r.Context(), userH, ids)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.GetQueue(w, r)
+}
+func (routes *Routes) PostQueueReject(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	userH := GetUserH(r)
+	ids, err := parseEssayIDs(r)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	reasonID, err := strconv.Atoi(r.FormValue("reasonID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = subH.RejectEssays(r.Context(), userH, ids, reasonID)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.GetQueue(w, r)
+}
@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+func (routes *Routes) SubRemovalsRouter(r chi.Router) {
+	r.Get("/", routes.GetRemovals)
+	r.Post("/reasons", routes.PostRemovalReason)
+	r.Delete("/reasons/{reasonID}", routes.DeleteRemovalReason)
+}
+func (routes *Routes) GetRemovals(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	reasons, err := subH.ListRemovalReasons(r.Context())
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	removed := []models.RemovedEssay{}
+	if subH.Perms().Check(models.PermRemoveEssay) {
+		removed, err = subH.ListRemovedEssays(r.Context())
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+	}
+	routes.tmpls.RenderHTML(w, "removals", struct {
+		Subdiscepto   string
+		Reasons       []models.RemovalReason
+		RemovedEssays []models.RemovedEssay
+		SubPerms      models.Perms
+		RetentionDays int
+	}{
+		subH.Name(),
+		reasons,
+		removed,
+		subH.Perms(),
+		routes.envConfig.RemovedRetentionDays,
+	})
+}
+func (routes *Routes) PostRemovalReason(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	err := subH.CreateRemovalReason(r.Context(), r.FormValue("text"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.GetRemovals(w, r)
+}
+func (routes *Routes) DeleteRemovalReason(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	reasonID, err := strconv.Atoi(chi.URLParam(r, "reasonID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = subH.DeleteRemovalReason(r.Context(), reasonID)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.GetRemovals(w, r)
+}
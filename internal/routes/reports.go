@@ -12,23 +12,72 @@ import (
 func (routes *Routes) SubReportsRouter(r chi.Router) {
 	r.Get("/", routes.GetReports)
 	r.Delete("/{reportID}", routes.DeleteReport)
+	r.Post("/{reportID}/claim", routes.ClaimReport)
+	r.Post("/{reportID}/resolve", routes.closeReport(models.ReportStateResolved))
+	r.Post("/{reportID}/dismiss", routes.closeReport(models.ReportStateDismissed))
 }
 func (routes *Routes) GetReports(w http.ResponseWriter, r *http.Request) {
 	subH := GetSubdisceptoH(r)
-	reports, err := subH.ListReports(r.Context())
+	// Unknown values are ignored, as if they weren't given
+	filter := models.ReportFilter{}
+	if state := models.ReportState(r.URL.Query().Get("state")); state.Valid() {
+		filter.State = state
+	}
+	if flagType := models.FlagType(r.URL.Query().Get("category")); flagType.Valid() {
+		filter.FlagType = flagType
+	}
+	reports, err := subH.ListReports(r.Context(), filter)
 	if err != nil {
 		fmt.Println(subH.Perms(), err)
 		routes.HandleErr(w, r, err)
 		return
 	}
 	routes.tmpls.RenderHTML(w, "reports", struct {
-		Reports  []models.ReportView
-		SubPerms models.Perms
+		Reports      []models.ReportView
+		SubPerms     models.Perms
+		Filter       models.ReportFilter
+		ReportStates []models.ReportState
+		FlagTypes    []models.FlagType
 	}{
 		reports,
 		subH.Perms(),
+		filter,
+		models.ReportStates,
+		models.FlagTypes,
 	})
 }
+func (routes *Routes) ClaimReport(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	userH := GetUserH(r)
+	reportID, err := strconv.Atoi(chi.URLParam(r, "reportID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = subH.ClaimReport(r.Context(), reportID, *userH)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.GetReports(w, r)
+}
+func (routes *Routes) closeReport(state models.ReportState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subH := GetSubdisceptoH(r)
+		userH := GetUserH(r)
+		reportID, err := strconv.Atoi(chi.URLParam(r, "reportID"))
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		err = subH.CloseReport(r.Context(), reportID, *userH, state, r.FormValue("resolution"))
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		routes.GetReports(w, r)
+	}
+}
 func (routes *Routes) DeleteReport(w http.ResponseWriter, r *http.Request) {
 	subH := GetSubdisceptoH(r)
 	reportID, err := strconv.Atoi(chi.URLParam(r, "reportID"))
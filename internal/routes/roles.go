@@ -9,7 +9,6 @@ import (
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/jackc/pgconn"
 	"gitlab.com/ranfdev/discepto/internal/db"
 	"gitlab.com/ranfdev/discepto/internal/models"
 )
@@ -20,6 +19,8 @@ func (routes *Routes) GlobalRolesRouter(r chi.Router) {
 }
 func (routes *Routes) SubRoleRouter(r chi.Router) {
 	r.Use(RoleManagerCtx(GetRoleManagerSubdiscepto))
+	r.Get("/export", routes.exportRoles)
+	r.Post("/import", routes.importRoles)
 	routes.roleRouter(r)
 }
 func (routes *Routes) roleRouter(r chi.Router) {
@@ -28,6 +29,7 @@ func (routes *Routes) roleRouter(r chi.Router) {
 	r.Get("/{roleName}", routes.getRolePerms)
 	r.Post("/", routes.postNewRole)
 	r.Put("/{roleName}", routes.putRolePerms)
+	r.Post("/{roleName}/rank", routes.postRoleRank)
 	r.Delete("/{roleName}", routes.deleteRole)
 }
 
@@ -52,6 +54,7 @@ func RoleManagerCtx(extract RoleManagerExtract) func(next http.Handler) http.Han
 
 type RoleManager interface {
 	Assign(ctx context.Context, toUser int, roleH db.RoleH) error
+	AssignFor(ctx context.Context, toUser int, roleH db.RoleH, days int) error
 	Unassign(ctx context.Context, toUser int, roleH db.RoleH) error
 	ListMembers(ctx context.Context) ([]models.Member, error)
 	ListRoles(ctx context.Context) ([]models.Role, error)
@@ -72,9 +75,17 @@ func (routes *Routes) assignRole(w http.ResponseWriter, r *http.Request) {
 		routes.HandleErr(w, r, err)
 		return
 	}
-	err = roleManager.Assign(r.Context(), userID, *roleH)
-	pgErr := &pgconn.PgError{}
-	if err != nil && !(errors.As(err, &pgErr) && pgErr.Code == "23505") {
+	// Empty for a permanent role
+	days := 0
+	if d := r.FormValue("days"); d != "" {
+		days, err = strconv.Atoi(d)
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+	}
+	err = roleManager.AssignFor(r.Context(), userID, *roleH, days)
+	if err != nil {
 		routes.HandleErr(w, r, err)
 		return
 	}
@@ -134,12 +145,16 @@ func (routes *Routes) putRolePerms(w http.ResponseWriter, r *http.Request) {
 	var perms models.Perms
 	{
 		tmpPerms := []models.Perm{}
+		denied := []models.Perm{}
 		for k, v := range r.Form {
-			if v[0] == "on" {
+			switch v[0] {
+			case "on":
 				tmpPerms = append(tmpPerms, models.Perm(k))
+			case "deny":
+				denied = append(denied, models.Perm(k))
 			}
 		}
-		perms = models.NewPerms(tmpPerms...)
+		perms = models.NewPerms(tmpPerms...).Deny(denied...)
 	}
 	roleH, err := roleManager.GetRoleH(r.Context(), chi.URLParam(r, "roleName"))
 	if err != nil {
@@ -153,6 +168,25 @@ func (routes *Routes) putRolePerms(w http.ResponseWriter, r *http.Request) {
 	}
 	routes.getRolePerms(w, r)
 }
+func (routes *Routes) postRoleRank(w http.ResponseWriter, r *http.Request) {
+	roleManager := GetRoleManager(r)
+	rank, err := strconv.Atoi(r.FormValue("rank"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	roleH, err := roleManager.GetRoleH(r.Context(), chi.URLParam(r, "roleName"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = roleH.SetRank(r.Context(), rank)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, path.Dir(r.URL.Path), http.StatusSeeOther)
+}
 
 // Should use better number
 const RoleManagerKey = disceptoCtxKey(100)
@@ -167,12 +201,15 @@ func (routes *Routes) listRoles(w http.ResponseWriter, r *http.Request) {
 		routes.HandleErr(w, r, err)
 		return
 	}
+	_, canImport := roleManager.(RoleTemplateManager)
 	data := struct {
 		Subdiscepto *models.SubdisceptoView
 		Roles       []models.Role
+		CanImport   bool
 	}{
 		Subdiscepto: nil,
 		Roles:       roles,
+		CanImport:   canImport,
 	}
 	routes.tmpls.RenderHTML(w, "roles", data)
 }
@@ -0,0 +1,72 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+
+	"gitlab.com/ranfdev/discepto/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+type RoleTemplateManager interface {
+	ExportRoles(ctx context.Context) (*models.RoleTemplate, error)
+	ImportRoles(ctx context.Context, t models.RoleTemplate) error
+}
+
+// exportRoles downloads the roles as YAML, or as JSON with ?format=json
+func (routes *Routes) exportRoles(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	t, err := subH.ExportRoles(r.Context())
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	// Encode before writing, to report the errors with a proper status
+	var buf bytes.Buffer
+	ext := "yaml"
+	if r.FormValue("format") == "json" {
+		ext = "json"
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(t)
+	} else {
+		enc := yaml.NewEncoder(&buf)
+		err = enc.Encode(t)
+		if err == nil {
+			err = enc.Close()
+		}
+	}
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	if ext == "json" {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "application/yaml")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-roles.%s"`, subH.Name(), ext))
+	w.Write(buf.Bytes())
+}
+
+// importRoles reads a template pasted as YAML or JSON (JSON is valid YAML)
+func (routes *Routes) importRoles(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	t := models.RoleTemplate{}
+	dec := yaml.NewDecoder(bytes.NewBufferString(r.FormValue("template")))
+	dec.KnownFields(true)
+	if err := dec.Decode(&t); err != nil {
+		routes.HandleErr(w, r, models.ErrInvalidRoleTemplate)
+		return
+	}
+	err := subH.ImportRoles(r.Context(), t)
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, path.Dir(r.URL.Path), http.StatusSeeOther)
+}
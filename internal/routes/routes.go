@@ -6,9 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
@@ -35,6 +37,9 @@ const (
 const (
 	LimitGlobalCount, LimitGlobalDuration = 180, 1 * time.Minute
 	LimitPostCount, LimitPostDuration     = 30, 1 * time.Minute
+	LimitResetCount, LimitResetDuration   = 5, 1 * time.Hour
+	// Time given to the mailer, for emails sent in the background
+	MailTimeout = 1 * time.Minute
 )
 
 type Routes struct {
@@ -42,11 +47,12 @@ type Routes struct {
 	db             *db.SharedDB
 	tmpls          *render.Templates
 	sessionManager *scs.SessionManager
+	mailer         models.Mailer
 }
 
-func NewRouter(config *models.EnvConfig, db *db.SharedDB, log zerolog.Logger, tmpls *render.Templates) chi.Router {
+func NewRouter(config *models.EnvConfig, db *db.SharedDB, log zerolog.Logger, tmpls *render.Templates, mailer models.Mailer) chi.Router {
 	sessionManager := NewSessionManager(config)
-	routes := &Routes{envConfig: config, db: db, tmpls: tmpls, sessionManager: sessionManager}
+	routes := &Routes{envConfig: config, db: db, tmpls: tmpls, sessionManager: sessionManager, mailer: mailer}
 	sessionManager.ErrorFunc = func(w http.ResponseWriter, r *http.Request, err error) {
 		routes.RenderErr(w, r, &ErrInternal{Cause: err})
 	}
@@ -115,22 +121,44 @@ func NewRouter(config *models.EnvConfig, db *db.SharedDB, log zerolog.Logger, tm
 	r.Post("/signup", routes.PostSignup)
 	r.Get("/login", routes.GetLogin)
 	r.Post("/login", routes.PostLogin)
+	r.Get("/forgotpasswd", routes.GetForgotPasswd)
+	r.With(httprate.LimitByIP(LimitResetCount, LimitResetDuration)).Post("/forgotpasswd", routes.PostForgotPasswd)
+	r.Get("/resetpasswd/{token}", routes.GetResetPasswd)
+	r.Post("/resetpasswd/{token}", routes.PostResetPasswd)
+	r.Get("/verifyemail/{token}", routes.GetVerifyEmail)
 	r.Get("/terms", routes.GetTerms)
+	r.Post("/confirmage", routes.PostConfirmAge)
+	r.Get("/invite/{token}", routes.GetInviteLink)
 	r.Route("/s", routes.SubdisceptoRouter)
 
 	loggedIn := r.With(routes.EnforceCtx(UserHCtxKey))
 	loggedIn.Get("/u", routes.GetUserSelf)
+	loggedIn.Route("/u/appeals", routes.UserAppealsRouter)
+	loggedIn.Post("/u/nsfw", routes.PostNsfwPref)
+	loggedIn.Post("/u/delete", routes.PostDeleteSelf)
+	loggedIn.Post("/u/verifyemail", routes.PostResendVerification)
+	loggedIn.Post("/u/email", routes.PostEmailChange)
 	loggedIn.Get("/u/{viewingUserID}", routes.GetUser)
+	loggedIn.Post("/u/{viewingUserID}/delete", routes.PostDeleteUser)
 	loggedIn.Post("/signout", routes.PostSignout)
 	loggedIn.Get("/newessay", routes.GetNewEssay)
 	loggedIn.Post("/newessay", routes.PostEssay)
 	loggedIn.Route("/roles", routes.GlobalRolesRouter)
 	loggedIn.Route("/members", routes.GlobalMembersRouter)
 	loggedIn.Route("/settings", routes.GlobalSettingsRouter)
+	loggedIn.Route("/auditlog", routes.GlobalAuditLogRouter)
+	loggedIn.Route("/bans", routes.GlobalBansRouter)
+	loggedIn.Route("/appeals", routes.GlobalAppealsRouter)
+	loggedIn.Route("/permissions", routes.GlobalPermExplainRouter)
 	loggedIn.Get("/search", routes.GetSearch)
 	loggedIn.Get("/newsubdiscepto", routes.GetNewSubdiscepto)
 	loggedIn.Get("/notifications", routes.GetNotifications)
 	loggedIn.Post("/notifications/{notifID}", routes.ViewDeleteNotif)
+	loggedIn.Route("/modmail", routes.ModmailRouter)
+	loggedIn.Post("/invite/{token}", routes.PostInviteLink)
+	loggedIn.Route("/invites", routes.UserInvitesRouter)
+	loggedIn.Get("/join/{subdiscepto}", routes.GetJoinRequest)
+	loggedIn.Post("/join/{subdiscepto}", routes.PostJoinRequest)
 
 	// Fallback
 	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
@@ -161,6 +189,13 @@ func (routes *Routes) UserCtx(next http.Handler) http.Handler {
 		if err != nil {
 			routes.sessionManager.Clear(r.Context())
 		}
+		// Sessions started before a password reset, or of deleted users, aren't valid anymore
+		generation, err := userH.SessionGeneration(r.Context())
+		if err != nil || generation != routes.sessionManager.GetInt(r.Context(), "sessionGeneration") {
+			routes.sessionManager.Remove(r.Context(), "userID")
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		ctx := context.WithValue(r.Context(), UserHCtxKey, &userH)
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -176,6 +211,25 @@ func (routes *Routes) DisceptoCtx(next http.Handler) http.Handler {
 			routes.HandleErr(w, r, err)
 			return
 		}
+		// Globally banned users can only sign out and appeal
+		isAllowed := r.URL.Path == "/signout" || strings.HasPrefix(r.URL.Path, "/static/") ||
+			strings.HasPrefix(r.URL.Path, "/u/appeals")
+		if ban := disceptoH.Ban(); ban != nil && !isAllowed {
+			routes.RenderErr(w, r, &ErrBanned{Ban: *ban})
+			return
+		}
+		if userH == nil && routes.sessionManager.GetBool(r.Context(), "ageConfirmed") {
+			disceptoH.ConfirmAge()
+		}
+		// Read by the page script, to decide whether NSFW content must be blurred
+		if c, err := r.Cookie("nsfw_pref"); err != nil || c.Value != string(disceptoH.NsfwPref()) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     "nsfw_pref",
+				Value:    string(disceptoH.NsfwPref()),
+				Path:     "/",
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
 
 		ctx := context.WithValue(r.Context(), DisceptoHCtxKey, disceptoH)
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -287,6 +341,20 @@ func (err *ErrBadRequest) Respond(w http.ResponseWriter, r *http.Request, routes
 	return loggableErr
 }
 
+type ErrBanned struct {
+	Ban models.Ban
+}
+
+func (err *ErrBanned) Respond(w http.ResponseWriter, r *http.Request, routes *Routes) LoggableErr {
+	loggableErr := LoggableErr{
+		Cause:   fmt.Errorf("user %d is banned", err.Ban.UserID),
+		Message: "User banned",
+		Status:  http.StatusForbidden,
+	}
+	routes.tmpls.RenderHTML(w, "banned", err.Ban)
+	return loggableErr
+}
+
 type ErrInsuffPerms struct {
 	Cause error
 }
@@ -328,6 +396,32 @@ func TranslateErr(err error) AppError {
 		models.ErrBadContentLen,
 		models.ErrEmailAlreadyUsed,
 		models.ErrInvalidFormat,
+		models.ErrInvalidPersuasion,
+		models.ErrInvalidBadge,
+		models.ErrInvalidReport,
+		models.ErrInvalidBan,
+		models.ErrInvalidRemovalReason,
+		models.ErrNotRemoved,
+		models.ErrNotHeld,
+		models.ErrInvalidAutomodRule,
+		models.ErrInvalidModmail,
+		models.ErrInvalidAppeal,
+		models.ErrAppealExists,
+		models.ErrAppealSelfReview,
+		models.ErrInvalidNsfwPref,
+		models.ErrInvalidInvite,
+		models.ErrInviteExpired,
+		models.ErrJoinClosed,
+		models.ErrInvalidJoinRequest,
+		models.ErrJoinRequestExists,
+		models.ErrInvalidExpiry,
+		models.ErrRoleRank,
+		models.ErrInvalidRoleTemplate,
+		models.ErrInvalidDeletion,
+		models.ErrVoteNullified,
+		models.ErrInvalidVerifyToken,
+		models.ErrTooManyVerifyMails,
+		models.ErrAlreadyVerified,
 		models.ErrPermDenied,
 		models.ErrWeakPasswd,
 		strconv.ErrSyntax,
@@ -337,6 +431,9 @@ func TranslateErr(err error) AppError {
 			return &ErrBadRequest{Cause: brErr}
 		}
 	}
+	if rejected, ok := err.(models.ErrAutomodRejected); ok {
+		return &ErrBadRequest{Cause: rejected, Motivation: "Your essay was rejected by the moderation rules of this community"}
+	}
 	if err == models.ErrPermDenied {
 		return &ErrInsuffPerms{Cause: err}
 	}
@@ -415,11 +512,16 @@ func (routes *Routes) GetUser(w http.ResponseWriter, r *http.Request) {
 		Essays          []models.EssayView
 		FilterReplyType string
 		MySubdisceptos  []models.SubdisceptoView
+		// Empty when viewing someone else
+		NsfwPref      models.NsfwPref
+		CanDelete     bool
+		EmailVerified bool
 	}{
 		User:            userData,
 		Essays:          essays,
 		FilterReplyType: "general",
 		MySubdisceptos:  mySubs,
+		CanDelete:       disceptoH.Perms().Check(models.PermDeleteUser) && vUserID != userH.ID(),
 	})
 }
 func (routes *Routes) GetUserSelf(w http.ResponseWriter, r *http.Request) {
@@ -442,18 +544,75 @@ func (routes *Routes) GetUserSelf(w http.ResponseWriter, r *http.Request) {
 		routes.HandleErr(w, r, err)
 		return
 	}
+
+	verified, err := userH.EmailVerified(r.Context())
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
 	routes.tmpls.RenderHTML(w, "user", struct {
 		User            *models.UserView
 		Essays          []models.EssayView
 		FilterReplyType string
 		MySubdisceptos  []models.SubdisceptoView
+		NsfwPref        models.NsfwPref
+		CanDelete       bool
+		EmailVerified   bool
 	}{
 		User:            userData,
 		Essays:          essays,
 		FilterReplyType: "general",
 		MySubdisceptos:  mySubs,
+		NsfwPref:        disceptoH.NsfwPref(),
+		EmailVerified:   verified,
 	})
 }
+func (routes *Routes) PostNsfwPref(w http.ResponseWriter, r *http.Request) {
+	userH := GetUserH(r)
+	err := userH.SetNsfwPref(r.Context(), models.NsfwPref(r.FormValue("nsfwPref")))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/u", http.StatusSeeOther)
+}
+func (routes *Routes) PostDeleteSelf(w http.ResponseWriter, r *http.Request) {
+	userH := GetUserH(r)
+	err := userH.Delete(r.Context(), models.EssayDeletion(r.FormValue("essays")))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.sessionManager.RenewToken(r.Context())
+	routes.sessionManager.Remove(r.Context(), "userID")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+func (routes *Routes) PostDeleteUser(w http.ResponseWriter, r *http.Request) {
+	disceptoH := GetDisceptoH(r)
+	vUserID, err := strconv.Atoi(chi.URLParam(r, "viewingUserID"))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	err = disceptoH.DeleteUser(r.Context(), vUserID, models.EssayDeletion(r.FormValue("essays")))
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// PostConfirmAge lets anonymous visitors in NSFW subdisceptos
+func (routes *Routes) PostConfirmAge(w http.ResponseWriter, r *http.Request) {
+	routes.sessionManager.Put(r.Context(), "ageConfirmed", true)
+	next := r.URL.Query().Get("next")
+	// Only local redirects
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/"
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
 func (routes *Routes) GetSignup(w http.ResponseWriter, r *http.Request) {
 	routes.tmpls.RenderHTML(w, "signup", nil)
 }
@@ -505,16 +664,28 @@ func (routes *Routes) PostLogin(w http.ResponseWriter, r *http.Request) {
 		routes.HandleErr(w, r, err)
 		return
 	}
+	generation, err := userH.SessionGeneration(r.Context())
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
 	routes.sessionManager.Put(r.Context(), "userID", userH.ID())
+	routes.sessionManager.Put(r.Context(), "sessionGeneration", generation)
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 func (routes *Routes) PostSignup(w http.ResponseWriter, r *http.Request) {
 	email := r.FormValue("email")
-	_, err := routes.db.CreateUser(r.Context(), &models.User{
+
+	// RealIP has already replaced RemoteAddr, when behind a proxy
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	userH, err := routes.db.CreateUserFrom(r.Context(), &models.User{
 		Name:  r.FormValue("name"),
 		Email: email,
-	}, r.FormValue("password"))
+	}, r.FormValue("password"), net.ParseIP(host))
 
 	errorMessage := ""
 	if err != nil {
@@ -540,6 +711,7 @@ The password must:
 		routes.HandleErr(w, r, err)
 		return
 	}
+	routes.requestSignupVerification(r, userH)
 
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
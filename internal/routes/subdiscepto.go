@@ -32,6 +32,18 @@ func (routes *Routes) SubdisceptoRouter(r chi.Router) {
 	specificSub.With(routes.EnforceCtx(UserHCtxKey)).Post("/{subdiscepto}/leave", routes.LeaveSubdiscepto)
 	specificSub.With(routes.EnforceCtx(UserHCtxKey)).Post("/{subdiscepto}/join", routes.JoinSubdiscepto)
 	specificSub.With(routes.EnforceCtx(UserHCtxKey)).Route("/{subdiscepto}/reports", routes.SubReportsRouter)
+	specificSub.With(routes.EnforceCtx(UserHCtxKey)).Route("/{subdiscepto}/voteflags", routes.SubVoteFlagsRouter)
+	specificSub.With(routes.EnforceCtx(UserHCtxKey)).Route("/{subdiscepto}/badges", routes.SubBadgesRouter)
+	specificSub.With(routes.EnforceCtx(UserHCtxKey)).Route("/{subdiscepto}/auditlog", routes.SubAuditLogRouter)
+	specificSub.With(routes.EnforceCtx(UserHCtxKey)).Route("/{subdiscepto}/bans", routes.SubBansRouter)
+	specificSub.With(routes.EnforceCtx(UserHCtxKey)).Route("/{subdiscepto}/appeals", routes.SubAppealsRouter)
+	specificSub.With(routes.EnforceCtx(UserHCtxKey)).Route("/{subdiscepto}/removals", routes.SubRemovalsRouter)
+	specificSub.With(routes.EnforceCtx(UserHCtxKey)).Route("/{subdiscepto}/queue", routes.SubQueueRouter)
+	specificSub.With(routes.EnforceCtx(UserHCtxKey)).Route("/{subdiscepto}/automod", routes.SubAutomodRouter)
+	specificSub.With(routes.EnforceCtx(UserHCtxKey)).Route("/{subdiscepto}/modmail", routes.SubModmailRouter)
+	specificSub.With(routes.EnforceCtx(UserHCtxKey)).Route("/{subdiscepto}/invites", routes.SubInvitesRouter)
+	specificSub.With(routes.EnforceCtx(UserHCtxKey)).Route("/{subdiscepto}/joinrequests", routes.SubJoinRequestsRouter)
+	specificSub.With(routes.EnforceCtx(UserHCtxKey)).Route("/{subdiscepto}/permissions", routes.SubPermExplainRouter)
 }
 func (routes *Routes) SubdiscpetoCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -45,6 +57,24 @@ func (routes *Routes) SubdiscpetoCtx(next http.Handler) http.Handler {
 			routes.HandleErr(w, r, err)
 			return
 		}
+		if ban := subH.Ban(); ban != nil {
+			routes.RenderErr(w, r, &ErrBanned{Ban: *ban})
+			return
+		}
+		if subH.Nsfw() && disceptoH.NsfwPref() == models.NsfwHide {
+			routes.tmpls.RenderHTML(w, "nsfwGate", struct {
+				Subdiscepto string
+				Essay       bool
+				LoggedIn    bool
+				Next        string
+			}{
+				subH.Name(),
+				false,
+				userH != nil,
+				r.URL.Path,
+			})
+			return
+		}
 		ctx := context.WithValue(r.Context(), SubdisceptoHCtxKey, subH)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -118,7 +148,7 @@ func (routes *Routes) JoinSubdiscepto(w http.ResponseWriter, r *http.Request) {
 func (routes *Routes) GetSubdisceptos(w http.ResponseWriter, r *http.Request) {
 	disceptoH := GetDisceptoH(r)
 	userH := GetUserH(r)
-	subs, err := routes.db.ListSubdisceptos(r.Context(), userH)
+	subs, err := routes.db.ListSubdisceptos(r.Context(), userH, disceptoH.NsfwPref())
 	if err != nil {
 		routes.HandleErr(w, r, err)
 		return
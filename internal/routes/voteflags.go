@@ -0,0 +1,46 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"gitlab.com/ranfdev/discepto/internal/models"
+)
+
+func (routes *Routes) SubVoteFlagsRouter(r chi.Router) {
+	r.Get("/", routes.GetVoteFlags)
+	r.Post("/{flagID}/nullify", routes.resolveVoteFlag(true))
+	r.Post("/{flagID}/dismiss", routes.resolveVoteFlag(false))
+}
+func (routes *Routes) GetVoteFlags(w http.ResponseWriter, r *http.Request) {
+	subH := GetSubdisceptoH(r)
+	flags, err := subH.ListVoteFlags(r.Context())
+	if err != nil {
+		routes.HandleErr(w, r, err)
+		return
+	}
+	routes.tmpls.RenderHTML(w, "voteflags", struct {
+		Flags    []models.VoteFlag
+		SubPerms models.Perms
+	}{
+		flags,
+		subH.Perms(),
+	})
+}
+func (routes *Routes) resolveVoteFlag(nullify bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subH := GetSubdisceptoH(r)
+		flagID, err := strconv.Atoi(chi.URLParam(r, "flagID"))
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		err = subH.ResolveVoteFlag(r.Context(), flagID, nullify)
+		if err != nil {
+			routes.HandleErr(w, r, err)
+			return
+		}
+		routes.GetVoteFlags(w, r)
+	}
+}
@@ -0,0 +1,99 @@
+// Package spam implements a naive Bayes classifier, telling spam apart from legit essays
+package spam
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	minTokenLen = 3
+	maxTokenLen = 30
+)
+
+// Classifier holds the token counts learned from the training samples.
+// It's meant to be stored as JSON.
+type Classifier struct {
+	SpamDocs   int            `json:"spam_docs"`
+	HamDocs    int            `json:"ham_docs"`
+	SpamTokens map[string]int `json:"spam_tokens"`
+	HamTokens  map[string]int `json:"ham_tokens"`
+	// Sum of the counts in SpamTokens and HamTokens
+	SpamTotal int `json:"spam_total"`
+	HamTotal  int `json:"ham_total"`
+}
+
+func New() *Classifier {
+	return &Classifier{
+		SpamTokens: map[string]int{},
+		HamTokens:  map[string]int{},
+	}
+}
+
+// Tokenize splits a text into lowercase words, ignoring the ones too short or too long
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		if l := len(w); l >= minTokenLen && l <= maxTokenLen {
+			tokens = append(tokens, w)
+		}
+	}
+	return tokens
+}
+
+func (c *Classifier) Train(text string, isSpam bool) {
+	tokens := Tokenize(text)
+	if isSpam {
+		c.SpamDocs++
+		c.SpamTotal += len(tokens)
+	} else {
+		c.HamDocs++
+		c.HamTotal += len(tokens)
+	}
+	for _, t := range tokens {
+		if isSpam {
+			c.SpamTokens[t]++
+		} else {
+			c.HamTokens[t]++
+		}
+	}
+}
+
+// Ready reports if the classifier has seen enough samples of both classes
+// to give meaningful scores
+func (c *Classifier) Ready(minDocs int) bool {
+	return c.SpamDocs >= minDocs && c.HamDocs >= minDocs
+}
+
+// Score returns the probability of the text being spam, between 0 and 1
+func (c *Classifier) Score(text string) float64 {
+	if c.SpamDocs == 0 || c.HamDocs == 0 {
+		return 0
+	}
+	vocabulary := len(c.SpamTokens)
+	for t := range c.HamTokens {
+		if _, ok := c.SpamTokens[t]; !ok {
+			vocabulary++
+		}
+	}
+
+	// Work with logarithms, to avoid underflows
+	docs := float64(c.SpamDocs + c.HamDocs)
+	logSpam := math.Log(float64(c.SpamDocs) / docs)
+	logHam := math.Log(float64(c.HamDocs) / docs)
+	seen := map[string]struct{}{}
+	for _, t := range Tokenize(text) {
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		// Laplace smoothing gives a small probability to unseen tokens
+		logSpam += math.Log(float64(c.SpamTokens[t]+1) / float64(c.SpamTotal+vocabulary))
+		logHam += math.Log(float64(c.HamTokens[t]+1) / float64(c.HamTotal+vocabulary))
+	}
+	return 1 / (1 + math.Exp(logHam-logSpam))
+}
@@ -0,0 +1,28 @@
+package spam
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	require := require.New(t)
+	require.Equal([]string{"buy", "cheap", "pills", "example", "com"}, Tokenize("Buy CHEAP pills at example.com!!! ok"))
+}
+func TestScore(t *testing.T) {
+	require := require.New(t)
+	c := New()
+	c.Train("Buy cheap pills now, best price", true)
+	c.Train("Cheap watches, buy now", true)
+	c.Train("Win money fast, click now", true)
+	c.Train("I think the argument about taxes misses the point", false)
+	c.Train("The study cited in the essay has a small sample", false)
+	c.Train("This refutes the thesis with better sources", false)
+
+	require.True(c.Ready(3))
+	require.False(c.Ready(4))
+	require.Greater(c.Score("buy cheap pills"), 0.9)
+	require.Less(c.Score("the thesis of the essay misses better sources"), 0.1)
+	require.Equal(0.0, New().Score("buy cheap pills"))
+}
@@ -66,12 +66,13 @@ func ParseFormStruct(r *http.Request, into interface{}) error {
 			if err != nil {
 				return err
 			}
-			v.Field(i).Set(reflect.ValueOf(intg))
+			v.Field(i).SetInt(int64(intg))
 		case reflect.Bool:
 			isChecked := r.FormValue(name) == "on"
-			v.Field(i).Set(reflect.ValueOf(isChecked))
+			v.Field(i).SetBool(isChecked)
 		case reflect.String:
-			v.Field(i).Set(reflect.ValueOf(r.FormValue(name)))
+			// SetString also works for named string types
+			v.Field(i).SetString(r.FormValue(name))
 		}
 	}
 	return nil
@@ -0,0 +1,2 @@
+DROP FUNCTION vote_weight;
+ALTER TABLE subdisceptos DROP COLUMN weighted_votes;
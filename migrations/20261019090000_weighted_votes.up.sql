@@ -0,0 +1,14 @@
+ALTER TABLE subdisceptos ADD COLUMN weighted_votes boolean NOT NULL DEFAULT false;
+
+-- Weight of a vote cast by "voter" inside "sub".
+-- The weight grows with the voter's local reputation (upvotes received on essays
+-- posted in the same subdiscepto). Reputation is capped at 1000 and log-scaled,
+-- so a vote weighs at least 1 and at most 1 + ln(1001) (~7.9).
+CREATE FUNCTION vote_weight(voter int, sub varchar) RETURNS double precision AS $$
+	SELECT 1 + ln(1 + LEAST(COUNT(*), 1000)::double precision)
+	FROM votes
+	JOIN essays ON essays.id = votes.essay_id
+	WHERE essays.attributed_to_id = voter
+		AND essays.posted_in = sub
+		AND votes.vote_type = 'upvote'
+$$ LANGUAGE SQL STABLE;
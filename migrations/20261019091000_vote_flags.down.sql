@@ -0,0 +1,16 @@
+DELETE FROM role_perms WHERE permission = 'review_votes';
+
+CREATE OR REPLACE FUNCTION vote_weight(voter int, sub varchar) RETURNS double precision AS $$
+	SELECT 1 + ln(1 + LEAST(COUNT(*), 1000)::double precision)
+	FROM votes
+	JOIN essays ON essays.id = votes.essay_id
+	WHERE essays.attributed_to_id = voter
+		AND essays.posted_in = sub
+		AND votes.vote_type = 'upvote'
+$$ LANGUAGE SQL STABLE;
+
+DROP TABLE vote_flags;
+DROP TYPE vote_flag_type;
+ALTER TABLE votes DROP COLUMN nullified;
+ALTER TABLE votes DROP COLUMN created_at;
+ALTER TABLE users DROP COLUMN signup_ip;
@@ -0,0 +1,34 @@
+ALTER TABLE users ADD COLUMN signup_ip inet;
+ALTER TABLE votes ADD COLUMN created_at timestamp NOT NULL DEFAULT NOW();
+-- Nullified votes are kept (the user can't vote again), but aren't counted
+ALTER TABLE votes ADD COLUMN nullified boolean NOT NULL DEFAULT false;
+
+CREATE TYPE vote_flag_type AS ENUM ('co_voting', 'burst', 'shared_ip');
+
+-- Suspicious groups of voters, found by a periodic analysis of the votes table
+CREATE TABLE vote_flags (
+	id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	subdiscepto varchar(50) NOT NULL REFERENCES subdisceptos(name) ON DELETE CASCADE,
+	flag_type vote_flag_type NOT NULL,
+	-- Sorted ids of the flagged accounts
+	user_ids int[] NOT NULL,
+	details varchar(255) NOT NULL,
+	created_at timestamp NOT NULL DEFAULT NOW(),
+	resolved_at timestamp,
+	nullified boolean NOT NULL DEFAULT false,
+	UNIQUE(subdiscepto, flag_type, user_ids)
+);
+
+CREATE OR REPLACE FUNCTION vote_weight(voter int, sub varchar) RETURNS double precision AS $$
+	SELECT 1 + ln(1 + LEAST(COUNT(*), 1000)::double precision)
+	FROM votes
+	JOIN essays ON essays.id = votes.essay_id
+	WHERE essays.attributed_to_id = voter
+		AND essays.posted_in = sub
+		AND votes.vote_type = 'upvote'
+		AND NOT votes.nullified
+$$ LANGUAGE SQL STABLE;
+
+-- The site admins and the admins of the existing subdisceptos review the flagged votes
+INSERT INTO role_perms (role_id, permission)
+SELECT id, 'review_votes' FROM roles WHERE preset AND name = 'admin';
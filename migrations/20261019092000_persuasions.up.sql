@@ -0,0 +1,7 @@
+-- A user marking a reply as having changed their view
+CREATE TABLE persuasions (
+	essay_id int REFERENCES essays(id) ON DELETE CASCADE,
+	user_id int REFERENCES users(id) ON DELETE CASCADE,
+	created_at timestamp NOT NULL DEFAULT NOW(),
+	PRIMARY KEY(essay_id, user_id)
+);
@@ -0,0 +1,5 @@
+DELETE FROM role_perms WHERE permission = 'manage_badges';
+
+DROP TABLE user_badges;
+DROP TABLE badges;
+DROP TYPE badge_rule;
@@ -0,0 +1,32 @@
+CREATE TYPE badge_rule AS ENUM ('accepted_correction', 'supports_received', 'membership_days');
+
+CREATE TABLE badges (
+	id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	name varchar(50) NOT NULL,
+	description varchar(255) NOT NULL DEFAULT '',
+	-- NULL for site-wide badges
+	subdiscepto varchar(50) REFERENCES subdisceptos(name) ON DELETE CASCADE,
+	-- NULL for badges awarded by hand
+	rule badge_rule,
+	threshold int NOT NULL DEFAULT 0,
+	UNIQUE(subdiscepto, name)
+);
+-- NULLs never collide in the constraint above
+CREATE UNIQUE INDEX badges_site_name_idx ON badges (name) WHERE subdiscepto IS NULL;
+
+CREATE TABLE user_badges (
+	badge_id int REFERENCES badges(id) ON DELETE CASCADE,
+	user_id int REFERENCES users(id) ON DELETE CASCADE,
+	awarded_at timestamp NOT NULL DEFAULT NOW(),
+	PRIMARY KEY(badge_id, user_id)
+);
+
+INSERT INTO badges (name, description, rule, threshold)
+VALUES
+	('Open door', 'Wrote a reply that changed the view of the essay author', 'accepted_correction', 1),
+	('Well supported', 'Received 100 supporting replies', 'supports_received', 100),
+	('Veteran', 'Member for one year', 'membership_days', 365);
+
+-- Badges are awarded by the admins, of the site and of each existing subdiscepto
+INSERT INTO role_perms (role_id, permission)
+SELECT id, 'manage_badges' FROM roles WHERE preset AND name = 'admin';
@@ -0,0 +1,7 @@
+ALTER TABLE reports DROP COLUMN closed_at;
+ALTER TABLE reports DROP COLUMN resolution;
+ALTER TABLE reports DROP COLUMN moderator_id;
+ALTER TABLE reports DROP COLUMN created_at;
+ALTER TABLE reports DROP COLUMN state;
+ALTER TABLE reports DROP COLUMN flag_type;
+DROP TYPE report_state;
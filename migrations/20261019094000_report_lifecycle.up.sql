@@ -0,0 +1,11 @@
+CREATE TYPE report_state AS ENUM ('open', 'claimed', 'resolved', 'dismissed');
+
+-- Reports created before categories existed are considered offensive
+ALTER TABLE reports ADD COLUMN flag_type flag_type NOT NULL DEFAULT 'offensive';
+ALTER TABLE reports ALTER COLUMN flag_type DROP DEFAULT;
+ALTER TABLE reports ADD COLUMN state report_state NOT NULL DEFAULT 'open';
+ALTER TABLE reports ADD COLUMN created_at timestamp NOT NULL DEFAULT NOW();
+-- The moderator who claimed or closed the report
+ALTER TABLE reports ADD COLUMN moderator_id int REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE reports ADD COLUMN resolution varchar(500);
+ALTER TABLE reports ADD COLUMN closed_at timestamp;
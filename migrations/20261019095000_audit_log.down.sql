@@ -0,0 +1,4 @@
+DELETE FROM role_perms WHERE permission = 'view_audit_log';
+
+DROP TABLE audit_log;
+DROP FUNCTION forbid_audit_log_changes;
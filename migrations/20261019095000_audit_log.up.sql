@@ -0,0 +1,27 @@
+-- Append-only log of privileged actions.
+-- There are no foreign keys: entries must outlive the users and domains they refer to.
+CREATE TABLE audit_log (
+	id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	domain int NOT NULL,
+	actor_id int,
+	action varchar(50) NOT NULL,
+	target varchar(255) NOT NULL,
+	before jsonb,
+	after jsonb,
+	created_at timestamp NOT NULL DEFAULT NOW()
+);
+CREATE INDEX audit_log_domain_idx ON audit_log (domain, id);
+
+CREATE FUNCTION forbid_audit_log_changes() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only
+BEFORE UPDATE OR DELETE ON audit_log
+FOR EACH ROW EXECUTE FUNCTION forbid_audit_log_changes();
+
+-- Every admin can read the audit log of their own domain
+INSERT INTO role_perms (role_id, permission)
+SELECT id, 'view_audit_log' FROM roles WHERE preset AND name = 'admin';
@@ -0,0 +1,12 @@
+CREATE TABLE bans (
+	id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	-- The roledomain of a subdiscepto, or -123 for global bans
+	domain int NOT NULL REFERENCES roledomains(id) ON DELETE CASCADE,
+	user_id int NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	banned_by int REFERENCES users(id) ON DELETE SET NULL,
+	reason varchar(500) NOT NULL,
+	created_at timestamp NOT NULL DEFAULT NOW(),
+	-- NULL for permanent bans
+	expires_at timestamp
+);
+CREATE INDEX bans_user_domain_idx ON bans (user_id, domain);
@@ -0,0 +1,9 @@
+DELETE FROM role_perms WHERE permission = 'remove_essay';
+
+ALTER TABLE essays
+	DROP COLUMN removed_at,
+	DROP COLUMN removed_by,
+	DROP COLUMN removal_reason,
+	DROP COLUMN purged_at;
+
+DROP TABLE removal_reasons;
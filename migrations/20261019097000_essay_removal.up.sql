@@ -0,0 +1,18 @@
+-- Reasons a moderator can choose from when removing an essay
+CREATE TABLE removal_reasons (
+	id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	subdiscepto varchar(50) NOT NULL REFERENCES subdisceptos(name) ON DELETE CASCADE,
+	text varchar(200) NOT NULL
+);
+
+ALTER TABLE essays
+	ADD COLUMN removed_at timestamp,
+	ADD COLUMN removed_by int REFERENCES users(id) ON DELETE SET NULL,
+	ADD COLUMN removal_reason varchar(200),
+	-- Set when the content of a removed essay has been wiped, after the retention period
+	ADD COLUMN purged_at timestamp;
+CREATE INDEX essays_removed_at_idx ON essays (removed_at) WHERE removed_at IS NOT NULL;
+
+-- Removing essays is a moderation tool, for the admins of the site and of the existing subdisceptos
+INSERT INTO role_perms (role_id, permission)
+SELECT id, 'remove_essay' FROM roles WHERE preset AND name = 'admin';
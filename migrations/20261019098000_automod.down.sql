@@ -0,0 +1,10 @@
+DELETE FROM role_perms WHERE permission = 'manage_automod';
+
+DELETE FROM reports WHERE from_user_id IS NULL;
+ALTER TABLE reports ALTER COLUMN from_user_id SET NOT NULL;
+
+ALTER TABLE essays
+	DROP COLUMN held_at,
+	DROP COLUMN held_reason;
+
+DROP TABLE automod_rules;
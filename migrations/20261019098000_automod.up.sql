@@ -0,0 +1,30 @@
+CREATE TABLE automod_rules (
+	id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	subdiscepto varchar(50) NOT NULL REFERENCES subdisceptos(name) ON DELETE CASCADE,
+	name varchar(50) NOT NULL,
+	-- thesis, content, tags or link_domain
+	field varchar(20) NOT NULL,
+	-- keyword or regex
+	match_type varchar(20) NOT NULL,
+	pattern varchar(255) NOT NULL,
+	-- When set, the rule only applies to accounts younger than this
+	max_account_age_days int,
+	-- reject, hold, report or tag
+	action varchar(20) NOT NULL,
+	-- The tag to add, or the category of the report
+	action_arg varchar(30),
+	enabled boolean NOT NULL DEFAULT true,
+	created_at timestamp NOT NULL DEFAULT NOW()
+);
+
+-- Held essays are hidden until a moderator approves them
+ALTER TABLE essays
+	ADD COLUMN held_at timestamp,
+	ADD COLUMN held_reason varchar(200);
+
+-- Reports filed by AutoMod have no author
+ALTER TABLE reports ALTER COLUMN from_user_id DROP NOT NULL;
+
+-- Admins configure AutoMod. New subdisceptos get it from PermsSubAdmin
+INSERT INTO role_perms (role_id, permission)
+SELECT id, 'manage_automod' FROM roles WHERE preset AND name = 'admin';
@@ -0,0 +1,2 @@
+DROP TABLE spam_model;
+DROP TABLE spam_samples;
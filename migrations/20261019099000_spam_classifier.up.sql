@@ -0,0 +1,17 @@
+-- Moderator decisions the spam classifier learns from. The text is copied,
+-- because removed essays are purged after a while.
+CREATE TABLE spam_samples (
+	id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	essay_id int UNIQUE REFERENCES essays(id) ON DELETE SET NULL,
+	text text NOT NULL,
+	spam boolean NOT NULL,
+	labeled_at timestamp NOT NULL DEFAULT NOW()
+);
+
+-- The trained classifier. There's only one per instance.
+CREATE TABLE spam_model (
+	id int PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+	model jsonb NOT NULL,
+	samples int NOT NULL,
+	trained_at timestamp NOT NULL DEFAULT NOW()
+);
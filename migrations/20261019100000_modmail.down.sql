@@ -0,0 +1,5 @@
+DELETE FROM role_perms WHERE permission = 'manage_modmail';
+
+DROP TABLE modmail_reads;
+DROP TABLE modmail_messages;
+DROP TABLE modmail_threads;
@@ -0,0 +1,33 @@
+-- Private conversations between a user and the moderators of a subdiscepto
+CREATE TABLE modmail_threads (
+	id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	subdiscepto varchar(50) NOT NULL REFERENCES subdisceptos(name) ON DELETE CASCADE,
+	user_id int NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	subject varchar(150) NOT NULL,
+	created_at timestamp NOT NULL DEFAULT NOW(),
+	updated_at timestamp NOT NULL DEFAULT NOW(),
+	archived_at timestamp
+);
+CREATE INDEX modmail_threads_subdiscepto_idx ON modmail_threads (subdiscepto, updated_at);
+
+CREATE TABLE modmail_messages (
+	id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	thread_id int NOT NULL REFERENCES modmail_threads(id) ON DELETE CASCADE,
+	author_id int REFERENCES users(id) ON DELETE SET NULL,
+	-- Messages written by a moderator are shown as written by the whole team
+	from_mods boolean NOT NULL,
+	text varchar(5000) NOT NULL,
+	created_at timestamp NOT NULL DEFAULT NOW()
+);
+
+-- When each participant last read a thread
+CREATE TABLE modmail_reads (
+	thread_id int REFERENCES modmail_threads(id) ON DELETE CASCADE,
+	user_id int REFERENCES users(id) ON DELETE CASCADE,
+	read_at timestamp NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (thread_id, user_id)
+);
+
+-- Admins answer the modmail of their subdiscepto
+INSERT INTO role_perms (role_id, permission)
+SELECT id, 'manage_modmail' FROM roles WHERE preset AND name = 'admin';
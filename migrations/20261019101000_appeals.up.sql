@@ -0,0 +1,23 @@
+-- Users contesting a ban or the removal of one of their essays
+CREATE TABLE appeals (
+	id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	-- Exactly one of ban_id and essay_id is set
+	ban_id int UNIQUE REFERENCES bans(id) ON DELETE CASCADE,
+	essay_id int REFERENCES essays(id) ON DELETE CASCADE,
+	domain int NOT NULL REFERENCES roledomains(id) ON DELETE CASCADE,
+	user_id int NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	-- The moderator who acted, who can't review the appeal
+	acted_by int REFERENCES users(id) ON DELETE SET NULL,
+	-- Reason of the ban or of the removal, when the appeal was filed
+	reason varchar(500) NOT NULL,
+	statement varchar(2000) NOT NULL,
+	created_at timestamp NOT NULL DEFAULT NOW(),
+	reviewed_by int REFERENCES users(id) ON DELETE SET NULL,
+	reviewed_at timestamp,
+	outcome varchar(20) CHECK (outcome IN ('upheld', 'overturned')),
+	response varchar(500),
+	CHECK ((ban_id IS NULL) <> (essay_id IS NULL))
+);
+CREATE INDEX appeals_domain_idx ON appeals (domain, reviewed_at);
+-- An essay can be removed again after an appeal, so only one open appeal per essay
+CREATE UNIQUE INDEX appeals_open_essay_idx ON appeals (essay_id) WHERE reviewed_at IS NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE subdisceptos DROP COLUMN premod_min_karma;
+ALTER TABLE subdisceptos DROP COLUMN premod_min_days;
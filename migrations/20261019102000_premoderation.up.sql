@@ -0,0 +1,3 @@
+-- Essays of members newer or with less karma than these are held for approval. 0 disables the check.
+ALTER TABLE subdisceptos ADD COLUMN premod_min_days int NOT NULL DEFAULT 0;
+ALTER TABLE subdisceptos ADD COLUMN premod_min_karma int NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE essays DROP COLUMN nsfw;
+ALTER TABLE users DROP COLUMN nsfw_pref;
@@ -0,0 +1,4 @@
+-- How the user wants NSFW content to be shown
+ALTER TABLE users ADD COLUMN nsfw_pref varchar(10) NOT NULL DEFAULT 'hide' CHECK (nsfw_pref IN ('hide', 'blur', 'show'));
+-- Essays of NSFW subdisceptos are always NSFW, the others can be marked individually
+ALTER TABLE essays ADD COLUMN nsfw boolean NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+DELETE FROM role_perms WHERE permission = 'manage_invites';
+
+DROP TABLE invites;
@@ -0,0 +1,24 @@
+-- Links and direct invitations to join a subdiscepto, even a private one
+CREATE TABLE invites (
+	id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	subdiscepto varchar(50) NOT NULL REFERENCES subdisceptos(name) ON DELETE CASCADE,
+	-- Set for invite links
+	token varchar(64) UNIQUE,
+	-- Set for direct invitations
+	invited_user_id int REFERENCES users(id) ON DELETE CASCADE,
+	-- Role assigned on top of the common one
+	role_id int REFERENCES roles(id) ON DELETE CASCADE,
+	created_by int REFERENCES users(id) ON DELETE SET NULL,
+	created_at timestamp NOT NULL DEFAULT NOW(),
+	expires_at timestamp,
+	-- Null for unlimited uses
+	max_uses int CHECK (max_uses > 0),
+	uses int NOT NULL DEFAULT 0,
+	CHECK ((token IS NULL) <> (invited_user_id IS NULL))
+);
+CREATE INDEX invites_subdiscepto_idx ON invites (subdiscepto);
+CREATE INDEX invites_invited_user_id_idx ON invites (invited_user_id);
+
+-- Admins invite people, mostly into their private subdisceptos
+INSERT INTO role_perms (role_id, permission)
+SELECT id, 'manage_invites' FROM roles WHERE preset AND name = 'admin';
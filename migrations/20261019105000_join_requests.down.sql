@@ -0,0 +1,4 @@
+DELETE FROM role_perms WHERE permission = 'manage_members';
+
+DROP TABLE join_requests;
+ALTER TABLE subdisceptos DROP COLUMN join_mode;
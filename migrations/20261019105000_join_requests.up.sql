@@ -0,0 +1,21 @@
+-- How users become members: freely, by request or by invitation only
+ALTER TABLE subdisceptos ADD COLUMN join_mode varchar(10) NOT NULL DEFAULT 'open' CHECK (join_mode IN ('open', 'request', 'invite'));
+-- Private subdisceptos could never be joined freely
+UPDATE subdisceptos SET join_mode = 'invite' WHERE NOT public;
+
+CREATE TABLE join_requests (
+	id int PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+	subdiscepto varchar(50) NOT NULL REFERENCES subdisceptos(name) ON DELETE CASCADE,
+	user_id int NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	message varchar(500) NOT NULL,
+	created_at timestamp NOT NULL DEFAULT NOW(),
+	decided_by int REFERENCES users(id) ON DELETE SET NULL,
+	decided_at timestamp,
+	approved boolean
+);
+-- One pending request per user
+CREATE UNIQUE INDEX join_requests_pending_idx ON join_requests (subdiscepto, user_id) WHERE decided_at IS NULL;
+
+-- Admins decide on the join requests
+INSERT INTO role_perms (role_id, permission)
+SELECT id, 'manage_members' FROM roles WHERE preset AND name = 'admin';
@@ -0,0 +1,3 @@
+-- Null for permanent assignments
+ALTER TABLE user_roles ADD COLUMN expires_at timestamp;
+CREATE INDEX user_roles_expires_at_idx ON user_roles (expires_at) WHERE expires_at IS NOT NULL;
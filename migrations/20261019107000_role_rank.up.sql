@@ -0,0 +1,4 @@
+-- Users can act only on users whose highest role ranks lower than their own
+ALTER TABLE roles ADD COLUMN rank int NOT NULL DEFAULT 0;
+UPDATE roles SET rank = 100 WHERE preset AND name = 'admin';
+UPDATE roles SET rank = 1 WHERE name NOT IN ('admin', 'common', 'common-after-rejoin');
@@ -0,0 +1,2 @@
+DELETE FROM role_perms WHERE deny;
+ALTER TABLE role_perms DROP COLUMN deny;
@@ -0,0 +1,2 @@
+-- A role can deny a permission, instead of granting it. Denies win over grants.
+ALTER TABLE role_perms ADD COLUMN deny boolean NOT NULL DEFAULT false;
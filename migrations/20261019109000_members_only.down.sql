@@ -0,0 +1,3 @@
+DELETE FROM role_perms WHERE permission = 'read_essay';
+
+ALTER TABLE essays DROP COLUMN members_only;
@@ -0,0 +1,14 @@
+-- Members-only essays can be read only with the read_essay permission,
+-- even inside a public subdiscepto
+ALTER TABLE essays ADD COLUMN members_only boolean NOT NULL DEFAULT false;
+
+INSERT INTO role_perms (role_id, permission)
+VALUES (-123, 'read_essay');
+
+-- Members and admins of every existing subdiscepto can read members-only essays
+INSERT INTO role_perms (role_id, permission)
+SELECT roles.id, 'read_essay'
+FROM roles
+JOIN roledomains ON roledomains.id = roles.roledomain_id
+WHERE roledomains.domain_type = 'subdiscepto' AND roles.name IN ('common', 'admin')
+ON CONFLICT DO NOTHING;
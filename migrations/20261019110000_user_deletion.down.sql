@@ -0,0 +1,2 @@
+DELETE FROM essays WHERE attributed_to_id IS NULL;
+ALTER TABLE essays ALTER COLUMN attributed_to_id SET NOT NULL;
@@ -0,0 +1,2 @@
+-- Essays of deleted users stay, with no author
+ALTER TABLE essays ALTER COLUMN attributed_to_id DROP NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN session_generation;
+
+DROP TABLE password_resets;
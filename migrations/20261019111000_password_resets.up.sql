@@ -0,0 +1,12 @@
+-- Tokens mailed to reset a forgotten password. Only their hash is stored.
+CREATE TABLE password_resets (
+	token_hash varchar(64) PRIMARY KEY,
+	user_id int NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at timestamp NOT NULL DEFAULT NOW(),
+	expires_at timestamp NOT NULL,
+	used_at timestamp
+);
+CREATE INDEX password_resets_user_id_idx ON password_resets (user_id);
+
+-- Incremented to sign the user out of every session
+ALTER TABLE users ADD COLUMN session_generation int NOT NULL DEFAULT 0;
@@ -0,0 +1,16 @@
+-- Users can't post nor vote until they verify their email
+ALTER TABLE users ADD COLUMN email_verified_at timestamp;
+-- Existing accounts are trusted
+UPDATE users SET email_verified_at = NOW();
+
+-- Tokens mailed to verify an address. Only their hash is stored.
+CREATE TABLE email_verifications (
+	token_hash varchar(64) PRIMARY KEY,
+	user_id int NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	-- Replaces the email of the user once verified, when changing it
+	email varchar(100) NOT NULL,
+	created_at timestamp NOT NULL DEFAULT NOW(),
+	expires_at timestamp NOT NULL,
+	used_at timestamp
+);
+CREATE INDEX email_verifications_user_id_idx ON email_verifications (user_id);
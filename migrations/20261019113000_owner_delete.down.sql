@@ -0,0 +1,2 @@
+INSERT INTO role_perms (role_id, permission)
+SELECT id, 'delete_essay' FROM roles WHERE preset AND name = 'admin';
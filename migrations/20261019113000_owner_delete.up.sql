@@ -0,0 +1,7 @@
+-- Essays are hard deleted only by their authors. Moderators remove them instead,
+-- keeping the replies. Custom roles keep the permission, if they were given it.
+DELETE FROM role_perms
+USING roles
+WHERE role_perms.role_id = roles.id
+	AND roles.preset AND roles.name = 'admin'
+	AND role_perms.permission = 'delete_essay';